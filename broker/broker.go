@@ -0,0 +1,450 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/config"
+	"github.com/cikupin/saga-simple-example/storage"
+	"github.com/cikupin/saga-simple-example/uid"
+)
+
+const (
+	// channelLogPrefix is prepended to channel name and segment number to
+	// build logID of channel messages
+	channelLogPrefix = "channel_"
+	// offsetLogPrefix is prepended to channel name, consumer name and
+	// segment number to build logID of consumer position
+	offsetLogPrefix = "offset_"
+
+	// defaultSegmentSize is number of messages kept in one segment of
+	// channel log
+	defaultSegmentSize = 1000
+)
+
+type (
+	// Message defines command sent to participant service, or its reply.
+	// Command calls Endpoint of participant with Header and Data, and its
+	// reply is sent to ReplyTo channel with CorrelationID set to command
+	// ID. Both carry saga ID and step of the call.
+	Message struct {
+		ID            string            `json:"id"`
+		CorrelationID string            `json:"correlation_id,omitempty"`
+		SagaID        string            `json:"saga_id,omitempty"`
+		Step          string            `json:"step,omitempty"`
+		Endpoint      string            `json:"endpoint,omitempty"`
+		ReplyTo       string            `json:"reply_to,omitempty"`
+		Header        map[string]string `json:"header,omitempty"`
+		Data          json.RawMessage   `json:"data,omitempty"`
+		StatusCode    int               `json:"status_code,omitempty"`
+		Body          string            `json:"body,omitempty"`
+		Time          time.Time         `json:"time"`
+	}
+
+	// Handler is called for every message received by consumer
+	Handler func(m Message) error
+
+	// Broker delivers messages through named channels. Every consumer of
+	// a channel receives every message in send order, and message whose
+	// handler fails is delivered again.
+	Broker interface {
+		Send(channel string, m Message) error
+		Subscribe(channel, consumer string, handler Handler)
+		Close() error
+	}
+
+	// LogBroker defines broker kept in a storage. Every channel is a log
+	// split into segments of segmentSize messages, and every consumer keeps
+	// its position as segment and index within it, so it reads only the
+	// segment it is in. Segment which every consumer of the channel has
+	// left is removed. File storage shared by several processes lets them
+	// exchange messages, as long as every channel is sent to by one process
+	// and consumed from one process, since new consumer is registered and
+	// segments are removed under the broker lock. Consumer position is
+	// persisted, so messages sent while consumer is offline are delivered
+	// once it is back.
+	LogBroker struct {
+		storage      storage.Storage
+		pollInterval time.Duration
+		segmentSize  int
+
+		mu    sync.Mutex
+		heads map[string]*position
+
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	}
+
+	// position defines message within channel log. For channel head, index
+	// is number of messages in its last segment.
+	position struct {
+		segment int
+		index   int
+	}
+)
+
+// New will create new broker kept in given storage. Consumers look for
+// new messages every pollInterval.
+func New(s storage.Storage, pollInterval time.Duration) *LogBroker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LogBroker{
+		storage:      s,
+		pollInterval: pollInterval,
+		segmentSize:  defaultSegmentSize,
+		heads:        make(map[string]*position),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Open will create broker kept in selected storage engine. Broker kept in
+// memory storage is seen only by the process which created it.
+func Open(engine, dir string, pollInterval time.Duration) (*LogBroker, error) {
+	switch engine {
+	case storage.EngineMemory:
+		return New(storage.NewMemoryStorage(), pollInterval), nil
+	case storage.EngineFile:
+		s, err := storage.NewFileStorage(dir)
+		if err != nil {
+			return nil, err
+		}
+		return New(s, pollInterval), nil
+	default:
+		return nil, fmt.Errorf("unknown message broker storage engine %s", engine)
+	}
+}
+
+// FromConfig will create broker with given settings. It returns nil
+// when broker is disabled. Orchestrator and participants run as separate
+// processes, so memory storage engine is refused.
+func FromConfig(cfg config.Broker) (*LogBroker, error) {
+	if cfg.Engine == "" {
+		return nil, nil
+	}
+	if cfg.Engine == storage.EngineMemory {
+		return nil, fmt.Errorf("message broker kept in %s storage engine is not shared between processes, use %s", storage.EngineMemory, storage.EngineFile)
+	}
+	return Open(cfg.Engine, cfg.Dir, time.Duration(cfg.PollInterval))
+}
+
+// Send will append message into given channel. Message without ID is
+// given a new one.
+func (b *LogBroker) Send(channel string, m Message) error {
+	if m.ID == "" {
+		id, err := uid.New()
+		if err != nil {
+			return err
+		}
+		m.ID = id
+	}
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+
+	messageBytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	head, err := b.head(channel)
+	if err != nil {
+		return err
+	}
+	if head.index >= b.segmentSize {
+		head.segment++
+		head.index = 0
+	}
+
+	if err = b.storage.AppendLog(segmentLogID(channel, head.segment), string(messageBytes)); err != nil {
+		return err
+	}
+	head.index++
+	return nil
+}
+
+// Messages will return every message of given channel which is not yet
+// removed, in send order
+func (b *LogBroker) Messages(channel string) ([]Message, error) {
+	segments, err := b.segments(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []Message
+	for _, segment := range segments {
+		segmentMessages, err := b.read(channel, segment)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, segmentMessages...)
+	}
+	return messages, nil
+}
+
+// Subscribe will start delivering messages of given channel to handler
+// under given consumer name, starting after the last message delivered
+// to the same consumer. New consumer starts from the earliest message
+// which is not yet removed.
+func (b *LogBroker) Subscribe(channel, consumer string, handler Handler) {
+	// position is registered before Subscribe returns, so segments sent
+	// from now on are kept until consumer reads them
+	pos, err := b.position(channel, consumer)
+	if err != nil {
+		log.Printf("[%s] failed to read %s position : %s\n", consumer, channel, err.Error())
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.consume(channel, consumer, pos, handler)
+	}()
+}
+
+// Close will stop every consumer and release storage
+func (b *LogBroker) Close() error {
+	b.cancel()
+	b.wg.Wait()
+	return b.storage.Close()
+}
+
+func (b *LogBroker) consume(channel, consumer string, pos position, handler Handler) {
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		pos = b.deliver(channel, consumer, pos, handler)
+
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver will hand messages of the segment consumer is in over to
+// handler, moving on to the next segment once the current one is full and
+// every message in it is delivered. It returns position of the first
+// message which is not yet delivered.
+func (b *LogBroker) deliver(channel, consumer string, pos position, handler Handler) position {
+	for {
+		messages, err := b.read(channel, pos.segment)
+		if err != nil {
+			log.Printf("[%s] failed to read %s messages : %s\n", consumer, channel, err.Error())
+			return pos
+		}
+
+		for ; pos.index < len(messages); pos.index++ {
+			m := messages[pos.index]
+			if err = handler(m); err != nil {
+				log.Printf("[%s] failed to handle %s message %s, will retry : %s\n", consumer, channel, m.ID, err.Error())
+				return pos
+			}
+			err = b.storage.AppendLog(offsetLogID(channel, consumer, pos.segment), strconv.Itoa(pos.index+1))
+			if err != nil {
+				log.Printf("[%s] failed to save position : %s\n", consumer, err.Error())
+			}
+		}
+
+		// sender starts the next segment only after this one is full
+		if pos.index < b.segmentSize {
+			return pos
+		}
+
+		next := position{segment: pos.segment + 1}
+		if err = b.storage.AppendLog(offsetLogID(channel, consumer, next.segment), "0"); err != nil {
+			log.Printf("[%s] failed to save position : %s\n", consumer, err.Error())
+			return pos
+		}
+		if err = b.storage.Cleanup(offsetLogID(channel, consumer, pos.segment)); err != nil {
+			log.Printf("[%s] failed to remove position : %s\n", consumer, err.Error())
+		}
+		pos = next
+
+		if err = b.trim(channel); err != nil {
+			log.Printf("[%s] failed to remove consumed %s messages : %s\n", consumer, channel, err.Error())
+		}
+	}
+}
+
+// head returns position after the last message of given channel. It is
+// looked up in storage once, then kept by Send.
+func (b *LogBroker) head(channel string) (*position, error) {
+	if head, ok := b.heads[channel]; ok {
+		return head, nil
+	}
+
+	head := &position{}
+	segments, err := b.segments(channel)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		head.segment = segments[len(segments)-1]
+		data, err := b.storage.Lookup(segmentLogID(channel, head.segment))
+		if err != nil {
+			return nil, err
+		}
+		head.index = len(data)
+	}
+
+	b.heads[channel] = head
+	return head, nil
+}
+
+// position returns position of the first message of given channel which
+// is not yet delivered to consumer. Consumer seen for the first time is
+// placed at the earliest segment, and its position is saved at once under
+// the lock held by trim, so the segment is not removed before consumer
+// reads it.
+func (b *LogBroker) position(channel, consumer string) (position, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	positions, err := b.positions(channel)
+	if err != nil {
+		return position{}, err
+	}
+	if segment, ok := positions[consumer]; ok {
+		data, err := b.storage.LastLog(offsetLogID(channel, consumer, segment))
+		if err != nil {
+			return position{segment: segment}, err
+		}
+		index, _ := strconv.Atoi(data)
+		return position{segment: segment, index: index}, nil
+	}
+
+	segments, err := b.segments(channel)
+	if err != nil {
+		return position{}, err
+	}
+	pos := position{}
+	if len(segments) > 0 {
+		pos.segment = segments[0]
+	}
+	return pos, b.storage.AppendLog(offsetLogID(channel, consumer, pos.segment), "0")
+}
+
+// positions returns segment every known consumer of given channel is in
+func (b *LogBroker) positions(channel string) (map[string]int, error) {
+	logIDs, err := b.storage.LogIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := offsetLogPrefix + channel + "_"
+	positions := make(map[string]int)
+	for _, logID := range logIDs {
+		if !strings.HasPrefix(logID, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(logID, prefix)
+		sep := strings.LastIndex(name, "_")
+		if sep < 0 {
+			continue
+		}
+		segment, err := strconv.Atoi(name[sep+1:])
+		if err != nil {
+			continue
+		}
+
+		// previous segment is left behind only when moving on failed
+		// halfway, so the later one wins
+		consumer := name[:sep]
+		if current, ok := positions[consumer]; !ok || segment > current {
+			positions[consumer] = segment
+		}
+	}
+	return positions, nil
+}
+
+// trim will remove segments of given channel which every known consumer
+// has left, except the last one
+func (b *LogBroker) trim(channel string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	positions, err := b.positions(channel)
+	if err != nil || len(positions) == 0 {
+		return err
+	}
+
+	first := -1
+	for _, segment := range positions {
+		if first < 0 || segment < first {
+			first = segment
+		}
+	}
+
+	segments, err := b.segments(channel)
+	if err != nil {
+		return err
+	}
+	// the last segment is kept, so sender restarted later goes on after it
+	for i := 0; i < len(segments)-1 && segments[i] < first; i++ {
+		if err = b.storage.Cleanup(segmentLogID(channel, segments[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segments returns numbers of every segment of given channel in order
+func (b *LogBroker) segments(channel string) ([]int, error) {
+	logIDs, err := b.storage.LogIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := channelLogPrefix + channel + "_"
+	var segments []int
+	for _, logID := range logIDs {
+		if !strings.HasPrefix(logID, prefix) {
+			continue
+		}
+		segment, err := strconv.Atoi(strings.TrimPrefix(logID, prefix))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+// read returns messages of given segment of channel
+func (b *LogBroker) read(channel string, segment int) ([]Message, error) {
+	data, err := b.storage.Lookup(segmentLogID(channel, segment))
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(data))
+	for _, d := range data {
+		var m Message
+		if err = json.Unmarshal([]byte(d), &m); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+func segmentLogID(channel string, segment int) string {
+	return fmt.Sprintf("%s%s_%08d", channelLogPrefix, channel, segment)
+}
+
+func offsetLogID(channel, consumer string, segment int) string {
+	return fmt.Sprintf("%s%s_%s_%08d", offsetLogPrefix, channel, consumer, segment)
+}
@@ -0,0 +1,154 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/httpclient"
+	"github.com/cikupin/saga-simple-example/storage"
+)
+
+// recorder records IDs of delivered messages
+type recorder struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (r *recorder) handle(m Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ids = append(r.ids, m.ID)
+	return nil
+}
+
+func (r *recorder) delivered() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.ids...)
+}
+
+func newTestBroker(s storage.Storage) *LogBroker {
+	b := New(s, 5*time.Millisecond)
+	b.segmentSize = 2
+	return b
+}
+
+func send(t *testing.T, b *LogBroker, channel string, from, to int) {
+	for i := from; i < to; i++ {
+		if err := b.Send(channel, Message{ID: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// subscribe registers consumer and delivers its messages synchronously,
+// so trimming does not depend on consumer goroutines
+func subscribe(t *testing.T, b *LogBroker, consumer string) (*recorder, func()) {
+	pos, err := b.position("ch", consumer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &recorder{}
+	return r, func() { pos = b.deliver("ch", consumer, pos, r.handle) }
+}
+
+func segments(t *testing.T, b *LogBroker) []int {
+	segments, err := b.segments("ch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segments
+}
+
+func TestConsumeTrimsSegments(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	b := newTestBroker(s)
+
+	a, deliverA := subscribe(t, b, "a")
+	send(t, b, "ch", 0, 7)
+	deliverA()
+	if want := []string{"0", "1", "2", "3", "4", "5", "6"}; !reflect.DeepEqual(a.delivered(), want) {
+		t.Fatalf("delivered %v, want %v", a.delivered(), want)
+	}
+	if got := segments(t, b); !reflect.DeepEqual(got, []int{3}) {
+		t.Fatalf("segments %v, want only the last one", got)
+	}
+
+	// restarted sender goes on after the last segment, and consumer
+	// registered before another one moves on keeps its segments
+	b = newTestBroker(s)
+	send(t, b, "ch", 7, 10)
+	fresh, deliverFresh := subscribe(t, b, "b")
+	resumed, deliverResumed := subscribe(t, b, "a")
+
+	deliverResumed()
+	if want := []string{"7", "8", "9"}; !reflect.DeepEqual(resumed.delivered(), want) {
+		t.Fatalf("resumed consumer delivered %v, want %v", resumed.delivered(), want)
+	}
+	if got := segments(t, b); !reflect.DeepEqual(got, []int{3, 4}) {
+		t.Fatalf("segments %v, want [3 4] kept for new consumer", got)
+	}
+
+	deliverFresh()
+	if want := []string{"6", "7", "8", "9"}; !reflect.DeepEqual(fresh.delivered(), want) {
+		t.Fatalf("new consumer delivered %v, want %v", fresh.delivered(), want)
+	}
+	if got := segments(t, b); !reflect.DeepEqual(got, []int{4}) {
+		t.Fatalf("segments %v, want [4]", got)
+	}
+
+	// consumer registered later starts at the earliest message kept
+	late, deliverLate := subscribe(t, b, "c")
+	deliverLate()
+	if want := []string{"8", "9"}; !reflect.DeepEqual(late.delivered(), want) {
+		t.Fatalf("late consumer delivered %v, want %v", late.delivered(), want)
+	}
+}
+
+func TestClientForgetsTimedOutCommand(t *testing.T) {
+	b := newTestBroker(storage.NewMemoryStorage())
+	defer b.Close()
+	c := NewClient(b, "item", "item-replies")
+
+	ctx := WithCorrelation(context.Background(), "saga", "step")
+	ctx = httpclient.WithHeader(ctx, "Idempotency-Key", "saga/step")
+
+	// participant is offline, so the first attempt times out
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := c.Post(timeoutCtx, "/item", nil, nil); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	Serve(b, "item", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+
+	var out struct{ OK bool }
+	if err := c.Post(ctx, "/item", nil, &out); err != nil || !out.OK {
+		t.Fatalf("got %v %v, want ok", out, err)
+	}
+
+	// reply of the timed out command arrives late and is dropped
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		waiting := len(c.waiting)
+		c.mu.Unlock()
+		if waiting == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d commands still waiting for reply", waiting)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
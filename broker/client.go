@@ -0,0 +1,166 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/cikupin/saga-simple-example/httpclient"
+	"github.com/cikupin/saga-simple-example/uid"
+)
+
+type (
+	// Client sends commands to participant service through broker and
+	// waits for their replies. Every attempt sends a new command carrying
+	// the same idempotency key, so participant which is temporarily
+	// offline replays outcome of the earlier command instead of processing
+	// it again, and reply arriving after its command timed out is ignored.
+	Client struct {
+		broker  Broker
+		channel string
+		replyTo string
+
+		mu      sync.Mutex
+		waiting map[string]chan Message
+	}
+
+	correlationKey struct{}
+
+	correlation struct {
+		sagaID string
+		step   string
+	}
+)
+
+// WithCorrelation returns context whose command is correlated with given
+// saga ID and step
+func WithCorrelation(ctx context.Context, sagaID, step string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, correlation{sagaID: sagaID, step: step})
+}
+
+// NewClient will create client sending commands into given channel and
+// receiving replies from replyTo channel
+func NewClient(b Broker, channel, replyTo string) *Client {
+	c := &Client{
+		broker:  b,
+		channel: channel,
+		replyTo: replyTo,
+		waiting: make(map[string]chan Message),
+	}
+	b.Subscribe(replyTo, replyTo, c.receive)
+	return c
+}
+
+// Post will send in as command calling given endpoint, and decode its
+// reply into out. Headers set with httpclient.WithHeader are sent with
+// the command, and failed reply is returned as httpclient.StatusError,
+// so callers handle every transport alike.
+func (c *Client) Post(ctx context.Context, path string, in, out interface{}) error {
+	id, replied, err := c.send(ctx, path, in)
+	if err != nil {
+		return err
+	}
+
+	var reply Message
+	select {
+	case <-ctx.Done():
+		c.forget(id)
+		return ctx.Err()
+	case reply = <-replied:
+	}
+
+	if reply.StatusCode < 200 || reply.StatusCode > 299 {
+		return &httpclient.StatusError{
+			Service:    c.channel,
+			Method:     http.MethodPost,
+			Path:       path,
+			StatusCode: reply.StatusCode,
+			Body:       reply.Body,
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err = json.Unmarshal([]byte(reply.Body), out); err != nil {
+		return &httpclient.DecodeError{Service: c.channel, Path: path, Err: err}
+	}
+	return nil
+}
+
+// Get is not served through broker
+func (c *Client) Get(ctx context.Context, path string, out interface{}) error {
+	return &httpclient.StatusError{
+		Service:    c.channel,
+		Method:     http.MethodGet,
+		Path:       path,
+		StatusCode: http.StatusNotImplemented,
+		Body:       fmt.Sprintf("%s is not served through message broker", path),
+	}
+}
+
+// send will send in as command calling given endpoint, returning its ID
+// and channel receiving its reply
+func (c *Client) send(ctx context.Context, path string, in interface{}) (string, chan Message, error) {
+	cor, _ := ctx.Value(correlationKey{}).(correlation)
+
+	payloadBytes, err := json.Marshal(in)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := uid.New()
+	if err != nil {
+		return "", nil, err
+	}
+
+	header := make(map[string]string)
+	for k, v := range httpclient.Headers(ctx) {
+		header[k] = v[0]
+	}
+
+	// reply may arrive before Send returns
+	replied := make(chan Message, 1)
+	c.mu.Lock()
+	c.waiting[id] = replied
+	c.mu.Unlock()
+
+	err = c.broker.Send(c.channel, Message{
+		ID:       id,
+		SagaID:   cor.sagaID,
+		Step:     cor.step,
+		Endpoint: path,
+		ReplyTo:  c.replyTo,
+		Header:   header,
+		Data:     payloadBytes,
+	})
+	if err != nil {
+		c.forget(id)
+		return "", nil, err
+	}
+	return id, replied, nil
+}
+
+// receive will hand reply over to command waiting for it. Reply of
+// unknown command, such as one sent before restart or one which timed
+// out, is ignored.
+func (c *Client) receive(m Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	replied, ok := c.waiting[m.CorrelationID]
+	if !ok {
+		return nil
+	}
+	delete(c.waiting, m.CorrelationID)
+	replied <- m
+	return nil
+}
+
+// forget will stop waiting for reply of given command
+func (c *Client) forget(id string) {
+	c.mu.Lock()
+	delete(c.waiting, id)
+	c.mu.Unlock()
+}
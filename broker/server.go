@@ -0,0 +1,57 @@
+package broker
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// Serve will pass every command received from given channel to handler
+// as HTTP request of its endpoint with its headers, so commands go through
+// the same idempotency, fault injection and chaos middlewares as HTTP
+// requests. Response is sent to ReplyTo channel of the command. Command
+// whose response is dropped gets no reply, as if it were lost.
+func Serve(b Broker, channel string, handler http.Handler) {
+	b.Subscribe(channel, channel, func(m Message) error {
+		statusCode, body, ok := serve(handler, m)
+		if !ok || m.ReplyTo == "" {
+			return nil
+		}
+
+		return b.Send(m.ReplyTo, Message{
+			CorrelationID: m.ID,
+			SagaID:        m.SagaID,
+			Step:          m.Step,
+			StatusCode:    statusCode,
+			Body:          body,
+		})
+	})
+}
+
+// serve will call handler with command. It returns false when handler
+// has aborted without response.
+func serve(handler http.Handler, m Message) (statusCode int, body string, ok bool) {
+	req, err := http.NewRequest(http.MethodPost, m.Endpoint, bytes.NewReader(m.Data))
+	if err != nil {
+		return http.StatusBadRequest, err.Error(), true
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range m.Header {
+		req.Header.Set(key, value)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if r != http.ErrAbortHandler {
+				log.Printf("[broker] %s : panic %v\n", m.Endpoint, r)
+			}
+			statusCode, body, ok = 0, "", false
+		}
+	}()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, strings.TrimSpace(rec.Body.String()), true
+}
@@ -0,0 +1,220 @@
+package chaos
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/fault"
+)
+
+// AdminPath is the endpoint showing and changing chaos settings at runtime
+const AdminPath = "/admin/chaos"
+
+type (
+	// Config defines chaos settings of participant service. Every rate is
+	// a probability between 0 and 1. FailureRate fails request without
+	// processing it, unless EndpointFailureRates has a rate for its path.
+	// Latency of every request is drawn uniformly between LatencyMin and
+	// LatencyMax. DropRate processes request and then drops its response,
+	// and DuplicateRate processes request twice as if it were delivered twice.
+	Config struct {
+		FailureRate          float64            `json:"failure_rate"`
+		EndpointFailureRates map[string]float64 `json:"endpoint_failure_rates,omitempty"`
+		LatencyMin           fault.Duration     `json:"latency_min"`
+		LatencyMax           fault.Duration     `json:"latency_max"`
+		DropRate             float64            `json:"drop_rate"`
+		DuplicateRate        float64            `json:"duplicate_rate"`
+	}
+
+	// Chaos injects random faults into participant service
+	Chaos struct {
+		mu     sync.Mutex
+		config Config
+		rand   *rand.Rand
+	}
+)
+
+// New will create chaos with given settings
+func New(config Config) (*Chaos, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Chaos{
+		config: config,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Validate returns error if any rate is not a probability or latency
+// range is empty
+func (c Config) Validate() error {
+	rates := map[string]float64{
+		"failure_rate":   c.FailureRate,
+		"drop_rate":      c.DropRate,
+		"duplicate_rate": c.DuplicateRate,
+	}
+	for path, rate := range c.EndpointFailureRates {
+		rates["endpoint_failure_rates "+path] = rate
+	}
+
+	for name, rate := range rates {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	if c.LatencyMin < 0 || c.LatencyMax < c.LatencyMin {
+		return fmt.Errorf("latency_max must not be less than latency_min")
+	}
+	return nil
+}
+
+// Enabled returns true if config injects any fault
+func (c Config) Enabled() bool {
+	return c.FailureRate > 0 || len(c.EndpointFailureRates) > 0 || c.LatencyMax > 0 || c.DropRate > 0 || c.DuplicateRate > 0
+}
+
+// Config returns current chaos settings
+func (ch *Chaos) Config() Config {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	return ch.config
+}
+
+// SetConfig will replace chaos settings
+func (ch *Chaos) SetConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.config = config
+	return nil
+}
+
+// ServeHTTP serves AdminPath. GET shows current settings, and PUT
+// replaces them.
+func (ch *Chaos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var config Config
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := ch.SetConfig(config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[chaos] settings changed : %+v\n", config)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ch.Config())
+}
+
+// Middleware will inject random faults into every request except
+// admin endpoints. It must run before idempotency middleware, so
+// duplicated and retried requests go through idempotency check.
+func (ch *Chaos) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := ch.Config()
+		if !config.Enabled() || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if latency := ch.latency(config); latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		rate, ok := config.EndpointFailureRates[r.URL.Path]
+		if !ok {
+			rate = config.FailureRate
+		}
+		if ch.roll(rate) {
+			log.Printf("[chaos] %s %s : failed\n", r.Method, r.URL.Path)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]bool{"success": false})
+			return
+		}
+
+		duplicate := ch.roll(config.DuplicateRate)
+		drop := ch.roll(config.DropRate)
+		if !duplicate && !drop {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if duplicate {
+			log.Printf("[chaos] %s %s : delivered twice\n", r.Method, r.URL.Path)
+			next.ServeHTTP(httptest.NewRecorder(), withBody(r, body))
+		}
+
+		if drop {
+			rec := httptest.NewRecorder()
+			next.ServeHTTP(rec, withBody(r, body))
+			log.Printf("[chaos] %s %s : processed with status %d, response dropped\n", r.Method, r.URL.Path, rec.Code)
+
+			// aborting handler closes connection without any response
+			panic(http.ErrAbortHandler)
+		}
+		next.ServeHTTP(w, withBody(r, body))
+	})
+}
+
+// latency returns random latency within configured range
+func (ch *Chaos) latency(config Config) time.Duration {
+	min, max := time.Duration(config.LatencyMin), time.Duration(config.LatencyMax)
+	if max <= min {
+		return min
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	return min + time.Duration(ch.rand.Int63n(int64(max-min)))
+}
+
+// roll returns true with given probability
+func (ch *Chaos) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	return ch.rand.Float64() < rate
+}
+
+// withBody returns copy of r reading given body
+func withBody(r *http.Request, body []byte) *http.Request {
+	clone := r.WithContext(r.Context())
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return clone
+}
@@ -0,0 +1,51 @@
+package chaos
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryInterceptor will inject random faults into every gRPC call, the
+// same way Middleware does into HTTP requests. EndpointFailureRates are
+// keyed by full method name, such as /item.ItemService/Purchase. It must
+// run before idempotency interceptor.
+func (ch *Chaos) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	config := ch.Config()
+	if !config.Enabled() {
+		return handler(ctx, req)
+	}
+
+	if latency := ch.latency(config); latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+
+	rate, ok := config.EndpointFailureRates[info.FullMethod]
+	if !ok {
+		rate = config.FailureRate
+	}
+	if ch.roll(rate) {
+		log.Printf("[chaos] %s : failed\n", info.FullMethod)
+		return nil, status.Error(codes.Internal, "chaos failure")
+	}
+
+	if ch.roll(config.DuplicateRate) {
+		log.Printf("[chaos] %s : delivered twice\n", info.FullMethod)
+		handler(ctx, req)
+	}
+
+	if ch.roll(config.DropRate) {
+		_, err := handler(ctx, req)
+		log.Printf("[chaos] %s : processed with status %s, response dropped\n", info.FullMethod, status.Code(err))
+		return nil, status.Error(codes.Unavailable, "response dropped")
+	}
+	return handler(ctx, req)
+}
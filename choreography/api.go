@@ -0,0 +1,232 @@
+package choreography
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/eventbus"
+	"github.com/cikupin/saga-simple-example/events"
+	"github.com/cikupin/saga-simple-example/fault"
+	"github.com/cikupin/saga-simple-example/saga"
+	"github.com/cikupin/saga-simple-example/uid"
+	"github.com/gorilla/mux"
+)
+
+type (
+	buyItemRequest struct {
+		Item          string     `json:"item"`
+		Price         int        `json:"price"`
+		PaymentMethod string     `json:"payment_method"`
+		Faults        fault.Plan `json:"faults,omitempty"`
+	}
+
+	buyItemResponse struct {
+		SagaID  string `json:"saga_id"`
+		Success bool   `json:"success"`
+	}
+
+	asyncResponse struct {
+		SagaID    string `json:"saga_id"`
+		StatusURL string `json:"status_url"`
+	}
+
+	statusResponse struct {
+		ID     string           `json:"id"`
+		State  saga.State       `json:"state"`
+		Events []eventbus.Event `json:"events"`
+	}
+
+	errorResponse struct {
+		Error string `json:"error"`
+	}
+
+	// tracker waits for outcome of purchases started by this process
+	tracker struct {
+		bus *eventbus.Bus
+
+		mu      sync.Mutex
+		waiters map[string]chan bool
+	}
+)
+
+var (
+	errSagaNotFound = errors.New("saga not found")
+
+	purchases *tracker
+)
+
+// handlerBuy defines buy handler
+func handlerBuy(w http.ResponseWriter, r *http.Request) {
+	var req buyItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		generateErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := injectFaults(r, &req); err != nil {
+		generateErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	startPurchase(w, r, req)
+}
+
+// startPurchase will publish purchase request carrying its fault plan,
+// then wait for its outcome. Purchase which is not finished within wait
+// timeout responds with 202 Accepted and its status URL.
+func startPurchase(w http.ResponseWriter, r *http.Request, req buyItemRequest) {
+	sagaID, err := uid.New()
+	if err != nil {
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	outcome := purchases.wait(sagaID)
+	defer purchases.forget(sagaID)
+
+	err = purchases.bus.Publish(events.PurchaseRequested, sagaID, events.Purchase{
+		Item:          req.Item,
+		Price:         req.Price,
+		PaymentMethod: req.PaymentMethod,
+		Faults:        req.Faults,
+	})
+	if err != nil {
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	timer := time.NewTimer(waitTimeout)
+	defer timer.Stop()
+
+	select {
+	case success := <-outcome:
+		response := buyItemResponse{
+			SagaID:  sagaID,
+			Success: success,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	case <-timer.C:
+		generateAcceptedResponse(w, sagaID)
+	case <-r.Context().Done():
+		generateAcceptedResponse(w, sagaID)
+	}
+}
+
+// handlerSagaStatus defines saga status handler. Status is rebuilt from
+// every event of the saga published on event bus.
+func handlerSagaStatus(w http.ResponseWriter, r *http.Request) {
+	sagaID := mux.Vars(r)["id"]
+
+	all, err := purchases.bus.Events()
+	if err != nil {
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := statusResponse{
+		ID:     sagaID,
+		State:  saga.StateRunning,
+		Events: []eventbus.Event{},
+	}
+	for _, e := range all {
+		if e.SagaID != sagaID {
+			continue
+		}
+
+		response.Events = append(response.Events, e)
+		switch e.Type {
+		case events.OrderFailed, events.PaymentFailed:
+			response.State = saga.StateCompensating
+		case events.PaymentCompleted:
+			response.State = saga.StateCompleted
+		case events.ItemReservationFailed, events.ItemReleased:
+			response.State = saga.StateAborted
+		}
+	}
+	if len(response.Events) == 0 {
+		generateErrorResponse(w, http.StatusNotFound, errSagaNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func newTracker(bus *eventbus.Bus) *tracker {
+	return &tracker{
+		bus:     bus,
+		waiters: make(map[string]chan bool),
+	}
+}
+
+// wait returns channel receiving whether purchase succeeded once it is finished
+func (t *tracker) wait(sagaID string) chan bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	outcome := make(chan bool, 1)
+	t.waiters[sagaID] = outcome
+	return outcome
+}
+
+func (t *tracker) forget(sagaID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.waiters, sagaID)
+}
+
+// listen will notify waiter of purchase once purchase is completed,
+// or once every finished step has been rolled back
+func (t *tracker) listen(e eventbus.Event) error {
+	var success bool
+	switch e.Type {
+	case events.PaymentCompleted:
+		success = true
+	case events.ItemReservationFailed, events.ItemReleased:
+		success = false
+	default:
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if outcome, ok := t.waiters[e.SagaID]; ok {
+		outcome <- success
+		delete(t.waiters, e.SagaID)
+	}
+	return nil
+}
+
+func generateAcceptedResponse(w http.ResponseWriter, sagaID string) {
+	response := asyncResponse{
+		SagaID:    sagaID,
+		StatusURL: fmt.Sprintf("/sagas/%s", sagaID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", response.StatusURL)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+func generateErrorResponse(w http.ResponseWriter, statusCode int, err error) {
+	response := errorResponse{Error: err.Error()}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,154 @@
+package choreography
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/config"
+	"github.com/cikupin/saga-simple-example/eventbus"
+	"github.com/cikupin/saga-simple-example/item"
+	"github.com/cikupin/saga-simple-example/order"
+	"github.com/cikupin/saga-simple-example/outbox"
+	"github.com/cikupin/saga-simple-example/payment"
+	"github.com/cikupin/saga-simple-example/storage"
+	"github.com/gorilla/mux"
+	"github.com/urfave/cli"
+)
+
+const (
+	roleAPI     = "api"
+	roleItem    = "item"
+	roleOrder   = "order"
+	rolePayment = "payment"
+)
+
+// Serve will serve choreography-based saga
+var Serve = cli.Command{
+	Name:        "choreography",
+	Usage:       "Run choreography-based saga",
+	Description: "Execute this command to start item, order and payment services exchanging domain events, together with API starting purchases",
+	Action:      startChoreography,
+	Flags:       config.Flags(config.SectionChoreography),
+}
+
+var waitTimeout time.Duration
+
+// startChoreography will start selected roles. Every role only talks to
+// event bus, so with file event bus every role may run in its own process.
+func startChoreography(c *cli.Context) {
+	cfg, err := config.FromContext(c, config.SectionChoreography)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	ch := cfg.Choreography
+	busStorage, err := newStorage(ch.Bus.Engine, ch.Bus.Dir)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	bus := eventbus.New(busStorage, time.Duration(ch.Bus.PollInterval))
+	waitTimeout = time.Duration(ch.WaitTimeout)
+
+	roles := ch.Roles
+	if len(roles) == 0 {
+		roles = []string{roleAPI, roleItem, roleOrder, rolePayment}
+	}
+
+	ctx, cancelRelay := context.WithCancel(context.Background())
+	var srv *http.Server
+	var stores []*outbox.Outbox
+	for _, role := range roles {
+		if role == roleAPI {
+			srv = startAPI(bus, ch.Server)
+			log.Printf("%s is running on %s event bus\n", role, ch.Bus.Engine)
+			continue
+		}
+
+		store, err := openStore(ch.Bus.Engine, filepath.Join(ch.StoreDir, role))
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		stores = append(stores, store)
+		go store.Relay(ctx, bus, time.Duration(ch.Bus.PollInterval))
+
+		switch role {
+		case roleItem:
+			item.SubscribeEvents(bus, store)
+		case roleOrder:
+			order.SubscribeEvents(bus, store)
+		case rolePayment:
+			payment.SubscribeEvents(bus, store)
+		default:
+			log.Fatalf("unknown role %s\n", role)
+		}
+		log.Printf("%s is running on %s event bus\n", role, ch.Bus.Engine)
+	}
+
+	chanSignal := make(chan os.Signal, 1)
+	signal.Notify(chanSignal, os.Interrupt)
+	<-chanSignal
+
+	if srv != nil {
+		// 3 seconds graceful shutdown
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		srv.Shutdown(ctx)
+	}
+	cancelRelay()
+	bus.Close()
+	for _, store := range stores {
+		store.Close()
+	}
+	log.Println("shutting down")
+	os.Exit(0)
+}
+
+// startAPI will serve buy endpoint, which publish purchase request
+// instead of calling participant services
+func startAPI(bus *eventbus.Bus, server config.Server) *http.Server {
+	purchases = newTracker(bus)
+	bus.Subscribe(roleAPI, purchases.listen)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/buy", handlerBuy).Methods(http.MethodPost)
+	r.HandleFunc("/sagas/{id}", handlerSagaStatus).Methods(http.MethodGet)
+
+	srv := server.HTTPServer(r)
+	go func() {
+		log.Printf("choreography api is running on %s\n", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil {
+			log.Println(err)
+		}
+	}()
+	return srv
+}
+
+// newStorage will create selected storage engine of event bus
+// or participant service store
+func newStorage(engine, dir string) (storage.Storage, error) {
+	switch engine {
+	case storage.EngineMemory:
+		return storage.NewMemoryStorage(), nil
+	case storage.EngineFile:
+		return storage.NewFileStorage(dir)
+	default:
+		return nil, fmt.Errorf("unknown storage engine %s", engine)
+	}
+}
+
+// openStore will open participant service store, using the same storage
+// engine as event bus
+func openStore(engine, dir string) (*outbox.Outbox, error) {
+	s, err := newStorage(engine, dir)
+	if err != nil {
+		return nil, err
+	}
+	return outbox.Open(s)
+}
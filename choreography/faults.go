@@ -0,0 +1,43 @@
+package choreography
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cikupin/saga-simple-example/events"
+	"github.com/cikupin/saga-simple-example/fault"
+)
+
+// injectFaults will set fault plan of purchase. Plan in X-Fault-Plan header
+// is added to plan in request body. Compensation of choreography is retried
+// by event bus until it succeeds, so only its latency can be injected.
+func injectFaults(r *http.Request, req *buyItemRequest) error {
+	if value := r.Header.Get(fault.HeaderPlan); value != "" {
+		plan, err := fault.ParsePlan(value)
+		if err != nil {
+			return err
+		}
+
+		if req.Faults == nil {
+			req.Faults = make(fault.Plan)
+		}
+		for step, f := range plan {
+			req.Faults[step] = f
+		}
+	}
+
+	err := req.Faults.Validate(events.StepPurchaseItem, events.StepOrder, events.StepPayment)
+	if err != nil {
+		return err
+	}
+
+	for step, f := range req.Faults {
+		if f.FailCompensation {
+			return fmt.Errorf("compensation of step %s is retried until it succeeds, only its latency can be injected", step)
+		}
+		if f.CompensationLatency > 0 && step == events.StepPayment {
+			return fmt.Errorf("step %s has no compensation to inject fault into", step)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,875 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/chaos"
+	"github.com/cikupin/saga-simple-example/fault"
+	"github.com/cikupin/saga-simple-example/httpclient"
+	"github.com/cikupin/saga-simple-example/storage"
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// EnvFile is the env var holding path of config file, overridden by
+// --config flag
+const EnvFile = "SAGA_CONFIG"
+
+// EnvWebhookSecret is the env var of webhook secret kept from before
+// settings got env vars of their own, overridden by ORCHESTRATOR_WEBHOOK_SECRET
+const EnvWebhookSecret = "WEBHOOK_SECRET"
+
+// Sections of config file, one per command. Section name is also the
+// prefix of env vars overriding its settings.
+const (
+	SectionOrchestrator = "orchestrator"
+	SectionItem         = "item"
+	SectionOrder        = "order"
+	SectionPayment      = "payment"
+	SectionChoreography = "choreography"
+)
+
+type (
+	// Duration defines timeout, written as duration string in YAML
+	Duration time.Duration
+
+	// Server defines HTTP server settings of a command
+	Server struct {
+		Addr         string   `yaml:"addr"`
+		ReadTimeout  Duration `yaml:"read_timeout"`
+		WriteTimeout Duration `yaml:"write_timeout"`
+		IdleTimeout  Duration `yaml:"idle_timeout"`
+	}
+
+	// Kafka defines kafka saga log storage settings
+	Kafka struct {
+		ZkAddrs        []string `yaml:"zk_addrs"`
+		BrokerAddrs    []string `yaml:"broker_addrs"`
+		Partitions     int      `yaml:"partitions"`
+		Replicas       int      `yaml:"replicas"`
+		ReturnDuration Duration `yaml:"return_duration"`
+	}
+
+	// Storage defines saga log storage settings
+	Storage struct {
+		Engine string `yaml:"engine"`
+		Dir    string `yaml:"dir"`
+	}
+
+	// RetryPolicy defines how failed sub-transaction or compensation is
+	// retried. Backoff starts at Backoff and doubles up to MaxBackoff.
+	RetryPolicy struct {
+		MaxAttempts int      `yaml:"max_attempts"`
+		Backoff     Duration `yaml:"backoff"`
+		MaxBackoff  Duration `yaml:"max_backoff"`
+	}
+
+	// Client defines settings of participant service client
+	Client struct {
+		Timeout      Duration `yaml:"timeout"`
+		MaxIdleConns int      `yaml:"max_idle_conns"`
+		Trace        bool     `yaml:"trace"`
+	}
+
+	// Webhook defines webhook delivery settings
+	Webhook struct {
+		Secret      string `yaml:"secret"`
+		MaxAttempts int    `yaml:"max_attempts"`
+	}
+
+	// Broker defines message broker carrying participant commands and
+	// replies, disabled when Engine is empty
+	Broker struct {
+		Engine       string   `yaml:"engine"`
+		Dir          string   `yaml:"dir"`
+		PollInterval Duration `yaml:"poll_interval"`
+	}
+
+	// Chaos defines random faults injected into participant service. Every
+	// rate is a probability between 0 and 1.
+	Chaos struct {
+		FailureRate          float64            `yaml:"failure_rate"`
+		EndpointFailureRates map[string]float64 `yaml:"endpoint_failure_rates"`
+		LatencyMin           Duration           `yaml:"latency_min"`
+		LatencyMax           Duration           `yaml:"latency_max"`
+		DropRate             float64            `yaml:"drop_rate"`
+		DuplicateRate        float64            `yaml:"duplicate_rate"`
+	}
+
+	// Bus defines event bus of choreography-based saga
+	Bus struct {
+		Engine       string   `yaml:"engine"`
+		Dir          string   `yaml:"dir"`
+		PollInterval Duration `yaml:"poll_interval"`
+	}
+
+	// Orchestrator defines settings of saga orchestrator
+	Orchestrator struct {
+		Server            Server      `yaml:"server"`
+		ItemURL           string      `yaml:"item_url"`
+		OrderURL          string      `yaml:"order_url"`
+		PaymentURL        string      `yaml:"payment_url"`
+		Storage           Storage     `yaml:"storage"`
+		Kafka             Kafka       `yaml:"kafka"`
+		Workers           int         `yaml:"workers"`
+		QueueSize         int         `yaml:"queue_size"`
+		StepTimeout       Duration    `yaml:"step_timeout"`
+		Retry             RetryPolicy `yaml:"retry"`
+		CompensationRetry RetryPolicy `yaml:"compensation_retry"`
+		Client            Client      `yaml:"client"`
+		FaultConfig       string      `yaml:"fault_config"`
+		Webhook           Webhook     `yaml:"webhook"`
+		Broker            Broker      `yaml:"broker"`
+	}
+
+	// Participant defines settings of participant service
+	Participant struct {
+		Server         Server `yaml:"server"`
+		GRPCAddr       string `yaml:"grpc_addr"`
+		IdempotencyDir string `yaml:"idempotency_dir"`
+		StoreDir       string `yaml:"store_dir"`
+		Broker         Broker `yaml:"broker"`
+		Chaos          Chaos  `yaml:"chaos"`
+	}
+
+	// Choreography defines settings of choreography-based saga
+	Choreography struct {
+		Server      Server   `yaml:"server"`
+		Bus         Bus      `yaml:"bus"`
+		StoreDir    string   `yaml:"store_dir"`
+		Roles       []string `yaml:"roles"`
+		WaitTimeout Duration `yaml:"wait_timeout"`
+	}
+
+	// Config defines settings of every command. Settings are taken from
+	// flags, then env vars, then config file, then defaults.
+	Config struct {
+		Orchestrator Orchestrator `yaml:"orchestrator"`
+		Item         Participant  `yaml:"item"`
+		Order        Participant  `yaml:"order"`
+		Payment      Participant  `yaml:"payment"`
+		Choreography Choreography `yaml:"choreography"`
+	}
+
+	// setting binds a config field to its flag and env var. Value points
+	// to *string, *[]string, *int, *float64, *bool, *Duration or
+	// *map[string]float64 field.
+	setting struct {
+		section string
+		flag    string
+		usage   string
+		value   interface{}
+	}
+)
+
+// Command will show effective configuration of every command
+var Command = cli.Command{
+	Name:  "config",
+	Usage: "Show configuration",
+	Subcommands: []cli.Command{
+		{
+			Name:        "print",
+			Usage:       "Print effective configuration",
+			Description: "Execute this command to print configuration loaded from config file and env vars, as config file",
+			Action:      printConfig,
+			Flags:       []cli.Flag{fileFlag},
+		},
+	},
+}
+
+var fileFlag = cli.StringFlag{
+	Name:   "config",
+	EnvVar: EnvFile,
+	Usage:  "YAML config file, overridden by env vars and flags",
+}
+
+// Default returns default settings of every command
+func Default() Config {
+	return Config{
+		Orchestrator: Orchestrator{
+			Server:     defaultServer(":8000"),
+			ItemURL:    "http://localhost:8001",
+			OrderURL:   "http://localhost:8002",
+			PaymentURL: "http://localhost:8003",
+			Storage: Storage{
+				Engine: storage.EngineKafka,
+				Dir:    "saga-log",
+			},
+			Kafka: Kafka{
+				ZkAddrs:        []string{"0.0.0.0:2181"},
+				BrokerAddrs:    []string{"0.0.0.0:9092"},
+				Partitions:     1,
+				Replicas:       1,
+				ReturnDuration: Duration(50 * time.Millisecond),
+			},
+			Workers:     10,
+			QueueSize:   100,
+			StepTimeout: Duration(time.Second),
+			Retry: RetryPolicy{
+				MaxAttempts: 3,
+				Backoff:     Duration(100 * time.Millisecond),
+				MaxBackoff:  Duration(time.Second),
+			},
+			CompensationRetry: RetryPolicy{
+				MaxAttempts: 5,
+				Backoff:     Duration(200 * time.Millisecond),
+				MaxBackoff:  Duration(5 * time.Second),
+			},
+			Client: Client{
+				Timeout:      Duration(httpclient.DefaultConfig.Timeout),
+				MaxIdleConns: httpclient.DefaultConfig.MaxIdleConnsPerHost,
+			},
+			Webhook: Webhook{
+				MaxAttempts: 5,
+			},
+			Broker: defaultBroker(),
+		},
+		Item:    defaultParticipant(":8001", ":9001"),
+		Order:   defaultParticipant(":8002", ":9002"),
+		Payment: defaultParticipant(":8003", ":9003"),
+		Choreography: Choreography{
+			Server: defaultServer(":8000"),
+			Bus: Bus{
+				Engine:       storage.EngineMemory,
+				Dir:          "event-bus",
+				PollInterval: Duration(100 * time.Millisecond),
+			},
+			StoreDir:    "participant-store",
+			WaitTimeout: Duration(2 * time.Second),
+		},
+	}
+}
+
+func defaultParticipant(addr, grpcAddr string) Participant {
+	return Participant{
+		Server:   defaultServer(addr),
+		GRPCAddr: grpcAddr,
+		Broker:   defaultBroker(),
+	}
+}
+
+func defaultBroker() Broker {
+	return Broker{
+		Dir:          "message-broker",
+		PollInterval: Duration(100 * time.Millisecond),
+	}
+}
+
+func defaultServer(addr string) Server {
+	return Server{
+		Addr:         addr,
+		ReadTimeout:  Duration(3 * time.Second),
+		WriteTimeout: Duration(3 * time.Second),
+		IdleTimeout:  Duration(10 * time.Second),
+	}
+}
+
+// Load will read config file on top of defaults, then apply env vars.
+// Config file is optional, and unknown keys in it are rejected.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err = yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid config %s : %s", path, err.Error())
+		}
+	}
+
+	if value := os.Getenv(EnvWebhookSecret); value != "" {
+		cfg.Orchestrator.Webhook.Secret = value
+	}
+	for _, s := range cfg.settings() {
+		value, ok := os.LookupEnv(s.env())
+		if !ok || value == "" {
+			continue
+		}
+		if err := s.parse(value); err != nil {
+			return cfg, fmt.Errorf("invalid env var %s : %s", s.env(), err.Error())
+		}
+	}
+	return cfg, nil
+}
+
+// Flags lists flags of given section, together with config file flag.
+// Every flag shows its default and is also read from its env var. Flag
+// of list or map setting may be repeated, or given comma separated items.
+func Flags(section string) []cli.Flag {
+	cfg := Default()
+	flags := []cli.Flag{fileFlag}
+	for _, s := range cfg.settings() {
+		if s.section != section {
+			continue
+		}
+
+		switch v := s.value.(type) {
+		case *string:
+			flags = append(flags, cli.StringFlag{Name: s.flag, EnvVar: s.env(), Value: *v, Usage: s.usage})
+		case *[]string:
+			// default is kept out of the flag, since cli appends given
+			// items to it
+			usage := s.usage + ", comma separated or repeated"
+			if len(*v) > 0 {
+				usage += fmt.Sprintf(" (default: %s)", strings.Join(*v, ","))
+			}
+			flags = append(flags, cli.StringSliceFlag{Name: s.flag, EnvVar: s.env(), Usage: usage})
+		case *map[string]float64:
+			flags = append(flags, cli.StringSliceFlag{Name: s.flag, EnvVar: s.env(), Usage: s.usage + ", given as key=rate, comma separated or repeated"})
+		case *int:
+			flags = append(flags, cli.IntFlag{Name: s.flag, EnvVar: s.env(), Value: *v, Usage: s.usage})
+		case *float64:
+			flags = append(flags, cli.Float64Flag{Name: s.flag, EnvVar: s.env(), Value: *v, Usage: s.usage})
+		case *bool:
+			flags = append(flags, cli.BoolFlag{Name: s.flag, EnvVar: s.env(), Usage: s.usage})
+		case *Duration:
+			flags = append(flags, cli.DurationFlag{Name: s.flag, EnvVar: s.env(), Value: time.Duration(*v), Usage: s.usage})
+		}
+	}
+	return flags
+}
+
+// FromContext will load config file and env vars of command, apply its
+// flags of given section and validate the result
+func FromContext(c *cli.Context, section string) (Config, error) {
+	cfg, err := Load(c.String("config"))
+	if err != nil {
+		return cfg, err
+	}
+
+	for _, s := range cfg.settings() {
+		if s.section != section || !c.IsSet(s.flag) {
+			continue
+		}
+
+		switch v := s.value.(type) {
+		case *string:
+			*v = c.String(s.flag)
+		case *[]string:
+			*v = splitList(strings.Join(c.StringSlice(s.flag), ","))
+		case *map[string]float64:
+			if *v, err = parseRates(strings.Join(c.StringSlice(s.flag), ",")); err != nil {
+				return cfg, fmt.Errorf("invalid flag --%s : %s", s.flag, err.Error())
+			}
+		case *int:
+			*v = c.Int(s.flag)
+		case *float64:
+			*v = c.Float64(s.flag)
+		case *bool:
+			*v = c.Bool(s.flag)
+		case *Duration:
+			*v = Duration(c.Duration(s.flag))
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate returns error if any setting is invalid
+func (c Config) Validate() error {
+	for _, section := range []string{SectionOrchestrator, SectionItem, SectionOrder, SectionPayment, SectionChoreography} {
+		if err := c.server(section).validate(); err != nil {
+			return fmt.Errorf("invalid config %s.server : %s", section, err.Error())
+		}
+	}
+
+	// orchestrator and participant services may run on one host, while
+	// choreography replaces all of them
+	addrs := map[string]string{}
+	for _, a := range [][2]string{
+		{"orchestrator.server.addr", c.Orchestrator.Server.Addr},
+		{"item.server.addr", c.Item.Server.Addr},
+		{"item.grpc_addr", c.Item.GRPCAddr},
+		{"order.server.addr", c.Order.Server.Addr},
+		{"order.grpc_addr", c.Order.GRPCAddr},
+		{"payment.server.addr", c.Payment.Server.Addr},
+		{"payment.grpc_addr", c.Payment.GRPCAddr},
+	} {
+		if a[1] == "" {
+			continue
+		}
+		if err := validateAddr(a[1]); err != nil {
+			return fmt.Errorf("invalid config %s : %s", a[0], err.Error())
+		}
+		if other, ok := addrs[a[1]]; ok {
+			return fmt.Errorf("invalid config %s : %s is already used by %s", a[0], a[1], other)
+		}
+		addrs[a[1]] = a[0]
+	}
+
+	for _, u := range [][3]string{
+		{"orchestrator.item_url", c.Orchestrator.ItemURL, SectionItem},
+		{"orchestrator.order_url", c.Orchestrator.OrderURL, SectionOrder},
+		{"orchestrator.payment_url", c.Orchestrator.PaymentURL, SectionPayment},
+	} {
+		if err := validateURL(u[1], u[2]); err != nil {
+			return fmt.Errorf("invalid config %s : %s", u[0], err.Error())
+		}
+	}
+
+	// kafka settings are used only by kafka storage engine
+	if c.Orchestrator.Storage.Engine == storage.EngineKafka {
+		if err := c.Orchestrator.Kafka.validate(); err != nil {
+			return fmt.Errorf("invalid config orchestrator.kafka : %s", err.Error())
+		}
+	}
+	if err := c.Orchestrator.validate(); err != nil {
+		return fmt.Errorf("invalid config orchestrator.%s", err.Error())
+	}
+
+	for _, section := range []string{SectionItem, SectionOrder, SectionPayment} {
+		p := c.participant(section)
+		if err := p.Broker.validate(); err != nil {
+			return fmt.Errorf("invalid config %s.broker : %s", section, err.Error())
+		}
+		if err := p.Chaos.Config().Validate(); err != nil {
+			return fmt.Errorf("invalid config %s.chaos : %s", section, err.Error())
+		}
+	}
+
+	if err := c.Choreography.validate(); err != nil {
+		return fmt.Errorf("invalid config choreography.%s", err.Error())
+	}
+	return nil
+}
+
+// server returns HTTP server settings of given section
+func (c Config) server(section string) Server {
+	switch section {
+	case SectionItem:
+		return c.Item.Server
+	case SectionOrder:
+		return c.Order.Server
+	case SectionPayment:
+		return c.Payment.Server
+	case SectionChoreography:
+		return c.Choreography.Server
+	default:
+		return c.Orchestrator.Server
+	}
+}
+
+// participant returns settings of given participant service section
+func (c Config) participant(section string) Participant {
+	switch section {
+	case SectionOrder:
+		return c.Order
+	case SectionPayment:
+		return c.Payment
+	default:
+		return c.Item
+	}
+}
+
+// HTTPServer will create http.Server serving handler with these settings
+func (s Server) HTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         s.Addr,
+		WriteTimeout: time.Duration(s.WriteTimeout),
+		ReadTimeout:  time.Duration(s.ReadTimeout),
+		IdleTimeout:  time.Duration(s.IdleTimeout),
+		Handler:      handler,
+	}
+}
+
+func (s Server) validate() error {
+	if err := validateAddr(s.Addr); err != nil {
+		return err
+	}
+	if s.ReadTimeout < 0 || s.WriteTimeout < 0 || s.IdleTimeout < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+	return nil
+}
+
+func (k Kafka) validate() error {
+	if len(k.ZkAddrs) == 0 || len(k.BrokerAddrs) == 0 {
+		return fmt.Errorf("zk_addrs and broker_addrs are required")
+	}
+	for _, addr := range append(append([]string{}, k.ZkAddrs...), k.BrokerAddrs...) {
+		if err := validateAddr(addr); err != nil {
+			return err
+		}
+	}
+	if k.Partitions < 1 || k.Replicas < 1 {
+		return fmt.Errorf("partitions and replicas must be at least 1")
+	}
+	if k.ReturnDuration <= 0 {
+		return fmt.Errorf("return_duration must be positive")
+	}
+	return nil
+}
+
+// validate returns error, prefixed with key of invalid setting, if any
+// orchestrator setting other than server, URLs and kafka is invalid
+func (o Orchestrator) validate() error {
+	switch o.Storage.Engine {
+	case storage.EngineKafka, storage.EngineMemory, storage.EngineFile:
+	default:
+		return fmt.Errorf("storage.engine : unknown engine %s, expected %s, %s or %s", o.Storage.Engine, storage.EngineKafka, storage.EngineMemory, storage.EngineFile)
+	}
+	if o.Workers < 1 || o.QueueSize < 0 {
+		return fmt.Errorf("workers : must be at least 1, and queue_size must not be negative")
+	}
+	if o.StepTimeout <= 0 {
+		return fmt.Errorf("step_timeout : must be positive")
+	}
+	if err := o.Retry.validate(); err != nil {
+		return fmt.Errorf("retry : %s", err.Error())
+	}
+	if err := o.CompensationRetry.validate(); err != nil {
+		return fmt.Errorf("compensation_retry : %s", err.Error())
+	}
+	if o.Client.Timeout < 0 || o.Client.MaxIdleConns < 0 {
+		return fmt.Errorf("client : timeout and max_idle_conns must not be negative")
+	}
+	if o.Webhook.MaxAttempts < 1 {
+		return fmt.Errorf("webhook.max_attempts : must be at least 1")
+	}
+	if err := o.Broker.validate(); err != nil {
+		return fmt.Errorf("broker : %s", err.Error())
+	}
+	if o.Broker.Engine == "" && o.UsesBroker() {
+		return fmt.Errorf("broker.engine : message broker is required by msg:// participant URL")
+	}
+	return nil
+}
+
+// UsesBroker returns true if any participant URL has msg scheme
+func (o Orchestrator) UsesBroker() bool {
+	for _, rawURL := range []string{o.ItemURL, o.OrderURL, o.PaymentURL} {
+		if strings.HasPrefix(rawURL, "msg://") {
+			return true
+		}
+	}
+	return false
+}
+
+func (r RetryPolicy) validate() error {
+	if r.MaxAttempts < 1 {
+		return fmt.Errorf("max_attempts must be at least 1")
+	}
+	if r.Backoff < 0 || r.MaxBackoff < r.Backoff {
+		return fmt.Errorf("backoff must not be negative, nor greater than max_backoff")
+	}
+	return nil
+}
+
+// validate returns error if broker is not shared between processes, since
+// orchestrator and participants run as separate processes. Settings of
+// disabled broker are not used, so they are not validated.
+func (b Broker) validate() error {
+	switch b.Engine {
+	case "":
+		return nil
+	case storage.EngineFile:
+	default:
+		return fmt.Errorf("unsupported engine %s, expected %s", b.Engine, storage.EngineFile)
+	}
+	if b.PollInterval <= 0 {
+		return fmt.Errorf("poll_interval must be positive")
+	}
+	return nil
+}
+
+// Config returns chaos settings. Minimum latency set alone adds minimum
+// latency to every request.
+func (c Chaos) Config() chaos.Config {
+	cfg := chaos.Config{
+		FailureRate:          c.FailureRate,
+		EndpointFailureRates: c.EndpointFailureRates,
+		LatencyMin:           fault.Duration(c.LatencyMin),
+		LatencyMax:           fault.Duration(c.LatencyMax),
+		DropRate:             c.DropRate,
+		DuplicateRate:        c.DuplicateRate,
+	}
+	if len(cfg.EndpointFailureRates) == 0 {
+		cfg.EndpointFailureRates = nil
+	}
+	if cfg.LatencyMax == 0 {
+		cfg.LatencyMax = cfg.LatencyMin
+	}
+	return cfg
+}
+
+// validate returns error, prefixed with key of invalid setting, if any
+// choreography setting other than server is invalid
+func (c Choreography) validate() error {
+	switch c.Bus.Engine {
+	case storage.EngineMemory, storage.EngineFile:
+	default:
+		return fmt.Errorf("bus.engine : unknown engine %s, expected %s or %s", c.Bus.Engine, storage.EngineMemory, storage.EngineFile)
+	}
+	if c.Bus.PollInterval <= 0 {
+		return fmt.Errorf("bus.poll_interval : must be positive")
+	}
+	for _, role := range c.Roles {
+		switch role {
+		case "api", SectionItem, SectionOrder, SectionPayment:
+		default:
+			return fmt.Errorf("roles : unknown role %s, expected api, item, order or payment", role)
+		}
+	}
+	if c.WaitTimeout < 0 {
+		return fmt.Errorf("wait_timeout : must not be negative")
+	}
+	return nil
+}
+
+// validateAddr returns error if addr is not a host:port listen or dial address
+func validateAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port in address %s", addr)
+	}
+	return nil
+}
+
+// validateURL returns error if rawURL is not a participant URL with
+// http, https, grpc or msg scheme. Participants serve broker commands on
+// the channel named after their section, so msg URL must name it.
+func validateURL(rawURL, section string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+	case "msg":
+		if u.Host != section {
+			return fmt.Errorf("unsupported channel of %s, %s service is served on msg://%s", rawURL, section, section)
+		}
+	case "grpc":
+		return validateAddr(u.Host)
+	default:
+		return fmt.Errorf("unsupported scheme of %s, expected http, https, grpc or msg", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in %s", rawURL)
+	}
+	return nil
+}
+
+// settings lists every setting which can be overridden by flag and env var
+func (c *Config) settings() []setting {
+	var settings []setting
+	server := func(section string, s *Server) {
+		settings = append(settings,
+			setting{section, "addr", "listen address of HTTP server", &s.Addr},
+			setting{section, "read-timeout", "maximum duration of reading HTTP request", &s.ReadTimeout},
+			setting{section, "write-timeout", "maximum duration of writing HTTP response", &s.WriteTimeout},
+			setting{section, "idle-timeout", "maximum duration of idle keep-alive connection", &s.IdleTimeout},
+		)
+	}
+
+	broker := func(section string, b *Broker) {
+		settings = append(settings,
+			setting{section, "broker", "message broker storage engine (file) carrying participant commands and replies, disabled when empty", &b.Engine},
+			setting{section, "broker-dir", "directory of message broker when using file storage engine, shared by every process", &b.Dir},
+			setting{section, "broker-poll-interval", "how often every consumer looks for new messages", &b.PollInterval},
+		)
+	}
+
+	o := &c.Orchestrator
+	server(SectionOrchestrator, &o.Server)
+	settings = append(settings,
+		setting{SectionOrchestrator, "item-url", "base URL of item service, grpc://host:port to call it through gRPC, or msg://channel to send it commands through --broker, such as grpc://localhost:9001 or msg://item", &o.ItemURL},
+		setting{SectionOrchestrator, "order-url", "base URL of order service, grpc://host:port to call it through gRPC, or msg://channel to send it commands through --broker, such as grpc://localhost:9002 or msg://order", &o.OrderURL},
+		setting{SectionOrchestrator, "payment-url", "base URL of payment service, grpc://host:port to call it through gRPC, or msg://channel to send it commands through --broker, such as grpc://localhost:9003 or msg://payment", &o.PaymentURL},
+		setting{SectionOrchestrator, "storage", "saga log storage engine (kafka, memory, file)", &o.Storage.Engine},
+		setting{SectionOrchestrator, "storage-dir", "directory of saga log when using file storage engine", &o.Storage.Dir},
+		setting{SectionOrchestrator, "kafka-zk-addrs", "zookeeper addresses of kafka saga log storage", &o.Kafka.ZkAddrs},
+		setting{SectionOrchestrator, "kafka-broker-addrs", "broker addresses of kafka saga log storage", &o.Kafka.BrokerAddrs},
+		setting{SectionOrchestrator, "kafka-partitions", "number of partitions of kafka saga log topic", &o.Kafka.Partitions},
+		setting{SectionOrchestrator, "kafka-replicas", "number of replicas of kafka saga log topic", &o.Kafka.Replicas},
+		setting{SectionOrchestrator, "kafka-return-duration", "how long kafka saga log storage waits for messages when reading", &o.Kafka.ReturnDuration},
+		setting{SectionOrchestrator, "workers", "number of workers executing asynchronous sagas", &o.Workers},
+		setting{SectionOrchestrator, "queue-size", "maximum number of queued asynchronous sagas", &o.QueueSize},
+		setting{SectionOrchestrator, "step-timeout", "deadline of every sub-transaction and compensation call", &o.StepTimeout},
+		setting{SectionOrchestrator, "retry-max-attempts", "maximum attempts of sub-transaction failed with network, server or conflict error", &o.Retry.MaxAttempts},
+		setting{SectionOrchestrator, "retry-backoff", "initial backoff between sub-transaction attempts", &o.Retry.Backoff},
+		setting{SectionOrchestrator, "retry-max-backoff", "maximum backoff between sub-transaction attempts", &o.Retry.MaxBackoff},
+		setting{SectionOrchestrator, "compensation-max-attempts", "maximum attempts of compensation before saga is moved to dead-letter store", &o.CompensationRetry.MaxAttempts},
+		setting{SectionOrchestrator, "compensation-backoff", "initial backoff between compensation attempts", &o.CompensationRetry.Backoff},
+		setting{SectionOrchestrator, "compensation-max-backoff", "maximum backoff between compensation attempts", &o.CompensationRetry.MaxBackoff},
+		setting{SectionOrchestrator, "client-timeout", "overall timeout of participant service call, on top of --step-timeout", &o.Client.Timeout},
+		setting{SectionOrchestrator, "client-max-idle-conns", "maximum idle connections kept to every participant service", &o.Client.MaxIdleConns},
+		setting{SectionOrchestrator, "client-trace", "log every participant service call with its status and duration", &o.Client.Trace},
+		setting{SectionOrchestrator, "fault-config", "JSON file with fault plan injected into every saga whose request has none", &o.FaultConfig},
+		setting{SectionOrchestrator, "webhook-secret", "secret used to sign webhook events with HMAC-SHA256, also read from " + EnvWebhookSecret, &o.Webhook.Secret},
+		setting{SectionOrchestrator, "webhook-max-attempts", "maximum number of webhook delivery attempts", &o.Webhook.MaxAttempts},
+	)
+	broker(SectionOrchestrator, &o.Broker)
+
+	participant := func(section string, p *Participant) {
+		server(section, &p.Server)
+		settings = append(settings,
+			setting{section, "grpc-addr", "address of gRPC server serving the same endpoints, disabled when empty", &p.GRPCAddr},
+			setting{section, "idempotency-dir", "directory of processed idempotency keys, kept in memory when empty", &p.IdempotencyDir},
+			setting{section, "store-dir", "directory of service state and its outbox, kept in memory when empty", &p.StoreDir},
+		)
+		broker(section, &p.Broker)
+		settings = append(settings,
+			setting{section, "chaos-failure-rate", "probability of failing a request without processing it", &p.Chaos.FailureRate},
+			setting{section, "chaos-endpoint-failure-rate", "failure probability of one endpoint path or gRPC method, overriding --chaos-failure-rate", &p.Chaos.EndpointFailureRates},
+			setting{section, "chaos-latency-min", "minimum latency added to every request", &p.Chaos.LatencyMin},
+			setting{section, "chaos-latency-max", "maximum latency added to every request, latency is uniformly distributed from --chaos-latency-min", &p.Chaos.LatencyMax},
+			setting{section, "chaos-drop-rate", "probability of dropping response after request is processed", &p.Chaos.DropRate},
+			setting{section, "chaos-duplicate-rate", "probability of processing a request twice", &p.Chaos.DuplicateRate},
+		)
+	}
+	participant(SectionItem, &c.Item)
+	participant(SectionOrder, &c.Order)
+	participant(SectionPayment, &c.Payment)
+
+	ch := &c.Choreography
+	server(SectionChoreography, &ch.Server)
+	settings = append(settings,
+		setting{SectionChoreography, "bus", "event bus storage engine (memory, file)", &ch.Bus.Engine},
+		setting{SectionChoreography, "bus-dir", "directory of event bus when using file storage engine, shared by every process", &ch.Bus.Dir},
+		setting{SectionChoreography, "poll-interval", "how often every participant looks for new events", &ch.Bus.PollInterval},
+		setting{SectionChoreography, "store-dir", "directory of participant service stores when using file storage engine, one sub-directory per role", &ch.StoreDir},
+		setting{SectionChoreography, "role", "role run by this process (api, item, order, payment), every role is run when not set", &ch.Roles},
+		setting{SectionChoreography, "wait-timeout", "how long buy endpoint waits for purchase to finish before responding with 202 Accepted", &ch.WaitTimeout},
+	)
+	return settings
+}
+
+// env returns name of env var overriding the setting, such as ITEM_GRPC_ADDR
+func (s setting) env() string {
+	return strings.ToUpper(strings.Replace(s.section+"_"+s.flag, "-", "_", -1))
+}
+
+// parse will set the setting from env var value
+func (s setting) parse(value string) error {
+	switch v := s.value.(type) {
+	case *string:
+		*v = value
+	case *[]string:
+		*v = splitList(value)
+	case *map[string]float64:
+		rates, err := parseRates(value)
+		if err != nil {
+			return err
+		}
+		*v = rates
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*v = n
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*v = f
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*v = b
+	case *Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*v = Duration(d)
+	}
+	return nil
+}
+
+// splitList returns items of comma separated list
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseRates returns rates of comma separated key=rate list
+func parseRates(value string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, item := range splitList(value) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid rate %q, expected key=rate", item)
+		}
+
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate %q : %s", item, err.Error())
+		}
+		rates[parts[0]] = rate
+	}
+	return rates, nil
+}
+
+// MarshalYAML will write duration as duration string
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// UnmarshalYAML will read duration from duration string
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func printConfig(c *cli.Context) {
+	if err := writeConfig(os.Stdout, c.String("config")); err != nil {
+		log.Fatalln(err.Error())
+	}
+}
+
+// writeConfig will load and validate config file and env vars, and write
+// the result into w as config file
+func writeConfig(w io.Writer, path string) error {
+	cfg, err := Load(path)
+	if err != nil {
+		return err
+	}
+	if err = cfg.Validate(); err != nil {
+		return err
+	}
+
+	// secret is not shown
+	if cfg.Orchestrator.Webhook.Secret != "" {
+		cfg.Orchestrator.Webhook.Secret = "<redacted>"
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
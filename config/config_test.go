@@ -0,0 +1,456 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// setenv will set env vars until test ends, clearing the ones set by the
+// environment running the test
+func setenv(t *testing.T, env map[string]string) {
+	cfg := Default()
+	for _, s := range cfg.settings() {
+		unsetenv(t, s.env())
+	}
+	unsetenv(t, EnvWebhookSecret)
+	unsetenv(t, EnvFile)
+
+	for k, v := range env {
+		unsetenv(t, k)
+		os.Setenv(k, v)
+	}
+}
+
+func unsetenv(t *testing.T, key string) {
+	if value, ok := os.LookupEnv(key); ok {
+		t.Cleanup(func() { os.Setenv(key, value) })
+	} else {
+		t.Cleanup(func() { os.Unsetenv(key) })
+	}
+	os.Unsetenv(key)
+}
+
+// configFile will write YAML config file removed when test ends
+func configFile(t *testing.T, data string) string {
+	f, err := ioutil.TempFile("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	if _, err = f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return f.Name()
+}
+
+// run will run command of given section with args, returning its config
+func run(t *testing.T, section string, args ...string) (Config, error) {
+	var cfg Config
+	app := cli.NewApp()
+	app.Writer = ioutil.Discard
+	app.ErrWriter = ioutil.Discard
+	app.Commands = []cli.Command{{
+		Name:  section,
+		Flags: Flags(section),
+		Action: func(c *cli.Context) error {
+			var err error
+			cfg, err = FromContext(c, section)
+			return err
+		},
+	}}
+	err := app.Run(append([]string{"saga", section}, args...))
+	return cfg, err
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{
+			name:   "default",
+			change: func(c *Config) {},
+		},
+		{
+			name: "kafka is not validated with file storage",
+			change: func(c *Config) {
+				c.Orchestrator.Storage.Engine = "file"
+				c.Orchestrator.Kafka = Kafka{}
+			},
+		},
+		{
+			name: "kafka is validated with kafka storage",
+			change: func(c *Config) {
+				c.Orchestrator.Kafka.BrokerAddrs = nil
+			},
+			wantErr: "orchestrator.kafka",
+		},
+		{
+			name: "unknown storage engine",
+			change: func(c *Config) {
+				c.Orchestrator.Storage.Engine = "redis"
+			},
+			wantErr: "orchestrator.storage.engine",
+		},
+		{
+			name: "disabled broker is not validated",
+			change: func(c *Config) {
+				c.Orchestrator.Broker.PollInterval = 0
+				c.Item.Broker.PollInterval = 0
+			},
+		},
+		{
+			name: "enabled broker is validated",
+			change: func(c *Config) {
+				c.Item.Broker.Engine = "file"
+				c.Item.Broker.PollInterval = 0
+			},
+			wantErr: "item.broker",
+		},
+		{
+			name: "broker kept in memory",
+			change: func(c *Config) {
+				c.Order.Broker.Engine = "memory"
+			},
+			wantErr: "order.broker",
+		},
+		{
+			name: "msg URL with broker",
+			change: func(c *Config) {
+				c.Orchestrator.ItemURL = "msg://item"
+				c.Orchestrator.Broker.Engine = "file"
+			},
+		},
+		{
+			name: "msg URL without broker",
+			change: func(c *Config) {
+				c.Orchestrator.ItemURL = "msg://item"
+			},
+			wantErr: "orchestrator.broker.engine",
+		},
+		{
+			name: "msg URL of channel participant does not serve",
+			change: func(c *Config) {
+				c.Orchestrator.OrderURL = "msg://orders"
+				c.Orchestrator.Broker.Engine = "file"
+			},
+			wantErr: "orchestrator.order_url",
+		},
+		{
+			name: "grpc URL without port",
+			change: func(c *Config) {
+				c.Orchestrator.PaymentURL = "grpc://localhost"
+			},
+			wantErr: "orchestrator.payment_url",
+		},
+		{
+			name: "address used twice",
+			change: func(c *Config) {
+				c.Order.GRPCAddr = ":9001"
+			},
+			wantErr: "order.grpc_addr",
+		},
+		{
+			name: "no workers",
+			change: func(c *Config) {
+				c.Orchestrator.Workers = 0
+			},
+			wantErr: "orchestrator.workers",
+		},
+		{
+			name: "step timeout",
+			change: func(c *Config) {
+				c.Orchestrator.StepTimeout = 0
+			},
+			wantErr: "orchestrator.step_timeout",
+		},
+		{
+			name: "retry backoff greater than max backoff",
+			change: func(c *Config) {
+				c.Orchestrator.Retry.Backoff = Duration(time.Minute)
+			},
+			wantErr: "orchestrator.retry",
+		},
+		{
+			name: "no webhook attempt",
+			change: func(c *Config) {
+				c.Orchestrator.Webhook.MaxAttempts = 0
+			},
+			wantErr: "orchestrator.webhook.max_attempts",
+		},
+		{
+			name: "chaos rate is not a probability",
+			change: func(c *Config) {
+				c.Payment.Chaos.EndpointFailureRates = map[string]float64{"/payment-paid": 1.5}
+			},
+			wantErr: "payment.chaos",
+		},
+		{
+			name: "chaos minimum latency alone",
+			change: func(c *Config) {
+				c.Item.Chaos.LatencyMin = Duration(time.Millisecond)
+			},
+		},
+		{
+			name: "chaos latency range ending before its minimum",
+			change: func(c *Config) {
+				c.Item.Chaos.LatencyMin = Duration(time.Second)
+				c.Item.Chaos.LatencyMax = Duration(time.Millisecond)
+			},
+			wantErr: "item.chaos",
+		},
+		{
+			name: "unknown choreography role",
+			change: func(c *Config) {
+				c.Choreography.Roles = []string{"api", "shipping"}
+			},
+			wantErr: "choreography.roles",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(&cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Validate() error = %v, want error of %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	file := `
+orchestrator:
+  storage:
+    engine: file
+    dir: /var/saga-log
+  workers: 4
+  step_timeout: 2s
+  retry:
+    max_attempts: 5
+  webhook:
+    secret: from-file
+  broker:
+    engine: file
+    poll_interval: 1s
+item:
+  chaos:
+    failure_rate: 0.1
+    endpoint_failure_rates:
+      /item-purchased: 0.5
+choreography:
+  bus:
+    engine: file
+  roles: [api]
+`
+
+	tests := []struct {
+		name    string
+		section string
+		env     map[string]string
+		args    []string
+		check   func(c Config) interface{}
+		want    interface{}
+	}{
+		{
+			name:    "storage from config file",
+			section: SectionOrchestrator,
+			check:   func(c Config) interface{} { return c.Orchestrator.Storage },
+			want:    Storage{Engine: "file", Dir: "/var/saga-log"},
+		},
+		{
+			name:    "storage engine from env var over config file",
+			section: SectionOrchestrator,
+			env:     map[string]string{"ORCHESTRATOR_STORAGE": "memory"},
+			check:   func(c Config) interface{} { return c.Orchestrator.Storage },
+			want:    Storage{Engine: "memory", Dir: "/var/saga-log"},
+		},
+		{
+			name:    "workers from flag over env var",
+			section: SectionOrchestrator,
+			env:     map[string]string{"ORCHESTRATOR_WORKERS": "6"},
+			args:    []string{"--workers", "8"},
+			check:   func(c Config) interface{} { return c.Orchestrator.Workers },
+			want:    8,
+		},
+		{
+			name:    "step timeout from env var",
+			section: SectionOrchestrator,
+			env:     map[string]string{"ORCHESTRATOR_STEP_TIMEOUT": "500ms"},
+			check:   func(c Config) interface{} { return c.Orchestrator.StepTimeout },
+			want:    Duration(500 * time.Millisecond),
+		},
+		{
+			name:    "retry keeps defaults not in config file",
+			section: SectionOrchestrator,
+			args:    []string{"--retry-backoff", "50ms"},
+			check:   func(c Config) interface{} { return c.Orchestrator.Retry },
+			want:    RetryPolicy{MaxAttempts: 5, Backoff: Duration(50 * time.Millisecond), MaxBackoff: Duration(time.Second)},
+		},
+		{
+			name:    "broker from flag over config file",
+			section: SectionOrchestrator,
+			args:    []string{"--broker-poll-interval", "10ms", "--broker-dir", "broker"},
+			check:   func(c Config) interface{} { return c.Orchestrator.Broker },
+			want:    Broker{Engine: "file", Dir: "broker", PollInterval: Duration(10 * time.Millisecond)},
+		},
+		{
+			name:    "webhook secret from env var over config file",
+			section: SectionOrchestrator,
+			env:     map[string]string{"ORCHESTRATOR_WEBHOOK_SECRET": "from-env"},
+			check:   func(c Config) interface{} { return c.Orchestrator.Webhook.Secret },
+			want:    "from-env",
+		},
+		{
+			name:    "legacy webhook secret env var over config file",
+			section: SectionOrchestrator,
+			env:     map[string]string{EnvWebhookSecret: "legacy"},
+			check:   func(c Config) interface{} { return c.Orchestrator.Webhook.Secret },
+			want:    "legacy",
+		},
+		{
+			name:    "webhook secret env var over legacy one",
+			section: SectionOrchestrator,
+			env:     map[string]string{EnvWebhookSecret: "legacy", "ORCHESTRATOR_WEBHOOK_SECRET": "from-env"},
+			check:   func(c Config) interface{} { return c.Orchestrator.Webhook.Secret },
+			want:    "from-env",
+		},
+		{
+			name:    "chaos rates from env var and flag",
+			section: SectionItem,
+			env:     map[string]string{"ITEM_CHAOS_DROP_RATE": "0.2"},
+			args:    []string{"--chaos-endpoint-failure-rate", "/item-confirmed=0.3", "--chaos-endpoint-failure-rate", "/item-cancelled=1"},
+			check:   func(c Config) interface{} { return c.Item.Chaos },
+			want: Chaos{
+				FailureRate:          0.1,
+				EndpointFailureRates: map[string]float64{"/item-confirmed": 0.3, "/item-cancelled": 1},
+				DropRate:             0.2,
+			},
+		},
+		{
+			name:    "flag of another section is not applied",
+			section: SectionOrder,
+			env:     map[string]string{"ITEM_GRPC_ADDR": ":9101"},
+			args:    []string{"--grpc-addr", ":9102"},
+			check:   func(c Config) interface{} { return []string{c.Item.GRPCAddr, c.Order.GRPCAddr} },
+			want:    []string{":9101", ":9102"},
+		},
+		{
+			name:    "choreography roles from flag over config file",
+			section: SectionChoreography,
+			args:    []string{"--role", "item,order", "--role", "payment"},
+			check:   func(c Config) interface{} { return c.Choreography.Roles },
+			want:    []string{"item", "order", "payment"},
+		},
+		{
+			name:    "choreography bus from env var",
+			section: SectionChoreography,
+			env:     map[string]string{"CHOREOGRAPHY_POLL_INTERVAL": "20ms"},
+			check:   func(c Config) interface{} { return c.Choreography.Bus },
+			want:    Bus{Engine: "file", Dir: "event-bus", PollInterval: Duration(20 * time.Millisecond)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, tt.env)
+
+			cfg, err := run(t, tt.section, append([]string{"--config", configFile(t, file)}, tt.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.check(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromContextInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{
+			name:    "invalid env var",
+			env:     map[string]string{"ORCHESTRATOR_WORKERS": "many"},
+			wantErr: "workers",
+		},
+		{
+			name:    "invalid flag",
+			args:    []string{"--workers", "0"},
+			wantErr: "orchestrator.workers",
+		},
+		{
+			name:    "msg URL without broker",
+			args:    []string{"--storage", "memory", "--payment-url", "msg://payment"},
+			wantErr: "orchestrator.broker.engine",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, tt.env)
+
+			_, err := run(t, SectionOrchestrator, tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want error of %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	setenv(t, map[string]string{
+		"ORCHESTRATOR_WEBHOOK_SECRET": "secret",
+		"PAYMENT_CHAOS_FAILURE_RATE":  "0.5",
+	})
+
+	var buf bytes.Buffer
+	if err := writeConfig(&buf, configFile(t, "orchestrator:\n  workers: 3\n")); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret: secret") {
+		t.Fatalf("webhook secret is printed :\n%s", buf.String())
+	}
+
+	// printed config is a config file giving the same settings
+	want := Default()
+	want.Orchestrator.Workers = 3
+	want.Orchestrator.Webhook.Secret = "<redacted>"
+	want.Payment.Chaos.FailureRate = 0.5
+
+	setenv(t, nil)
+	got, err := Load(configFile(t, buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotBytes, _ := yaml.Marshal(got)
+	wantBytes, _ := yaml.Marshal(want)
+	if !bytes.Equal(gotBytes, wantBytes) {
+		t.Fatalf("printed config loads as :\n%s\nwant :\n%s", gotBytes, wantBytes)
+	}
+
+	if err = writeConfig(&buf, configFile(t, "orchestrator:\n  storage:\n    engine: redis\n")); err == nil {
+		t.Fatal("invalid config is printed")
+	}
+}
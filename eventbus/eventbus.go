@@ -0,0 +1,217 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/storage"
+	"github.com/cikupin/saga-simple-example/uid"
+)
+
+const (
+	// eventLogID is the logID holding every published event
+	eventLogID = "events"
+	// offsetLogPrefix is prepended to consumer name and slot to build
+	// logID of its position
+	offsetLogPrefix = "offset_"
+)
+
+type (
+	// Event defines a published event
+	Event struct {
+		ID     string          `json:"id"`
+		Type   string          `json:"type"`
+		SagaID string          `json:"saga_id"`
+		Time   time.Time       `json:"time"`
+		Data   json.RawMessage `json:"data,omitempty"`
+	}
+
+	// Handler is called for every event received by consumer
+	Handler func(e Event) error
+
+	// Bus defines event bus kept in a storage. In-memory storage makes
+	// in-process bus, while file storage shared by several processes lets
+	// them exchange events. Every consumer receives every event in publish
+	// order, reading only events published after its position, and its
+	// position is persisted so it resumes after restart. Event whose
+	// handler fails is delivered again, so every event is delivered at
+	// least once.
+	Bus struct {
+		storage      storage.Storage
+		pollInterval time.Duration
+
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	}
+
+	// offset defines consumer position in event log. It is saved into one
+	// of two logs in turn, each holding only the latest offset saved into
+	// it, so consumer still has the previous offset if saving fails halfway.
+	offset struct {
+		Seq int   `json:"seq"`
+		Pos int64 `json:"pos"`
+	}
+)
+
+// New will create new event bus kept in given storage. Consumers look for
+// new events every pollInterval.
+func New(s storage.Storage, pollInterval time.Duration) *Bus {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Bus{
+		storage:      s,
+		pollInterval: pollInterval,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Publish will append event of given type into bus
+func (b *Bus) Publish(eventType, sagaID string, data interface{}) error {
+	payloadBytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	id, err := uid.New()
+	if err != nil {
+		return err
+	}
+	return b.Send(id, eventType, sagaID, payloadBytes)
+}
+
+// Send will append event with given ID into bus. Event sent again with
+// the same ID can be recognized by consumers as a duplicate.
+func (b *Bus) Send(id, eventType, sagaID string, data json.RawMessage) error {
+	eventBytes, err := json.Marshal(Event{
+		ID:     id,
+		Type:   eventType,
+		SagaID: sagaID,
+		Time:   time.Now(),
+		Data:   data,
+	})
+	if err != nil {
+		return err
+	}
+	return b.storage.AppendLog(eventLogID, string(eventBytes))
+}
+
+// Events will return every published event in publish order
+func (b *Bus) Events() ([]Event, error) {
+	data, err := b.storage.Lookup(eventLogID)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(data))
+	for _, d := range data {
+		var e Event
+		if err = json.Unmarshal([]byte(d), &e); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Subscribe will start delivering events to handler under given consumer
+// name, starting after the last event delivered to the same consumer
+func (b *Bus) Subscribe(consumer string, handler Handler) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.consume(consumer, handler)
+	}()
+}
+
+// Close will stop every consumer and release storage
+func (b *Bus) Close() error {
+	b.cancel()
+	b.wg.Wait()
+	return b.storage.Close()
+}
+
+func (b *Bus) consume(consumer string, handler Handler) {
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	o := b.offset(consumer)
+	for {
+		o = b.deliver(consumer, o, handler)
+
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver will hand events published after given offset over to handler.
+// It returns offset of the first event which is not yet delivered.
+func (b *Bus) deliver(consumer string, o offset, handler Handler) offset {
+	data, next, err := storage.ReadFrom(b.storage, eventLogID, o.Pos)
+	if err != nil {
+		log.Printf("[%s] failed to read events : %s\n", consumer, err.Error())
+		return o
+	}
+
+	for i, d := range data {
+		var e Event
+		if err = json.Unmarshal([]byte(d), &e); err != nil {
+			log.Printf("[%s] failed to decode event, will retry : %s\n", consumer, err.Error())
+			return o
+		}
+		if err = handler(e); err != nil {
+			log.Printf("[%s] failed to handle %s event %s, will retry : %s\n", consumer, e.Type, e.ID, err.Error())
+			return o
+		}
+
+		o = offset{Seq: o.Seq + 1, Pos: next[i]}
+		if err = b.save(consumer, o); err != nil {
+			log.Printf("[%s] failed to save position : %s\n", consumer, err.Error())
+		}
+	}
+	return o
+}
+
+// offset returns position of the first event which is not yet delivered
+// to consumer, saved last into either of its offset logs
+func (b *Bus) offset(consumer string) offset {
+	var last offset
+	for slot := 0; slot < 2; slot++ {
+		data, err := b.storage.LastLog(offsetLogID(consumer, slot))
+		if err != nil {
+			continue
+		}
+
+		var o offset
+		if err = json.Unmarshal([]byte(data), &o); err == nil && o.Seq > last.Seq {
+			last = o
+		}
+	}
+	return last
+}
+
+// save will replace offset log of consumer which is not holding the
+// previous offset
+func (b *Bus) save(consumer string, o offset) error {
+	offsetBytes, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+
+	logID := offsetLogID(consumer, o.Seq%2)
+	if err = b.storage.Cleanup(logID); err != nil {
+		return err
+	}
+	return b.storage.AppendLog(logID, string(offsetBytes))
+}
+
+func offsetLogID(consumer string, slot int) string {
+	return offsetLogPrefix + consumer + "_" + strconv.Itoa(slot)
+}
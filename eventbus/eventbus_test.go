@@ -0,0 +1,130 @@
+package eventbus
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/storage"
+)
+
+// recorder records IDs of delivered events, failing the ones it is told to
+type recorder struct {
+	ids  []string
+	fail map[string]bool
+}
+
+func (r *recorder) handle(e Event) error {
+	if r.fail[e.ID] {
+		return errors.New("handler failed")
+	}
+	r.ids = append(r.ids, e.ID)
+	return nil
+}
+
+func send(t *testing.T, b *Bus, ids ...string) {
+	for _, id := range ids {
+		if err := b.Send(id, "ItemPurchased", "saga", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event-bus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	engines := []struct {
+		name string
+		open func(t *testing.T) storage.Storage
+	}{
+		{
+			name: storage.EngineMemory,
+			open: func(t *testing.T) storage.Storage { return storage.NewMemoryStorage() },
+		},
+		{
+			name: storage.EngineFile,
+			open: func(t *testing.T) storage.Storage {
+				s, err := storage.NewFileStorage(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+		},
+	}
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			s := engine.open(t)
+			b := New(s, time.Millisecond)
+
+			// events are delivered in publish order
+			send(t, b, "1", "2", "3")
+			r := &recorder{fail: map[string]bool{"3": true}}
+			o := b.deliver("item", b.offset("item"), r.handle)
+			if want := []string{"1", "2"}; !reflect.DeepEqual(r.ids, want) {
+				t.Fatalf("delivered %v, want %v", r.ids, want)
+			}
+
+			// failed event is delivered again, followed by new ones
+			send(t, b, "4")
+			r.fail = nil
+			o = b.deliver("item", o, r.handle)
+			if want := []string{"1", "2", "3", "4"}; !reflect.DeepEqual(r.ids, want) {
+				t.Fatalf("delivered %v, want %v", r.ids, want)
+			}
+
+			// restarted consumer resumes after the last delivered event,
+			// and its position is kept in two logs of one record each
+			send(t, b, "5")
+			b = New(s, time.Millisecond)
+			r = &recorder{}
+			b.deliver("item", b.offset("item"), r.handle)
+			if want := []string{"5"}; !reflect.DeepEqual(r.ids, want) {
+				t.Fatalf("resumed consumer delivered %v, want %v", r.ids, want)
+			}
+			for slot := 0; slot < 2; slot++ {
+				if data, _ := s.Lookup(offsetLogID("item", slot)); len(data) != 1 {
+					t.Errorf("offset log %d holds %d records, want 1", slot, len(data))
+				}
+			}
+
+			// another consumer starts from the first event
+			r = &recorder{}
+			b.deliver("order", b.offset("order"), r.handle)
+			if len(r.ids) != 5 {
+				t.Errorf("new consumer delivered %v, want every event", r.ids)
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	b := New(storage.NewMemoryStorage(), time.Millisecond)
+	defer b.Close()
+
+	delivered := make(chan string, 3)
+	b.Subscribe("item", func(e Event) error {
+		delivered <- e.ID
+		return nil
+	})
+	send(t, b, "1", "2", "3")
+
+	for _, want := range []string{"1", "2", "3"} {
+		select {
+		case id := <-delivered:
+			if id != want {
+				t.Fatalf("delivered %s, want %s", id, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %s is not delivered", want)
+		}
+	}
+}
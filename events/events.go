@@ -0,0 +1,46 @@
+package events
+
+import "github.com/cikupin/saga-simple-example/fault"
+
+// Domain events published by participant services in choreography mode
+const (
+	// PurchaseRequested is published when client buys an item
+	PurchaseRequested = "PurchaseRequested"
+	// ItemReserved is published by item service after item is purchased
+	ItemReserved = "ItemReserved"
+	// ItemReservationFailed is published by item service when item can not be purchased
+	ItemReservationFailed = "ItemReservationFailed"
+	// ItemReleased is published by item service after purchased item is rolled back
+	ItemReleased = "ItemReleased"
+	// OrderCreated is published by order service after order is recorded
+	OrderCreated = "OrderCreated"
+	// OrderFailed is published by order service when order can not be recorded
+	OrderFailed = "OrderFailed"
+	// OrderCancelled is published by order service after recorded order is rolled back
+	OrderCancelled = "OrderCancelled"
+	// PaymentCompleted is published by payment service after order is paid
+	PaymentCompleted = "PaymentCompleted"
+	// PaymentFailed is published by payment service when order can not be paid
+	PaymentFailed = "PaymentFailed"
+)
+
+// Steps of purchase, keys of fault plan
+const (
+	StepPurchaseItem = "purchase-item"
+	StepOrder        = "order"
+	StepPayment      = "payment"
+)
+
+// Purchase defines purchase carried by every domain event. Every
+// participant service fills in its own result before publishing, so
+// later events carry everything needed to compensate earlier steps.
+// Faults is fault plan of the purchase, keyed by step.
+type Purchase struct {
+	Item           string     `json:"item"`
+	Price          int        `json:"price"`
+	PaymentMethod  string     `json:"payment_method"`
+	Faults         fault.Plan `json:"faults,omitempty"`
+	PurchaseItemID int        `json:"purchase_item_id,omitempty"`
+	OrderID        int        `json:"order_id,omitempty"`
+	PaymentID      int        `json:"payment_id,omitempty"`
+}
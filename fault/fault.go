@@ -0,0 +1,235 @@
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderPlan is the buy request header carrying fault plan of a saga
+	HeaderPlan = "X-Fault-Plan"
+	// HeaderInjection is the participant request header carrying fault
+	// injected into a single call
+	HeaderInjection = "X-Fault-Injection"
+)
+
+type (
+	// Duration defines latency, written as duration string in JSON
+	Duration time.Duration
+
+	// Fault defines fault injected into a single participant call. Latency
+	// delays the call, then Fail makes participant fail without processing it.
+	Fault struct {
+		Fail    bool
+		Latency time.Duration
+	}
+
+	// Step defines faults injected into a sub-transaction. Fail fails its
+	// action, FailCompensation fails its compensation, Latency delays its
+	// action and CompensationLatency delays its compensation.
+	Step struct {
+		Fail                bool     `json:"fail,omitempty"`
+		FailCompensation    bool     `json:"fail_compensation,omitempty"`
+		Latency             Duration `json:"latency,omitempty"`
+		CompensationLatency Duration `json:"compensation_latency,omitempty"`
+	}
+
+	// Plan defines faults injected into a saga, keyed by sub-transaction ID
+	Plan map[string]Step
+)
+
+// MarshalJSON will write duration as duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON will read duration from duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ParsePlan will parse fault plan from header value, a comma separated
+// list of directives :
+//
+//	fail=order, fail-compensation=purchase-item, latency=payment:200ms,
+//	compensation-latency=order:1s
+func ParsePlan(value string) (Plan, error) {
+	plan := make(Plan)
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" {
+			continue
+		}
+
+		parts := strings.SplitN(directive, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid fault directive %q", directive)
+		}
+
+		subTxID := parts[1]
+		switch parts[0] {
+		case "fail":
+			step := plan[subTxID]
+			step.Fail = true
+			plan[subTxID] = step
+		case "fail-compensation":
+			step := plan[subTxID]
+			step.FailCompensation = true
+			plan[subTxID] = step
+		case "latency", "compensation-latency":
+			i := strings.LastIndex(subTxID, ":")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid fault directive %q, %s needs step:duration", directive, parts[0])
+			}
+			latency, err := time.ParseDuration(subTxID[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid fault directive %q : %s", directive, err.Error())
+			}
+
+			subTxID = subTxID[:i]
+			step := plan[subTxID]
+			if parts[0] == "latency" {
+				step.Latency = Duration(latency)
+			} else {
+				step.CompensationLatency = Duration(latency)
+			}
+			plan[subTxID] = step
+		default:
+			return nil, fmt.Errorf("unknown fault directive %q", directive)
+		}
+	}
+	return plan, nil
+}
+
+// LoadPlan will read fault plan from JSON file
+func LoadPlan(path string) (Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan Plan
+	if err = json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("invalid fault config %s : %s", path, err.Error())
+	}
+	return plan, nil
+}
+
+// Validate returns error if plan injects faults into unknown sub-transaction
+func (p Plan) Validate(subTxIDs ...string) error {
+	known := make(map[string]bool, len(subTxIDs))
+	for _, id := range subTxIDs {
+		known[id] = true
+	}
+
+	for id := range p {
+		if !known[id] {
+			return fmt.Errorf("unknown step %s in fault plan", id)
+		}
+	}
+	return nil
+}
+
+// Action returns fault injected into action of given sub-transaction
+func (p Plan) Action(subTxID string) Fault {
+	step := p[subTxID]
+	return Fault{Fail: step.Fail, Latency: time.Duration(step.Latency)}
+}
+
+// Compensation returns fault injected into compensation of given sub-transaction
+func (p Plan) Compensation(subTxID string) Fault {
+	step := p[subTxID]
+	return Fault{Fail: step.FailCompensation, Latency: time.Duration(step.CompensationLatency)}
+}
+
+// IsZero returns true if fault injects nothing
+func (f Fault) IsZero() bool {
+	return !f.Fail && f.Latency == 0
+}
+
+// String will format fault as HeaderInjection value, such as
+// "fail, latency=200ms"
+func (f Fault) String() string {
+	var directives []string
+	if f.Fail {
+		directives = append(directives, "fail")
+	}
+	if f.Latency > 0 {
+		directives = append(directives, "latency="+f.Latency.String())
+	}
+	return strings.Join(directives, ", ")
+}
+
+// Parse will parse fault from HeaderInjection value
+func Parse(value string) (Fault, error) {
+	var f Fault
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.TrimSpace(directive)
+		switch {
+		case directive == "":
+		case directive == "fail":
+			f.Fail = true
+		case strings.HasPrefix(directive, "latency="):
+			latency, err := time.ParseDuration(strings.TrimPrefix(directive, "latency="))
+			if err != nil {
+				return f, fmt.Errorf("invalid fault directive %q : %s", directive, err.Error())
+			}
+			f.Latency = latency
+		default:
+			return f, fmt.Errorf("unknown fault directive %q", directive)
+		}
+	}
+	return f, nil
+}
+
+// Middleware will honor fault injected into participant request through
+// HeaderInjection. Failed request is answered with 500 without reaching
+// next, so it changes nothing.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(HeaderInjection)
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		f, err := Parse(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if f.Latency > 0 {
+			select {
+			case <-time.After(f.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if f.Fail {
+			log.Printf("%s %s : FAILED!!! (injected fault)\n", r.Method, r.URL.Path)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]bool{"success": false})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
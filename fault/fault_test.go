@@ -0,0 +1,442 @@
+package fault
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestParsePlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Plan
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  Plan{},
+		},
+		{
+			name:  "every directive",
+			value: "fail=payment, fail-compensation=order, latency=purchase-item:300ms, compensation-latency=order:1s",
+			want: Plan{
+				"payment":       {Fail: true},
+				"order":         {FailCompensation: true, CompensationLatency: Duration(time.Second)},
+				"purchase-item": {Latency: Duration(300 * time.Millisecond)},
+			},
+		},
+		{
+			name:  "directives of the same step are merged",
+			value: "fail=order,latency=order:10ms,",
+			want: Plan{
+				"order": {Fail: true, Latency: Duration(10 * time.Millisecond)},
+			},
+		},
+		{
+			name:    "unknown directive",
+			value:   "boom=order",
+			wantErr: true,
+		},
+		{
+			name:    "missing step",
+			value:   "fail=",
+			wantErr: true,
+		},
+		{
+			name:    "latency without duration",
+			value:   "latency=order",
+			wantErr: true,
+		},
+		{
+			name:    "invalid duration",
+			value:   "compensation-latency=order:soon",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePlan(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePlan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePlan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		data    string
+		want    Plan
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			data: `{"payment":{"fail":true},"order":{"latency":"200ms","fail_compensation":true}}`,
+			want: Plan{
+				"payment": {Fail: true},
+				"order":   {Latency: Duration(200 * time.Millisecond), FailCompensation: true},
+			},
+		},
+		{
+			name:    "invalid duration",
+			data:    `{"order":{"latency":200}}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			data:    `fail=order`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if err := ioutil.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := LoadPlan(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadPlan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadPlan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadPlan(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadPlan() of missing file succeeded")
+	}
+}
+
+func TestPlanJSON(t *testing.T) {
+	plan := Plan{
+		"order": {Fail: true, Latency: Duration(300 * time.Millisecond), CompensationLatency: Duration(time.Second)},
+	}
+
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"order":{"fail":true,"latency":"300ms","compensation_latency":"1s"}}`; string(data) != want {
+		t.Errorf("json = %s, want %s", data, want)
+	}
+
+	var got Plan
+	if err = json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, plan) {
+		t.Errorf("decoded plan = %+v, want %+v", got, plan)
+	}
+}
+
+func TestPlanValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    Plan
+		wantErr bool
+	}{
+		{
+			name: "empty plan",
+		},
+		{
+			name: "known steps",
+			plan: Plan{"order": {Fail: true}, "payment": {Fail: true}},
+		},
+		{
+			name:    "unknown step",
+			plan:    Plan{"shipping": {Fail: true}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.plan.Validate("order", "payment")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPlanFaults(t *testing.T) {
+	plan := Plan{
+		"order": {
+			Fail:                true,
+			Latency:             Duration(time.Millisecond),
+			FailCompensation:    false,
+			CompensationLatency: Duration(time.Second),
+		},
+	}
+
+	tests := []struct {
+		name string
+		got  Fault
+		want Fault
+	}{
+		{
+			name: "action",
+			got:  plan.Action("order"),
+			want: Fault{Fail: true, Latency: time.Millisecond},
+		},
+		{
+			name: "compensation",
+			got:  plan.Compensation("order"),
+			want: Fault{Latency: time.Second},
+		},
+		{
+			name: "step without fault",
+			got:  plan.Action("payment"),
+			want: Fault{},
+		},
+		{
+			name: "nil plan",
+			got:  Plan(nil).Compensation("order"),
+			want: Fault{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("fault = %+v, want %+v", tt.got, tt.want)
+			}
+			if tt.got.IsZero() != (tt.want == Fault{}) {
+				t.Errorf("IsZero() = %v", tt.got.IsZero())
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Fault
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+		},
+		{
+			name:  "fail",
+			value: "fail",
+			want:  Fault{Fail: true},
+		},
+		{
+			name:  "fail and latency",
+			value: "fail, latency=200ms",
+			want:  Fault{Fail: true, Latency: 200 * time.Millisecond},
+		},
+		{
+			name:    "invalid latency",
+			value:   "latency=soon",
+			wantErr: true,
+		},
+		{
+			name:    "unknown directive",
+			value:   "fail-compensation",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+
+			// fault is sent to participant as its String value
+			again, err := Parse(got.String())
+			if err != nil || again != got {
+				t.Errorf("Parse(%q) = %+v, %v, want %+v", got.String(), again, err, got)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		wantCalled  bool
+		wantStatus  int
+		wantLatency time.Duration
+	}{
+		{
+			name:       "no fault",
+			wantCalled: true,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "fail",
+			value:      "fail",
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:        "latency",
+			value:       "latency=20ms",
+			wantCalled:  true,
+			wantStatus:  http.StatusOK,
+			wantLatency: 20 * time.Millisecond,
+		},
+		{
+			name:        "latency then fail",
+			value:       "fail, latency=20ms",
+			wantStatus:  http.StatusInternalServerError,
+			wantLatency: 20 * time.Millisecond,
+		},
+		{
+			name:       "invalid fault",
+			value:      "boom",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/order", nil)
+			if tt.value != "" {
+				req.Header.Set(HeaderInjection, tt.value)
+			}
+			rec := httptest.NewRecorder()
+
+			start := time.Now()
+			h.ServeHTTP(rec, req)
+
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if elapsed := time.Since(start); elapsed < tt.wantLatency {
+				t.Errorf("request took %s, want at least %s", elapsed, tt.wantLatency)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCancelled(t *testing.T) {
+	called := false
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodPost, "/order", nil).WithContext(ctx)
+	req.Header.Set(HeaderInjection, "latency=1m")
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cancelled request waits for injected latency")
+	}
+	if called {
+		t.Error("cancelled request reached handler")
+	}
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		wantCalled bool
+		wantCode   codes.Code
+	}{
+		{
+			name:       "no fault",
+			wantCalled: true,
+			wantCode:   codes.OK,
+		},
+		{
+			name:     "fail",
+			value:    "fail",
+			wantCode: codes.Internal,
+		},
+		{
+			name:       "latency",
+			value:      "latency=10ms",
+			wantCalled: true,
+			wantCode:   codes.OK,
+		},
+		{
+			name:     "invalid fault",
+			value:    "boom",
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.OrderService/Create"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				return req, nil
+			}
+
+			ctx := context.Background()
+			if tt.value != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(HeaderInjection, tt.value))
+			}
+
+			_, err := UnaryInterceptor(ctx, "request", info, handler)
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("code = %s, want %s", code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package fault
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/grpctransport"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryInterceptor will honor fault injected into participant call through
+// HeaderInjection metadata. Failed call is answered with Internal status
+// without reaching handler, so it changes nothing.
+func UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	value := grpctransport.IncomingHeader(ctx, HeaderInjection)
+	if value == "" {
+		return handler(ctx, req)
+	}
+
+	f, err := Parse(value)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if f.Latency > 0 {
+		select {
+		case <-time.After(f.Latency):
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+
+	if f.Fail {
+		log.Printf("%s : FAILED!!! (injected fault)\n", info.FullMethod)
+		return nil, status.Error(codes.Internal, "injected fault")
+	}
+	return handler(ctx, req)
+}
@@ -0,0 +1,102 @@
+package grpctransport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cikupin/saga-simple-example/httpclient"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// NewServer will create gRPC server running given interceptors, in order,
+// before every call. Participant services pass idempotency, fault injection
+// and chaos interceptors, so both transports behave alike.
+func NewServer(interceptors ...grpc.UnaryServerInterceptor) *grpc.Server {
+	return grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+}
+
+// Dial will open connection to gRPC server of participant service. Headers
+// set with httpclient.WithHeader are sent as metadata, and failed call is
+// returned as httpclient.StatusError, so callers handle every transport
+// alike.
+func Dial(addr string) (*grpc.ClientConn, error) {
+	return grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(unaryClientInterceptor),
+	)
+}
+
+func unaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	header := httpclient.Headers(ctx)
+	for key := range header {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(key), header.Get(key))
+	}
+
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil {
+		return nil
+	}
+
+	s, ok := status.FromError(err)
+	if !ok || s.Code() == codes.Canceled || s.Code() == codes.DeadlineExceeded {
+		return err
+	}
+
+	// method is formatted as /package.Service/Method
+	service := strings.SplitN(strings.TrimPrefix(method, "/"), "/", 2)[0]
+	return &httpclient.StatusError{
+		Service:    service,
+		Method:     http.MethodPost,
+		Path:       method,
+		StatusCode: httpStatus(s.Code()),
+		Body:       s.Message(),
+	}
+}
+
+// NotServed returns error of participant service endpoint which has no
+// RPC method serving the same operation
+func NotServed(service, method, path string) error {
+	return &httpclient.StatusError{
+		Service:    service,
+		Method:     method,
+		Path:       path,
+		StatusCode: http.StatusNotImplemented,
+		Body:       fmt.Sprintf("%s is not served through gRPC", path),
+	}
+}
+
+// IncomingHeader returns value of given header sent as metadata of
+// incoming call
+func IncomingHeader(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// httpStatus returns HTTP status of gRPC status code, so gRPC errors get
+// the same causes as responses of HTTP endpoints
+func httpStatus(c codes.Code) int {
+	switch c {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.Aborted:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		return http.StatusUnprocessableEntity
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,250 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// Config defines settings of http.Client shared by participant clients
+	Config struct {
+		Timeout             time.Duration
+		DialTimeout         time.Duration
+		MaxIdleConnsPerHost int
+		IdleConnTimeout     time.Duration
+	}
+
+	// Middleware wraps transport of http.Client, used to add headers,
+	// tracing or logging to every request
+	Middleware func(next http.RoundTripper) http.RoundTripper
+
+	// RoundTripperFunc adapts a function into http.RoundTripper
+	RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+	// Client sends JSON requests to a participant service
+	Client struct {
+		service string
+		baseURL string
+		http    *http.Client
+	}
+
+	// StatusError means participant service responded with non-2xx status.
+	// Err is the cause known for that status, if any.
+	StatusError struct {
+		Service    string
+		Method     string
+		Path       string
+		StatusCode int
+		Body       string
+		Err        error
+	}
+
+	// DecodeError means participant service response could not be decoded
+	DecodeError struct {
+		Service string
+		Path    string
+		Err     error
+	}
+
+	headerKey struct{}
+)
+
+// DefaultConfig defines default http.Client settings
+var DefaultConfig = Config{
+	Timeout:             10 * time.Second,
+	DialTimeout:         time.Second,
+	MaxIdleConnsPerHost: 100,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// RoundTrip calls f
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// NewHTTPClient will create http.Client with pooled connections, wrapped
+// by given middlewares. The first middleware is the outermost.
+func NewHTTPClient(cfg Config, middlewares ...Middleware) *http.Client {
+	var transport http.RoundTripper = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          cfg.MaxIdleConnsPerHost * 4,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		transport = middlewares[i](transport)
+	}
+
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+	}
+}
+
+// Header returns middleware which sets header on every request
+func Header(key, value string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set(key, value)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// Trace returns middleware which logs every request with its status
+// and duration
+func Trace() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				log.Printf("[http] %s %s : %s (%s)\n", req.Method, req.URL, err.Error(), time.Since(start))
+				return resp, err
+			}
+
+			log.Printf("[http] %s %s : %d (%s)\n", req.Method, req.URL, resp.StatusCode, time.Since(start))
+			return resp, err
+		})
+	}
+}
+
+// WithHeader returns context which sets header on request sent with it
+func WithHeader(ctx context.Context, key, value string) context.Context {
+	header := http.Header{}
+	if parent, ok := ctx.Value(headerKey{}).(http.Header); ok {
+		header = parent.Clone()
+	}
+	header.Set(key, value)
+	return context.WithValue(ctx, headerKey{}, header)
+}
+
+// Headers returns headers set on ctx with WithHeader
+func Headers(ctx context.Context) http.Header {
+	header, _ := ctx.Value(headerKey{}).(http.Header)
+	return header
+}
+
+// New will create client of given participant service
+func New(service, baseURL string, httpClient *http.Client) *Client {
+	return &Client{
+		service: service,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    httpClient,
+	}
+}
+
+// Post will send in as JSON to given path and decode response into out
+func (c *Client) Post(ctx context.Context, path string, in, out interface{}) error {
+	payloadBytes, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, path, payloadBytes, out)
+}
+
+// Get will decode response of given path into out
+func (c *Client) Get(ctx context.Context, path string, out interface{}) error {
+	return c.do(ctx, http.MethodGet, path, nil, out)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, payloadBytes []byte, out interface{}) error {
+	var payload io.Reader
+	if payloadBytes != nil {
+		payload = bytes.NewReader(payloadBytes)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, payload)
+	if err != nil {
+		return err
+	}
+	if payloadBytes != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	header := Headers(ctx)
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{
+			Service:    c.service,
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(body)),
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err = json.Unmarshal(body, out); err != nil {
+		return &DecodeError{Service: c.service, Path: path, Err: err}
+	}
+	return nil
+}
+
+func (e *StatusError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("request error to service %s %s : %s", e.Service, e.Path, e.Err.Error())
+	}
+	return fmt.Sprintf("request error to service %s %s : %d %s", e.Service, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// IsClientError returns true if participant service rejected request
+func (e *StatusError) IsClientError() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// IsServerError returns true if participant service failed to process request
+func (e *StatusError) IsServerError() bool {
+	return e.StatusCode >= 500
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("invalid response of service %s %s : %s", e.Service, e.Path, e.Err.Error())
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// WithCause will set cause of StatusError with given status code.
+// Other errors are returned as is.
+func WithCause(err error, statusCode int, cause error) error {
+	if se, ok := err.(*StatusError); ok && se.StatusCode == statusCode {
+		se.Err = cause
+	}
+	return err
+}
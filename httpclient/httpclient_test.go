@@ -0,0 +1,268 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// response defines JSON response of test service
+type response struct {
+	ID int `json:"id"`
+}
+
+func TestClient(t *testing.T) {
+	errCause := errors.New("item is reserved")
+
+	tests := []struct {
+		name       string
+		method     string
+		status     int
+		body       string
+		out        bool
+		wantID     int
+		wantStatus int
+		wantDecode bool
+		wantCause  error
+	}{
+		{
+			name:   "post",
+			method: http.MethodPost,
+			status: http.StatusCreated,
+			body:   `{"id":66}`,
+			out:    true,
+			wantID: 66,
+		},
+		{
+			name:   "get",
+			method: http.MethodGet,
+			status: http.StatusOK,
+			body:   `{"id":32}`,
+			out:    true,
+			wantID: 32,
+		},
+		{
+			name:   "response is not decoded without out",
+			method: http.MethodPost,
+			status: http.StatusOK,
+			body:   `not json`,
+		},
+		{
+			name:       "client error",
+			method:     http.MethodPost,
+			status:     http.StatusConflict,
+			body:       `{"success":false}`,
+			out:        true,
+			wantStatus: http.StatusConflict,
+			wantCause:  errCause,
+		},
+		{
+			name:       "server error",
+			method:     http.MethodPost,
+			status:     http.StatusInternalServerError,
+			body:       `{"success":false}`,
+			out:        true,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "invalid response",
+			method:     http.MethodGet,
+			status:     http.StatusOK,
+			body:       `{"id":"66"}`,
+			out:        true,
+			wantDecode: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != tt.method || r.URL.Path != "/items" {
+					t.Errorf("request = %s %s, want %s /items", r.Method, r.URL.Path, tt.method)
+				}
+				if r.Method == http.MethodPost {
+					body, _ := ioutil.ReadAll(r.Body)
+					if ct := r.Header.Get("Content-Type"); ct != "application/json" || string(body) != `{"item":"book"}` {
+						t.Errorf("request body = %s %s", ct, body)
+					}
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c := New("item", srv.URL+"/", NewHTTPClient(DefaultConfig))
+			var resp response
+			var out interface{}
+			if tt.out {
+				out = &resp
+			}
+
+			var err error
+			if tt.method == http.MethodPost {
+				err = c.Post(context.Background(), "/items", map[string]string{"item": "book"}, out)
+			} else {
+				err = c.Get(context.Background(), "/items", out)
+			}
+			err = WithCause(err, http.StatusConflict, errCause)
+
+			var se *StatusError
+			var de *DecodeError
+			switch {
+			case tt.wantStatus != 0:
+				if !errors.As(err, &se) || se.StatusCode != tt.wantStatus || se.Service != "item" || se.Body != tt.body {
+					t.Fatalf("err = %#v, want StatusError %d", err, tt.wantStatus)
+				}
+				if se.IsClientError() != (tt.wantStatus < 500) || se.IsServerError() != (tt.wantStatus >= 500) {
+					t.Errorf("client error = %v, server error = %v", se.IsClientError(), se.IsServerError())
+				}
+				if !errors.Is(err, tt.wantCause) && tt.wantCause != nil {
+					t.Errorf("err = %v, want cause %v", err, tt.wantCause)
+				}
+				if tt.wantCause == nil && se.Err != nil {
+					t.Errorf("err has cause %v, want none", se.Err)
+				}
+			case tt.wantDecode:
+				if !errors.As(err, &de) || de.Service != "item" || de.Path != "/items" {
+					t.Fatalf("err = %#v, want DecodeError", err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case resp.ID != tt.wantID:
+				t.Errorf("id = %d, want %d", resp.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestClientUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	err := New("item", url, NewHTTPClient(DefaultConfig)).Get(context.Background(), "/items", nil)
+	var se *StatusError
+	if err == nil || errors.As(err, &se) {
+		t.Errorf("err = %v, want network error", err)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		ctx         func() context.Context
+		middlewares []Middleware
+		want        map[string]string
+	}{
+		{
+			name: "no header",
+			ctx:  context.Background,
+			want: map[string]string{"Idempotency-Key": ""},
+		},
+		{
+			name: "context headers",
+			ctx: func() context.Context {
+				ctx := WithHeader(context.Background(), "Idempotency-Key", "s1/item")
+				return WithHeader(ctx, "X-Fault-Injection", "fail")
+			},
+			want: map[string]string{"Idempotency-Key": "s1/item", "X-Fault-Injection": "fail"},
+		},
+		{
+			name: "later context header replaces earlier one",
+			ctx: func() context.Context {
+				ctx := WithHeader(context.Background(), "Idempotency-Key", "s1/item")
+				return WithHeader(ctx, "Idempotency-Key", "s1/item/compensation")
+			},
+			want: map[string]string{"Idempotency-Key": "s1/item/compensation"},
+		},
+		{
+			name:        "middleware headers",
+			ctx:         context.Background,
+			middlewares: []Middleware{Header("X-Caller", "orchestrator"), Trace()},
+			want:        map[string]string{"X-Caller": "orchestrator"},
+		},
+		{
+			name:        "outer middleware runs first",
+			ctx:         context.Background,
+			middlewares: []Middleware{Header("X-Caller", "outer"), Header("X-Caller", "inner")},
+			want:        map[string]string{"X-Caller": "inner"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := make(chan http.Header, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers <- r.Header
+			}))
+			defer srv.Close()
+
+			c := New("item", srv.URL, NewHTTPClient(DefaultConfig, tt.middlewares...))
+			if err := c.Get(tt.ctx(), "/items", nil); err != nil {
+				t.Fatal(err)
+			}
+			got := <-headers
+			for key, value := range tt.want {
+				if got.Get(key) != value {
+					t.Errorf("%s = %q, want %q", key, got.Get(key), value)
+				}
+			}
+		})
+	}
+}
+
+func TestWithHeaderKeepsParent(t *testing.T) {
+	parent := WithHeader(context.Background(), "Idempotency-Key", "s1/item")
+	WithHeader(parent, "Idempotency-Key", "s2/item")
+
+	if got := Headers(parent).Get("Idempotency-Key"); got != "s1/item" {
+		t.Errorf("parent header = %q, want s1/item", got)
+	}
+}
+
+func TestWithCause(t *testing.T) {
+	errCause := errors.New("order not found")
+	errOther := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		err       error
+		wantCause bool
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:      "matching status",
+			err:       &StatusError{Service: "order", Path: "/order", StatusCode: http.StatusNotFound},
+			wantCause: true,
+		},
+		{
+			name: "other status",
+			err:  &StatusError{Service: "order", Path: "/order", StatusCode: http.StatusConflict},
+		},
+		{
+			name: "not a status error",
+			err:  errOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := WithCause(tt.err, http.StatusNotFound, errCause)
+			if errors.Is(err, errCause) != tt.wantCause {
+				t.Errorf("errors.Is(%v, cause) = %v, want %v", err, !tt.wantCause, tt.wantCause)
+			}
+			if tt.wantCause && !strings.Contains(err.Error(), errCause.Error()) {
+				t.Errorf("err = %q, want cause in message", err.Error())
+			}
+			if tt.err == errOther && err != errOther {
+				t.Errorf("err = %v, want it returned as is", err)
+			}
+		})
+	}
+}
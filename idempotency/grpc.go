@@ -0,0 +1,85 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/cikupin/saga-simple-example/grpctransport"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// contentTypeProto is the content type of stored gRPC reply
+const contentTypeProto = "application/grpc+proto"
+
+// UnaryInterceptor will replay stored reply of call whose idempotency key
+// has already been processed on the same method, instead of calling
+// handler. Calls with the same key are processed one at a time.
+func (s *Store) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	key := grpctransport.IncomingHeader(ctx, HeaderKey)
+	if key == "" {
+		return handler(ctx, req)
+	}
+
+	logID := requestLogID(info.FullMethod, key)
+	s.keys.Lock(logID)
+	defer s.keys.Unlock(logID)
+
+	stored, err := s.lookup(logID)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if stored != nil {
+		log.Printf("[idempotency] %s with key %s is already processed, replaying response\n", info.FullMethod, key)
+		grpc.SetHeader(ctx, metadata.Pairs(HeaderReplayed, "true"))
+		return stored.message()
+	}
+
+	reply, err := handler(ctx, req)
+	if err != nil {
+		return reply, err
+	}
+
+	msg, ok := reply.(proto.Message)
+	if !ok {
+		return reply, nil
+	}
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		log.Printf("failed to remember idempotency key %s : %s\n", key, err.Error())
+		return reply, nil
+	}
+
+	data, _ := json.Marshal(response{
+		StatusCode:  http.StatusOK,
+		ContentType: contentTypeProto,
+		Message:     string(msg.ProtoReflect().Descriptor().FullName()),
+		Body:        body,
+	})
+	if err = s.storage.AppendLog(logID, string(data)); err != nil {
+		log.Printf("failed to remember idempotency key %s : %s\n", key, err.Error())
+	}
+	return reply, nil
+}
+
+// message will decode stored gRPC reply
+func (r *response) message() (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(r.Message))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	msg := mt.New().Interface()
+	if err = proto.Unmarshal(r.Body, msg); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return msg, nil
+}
@@ -0,0 +1,161 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/cikupin/saga-simple-example/storage"
+)
+
+const (
+	// HeaderKey is the request header carrying idempotency key
+	HeaderKey = "Idempotency-Key"
+	// HeaderReplayed is set on response which is replayed from store
+	HeaderReplayed = "Idempotent-Replayed"
+
+	// logPrefix is prepended to hashed request path and key to build its logID
+	logPrefix = "idempotency_"
+)
+
+type (
+	// response defines stored response of a processed request. Message
+	// is the full name of protobuf message of stored gRPC reply.
+	response struct {
+		StatusCode  int    `json:"status_code"`
+		ContentType string `json:"content_type,omitempty"`
+		Message     string `json:"message,omitempty"`
+		Body        []byte `json:"body"`
+	}
+
+	// Store remembers response of every request processed with an
+	// idempotency key. Only successful responses are remembered, since
+	// failed request has no side effect and is safe to process again.
+	Store struct {
+		storage storage.Storage
+		keys    *KeyLock
+	}
+)
+
+// NewStore will create new idempotency store backed by given storage
+func NewStore(s storage.Storage) *Store {
+	return &Store{
+		storage: s,
+		keys:    NewKeyLock(),
+	}
+}
+
+// Open will create new idempotency store kept in given directory,
+// or in memory when dir is empty
+func Open(dir string) (*Store, error) {
+	if dir == "" {
+		return NewStore(storage.NewMemoryStorage()), nil
+	}
+
+	s, err := storage.NewFileStorage(dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(s), nil
+}
+
+// Close releases resources held by store
+func (s *Store) Close() error {
+	return s.storage.Close()
+}
+
+// Middleware will replay stored response of request whose idempotency key
+// has already been processed on the same path, instead of calling next.
+// Requests with the same key are processed one at a time.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logID := requestLogID(r.URL.Path, key)
+		s.keys.Lock(logID)
+		defer s.keys.Unlock(logID)
+
+		stored, err := s.lookup(logID)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if stored != nil {
+			log.Printf("[idempotency] %s with key %s is already processed, replaying response\n", r.URL.Path, key)
+			stored.write(w)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.statusCode < 200 || rec.statusCode >= 300 {
+			return
+		}
+
+		data, _ := json.Marshal(response{
+			StatusCode:  rec.statusCode,
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		})
+		if err = s.storage.AppendLog(logID, string(data)); err != nil {
+			log.Printf("failed to remember idempotency key %s : %s\n", key, err.Error())
+		}
+	})
+}
+
+func (s *Store) lookup(logID string) (*response, error) {
+	data, err := s.storage.Lookup(logID)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	var stored response
+	if err = json.Unmarshal([]byte(data[len(data)-1]), &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func (r *response) write(w http.ResponseWriter) {
+	if r.ContentType != "" {
+		w.Header().Set("Content-Type", r.ContentType)
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(r.StatusCode)
+	w.Write(r.Body)
+}
+
+// recorder keeps a copy of response written by handler
+type recorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// requestLogID hashes request path and key, since key may contain
+// characters which are not allowed in logID
+func requestLogID(path, key string) string {
+	sum := sha256.Sum256([]byte(path + "\n" + key))
+	return logPrefix + hex.EncodeToString(sum[:])
+}
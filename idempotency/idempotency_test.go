@@ -0,0 +1,241 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// call defines request sent through middleware
+type call struct {
+	path string
+	key  string
+	// status is answered by handler when it is called
+	status int
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "idempotency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name  string
+		calls []call
+		// wantHandled lists whether each call reaches handler
+		wantHandled []bool
+	}{
+		{
+			name:        "duplicate key is replayed",
+			calls:       []call{{"/pay", "k1", http.StatusCreated}, {"/pay", "k1", http.StatusCreated}},
+			wantHandled: []bool{true, false},
+		},
+		{
+			name:        "different keys are processed",
+			calls:       []call{{"/pay", "k1", http.StatusOK}, {"/pay", "k2", http.StatusOK}},
+			wantHandled: []bool{true, true},
+		},
+		{
+			name:        "same key on another path is processed",
+			calls:       []call{{"/pay", "k1", http.StatusOK}, {"/refund", "k1", http.StatusOK}},
+			wantHandled: []bool{true, true},
+		},
+		{
+			name:        "failed response is not remembered",
+			calls:       []call{{"/pay", "k1", http.StatusInternalServerError}, {"/pay", "k1", http.StatusOK}, {"/pay", "k1", http.StatusOK}},
+			wantHandled: []bool{true, true, false},
+		},
+		{
+			name:        "request without key is always processed",
+			calls:       []call{{"/pay", "", http.StatusOK}, {"/pay", "", http.StatusOK}},
+			wantHandled: []bool{true, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := Open("")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var status, handled int
+			handler := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handled++
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				fmt.Fprintf(w, `{"call":%d}`, handled)
+			}))
+
+			first := make(map[string]string)
+			for i, c := range tt.calls {
+				status = c.status
+				before := handled
+				rec := serve(handler, c)
+
+				if got := handled > before; got != tt.wantHandled[i] {
+					t.Fatalf("call %d handled = %v, want %v", i, got, tt.wantHandled[i])
+				}
+				if rec.Code != c.status {
+					t.Errorf("call %d status = %d, want %d", i, rec.Code, c.status)
+				}
+
+				replayed := rec.Header().Get(HeaderReplayed) == "true"
+				if replayed == tt.wantHandled[i] {
+					t.Errorf("call %d replayed = %v", i, replayed)
+				}
+				if !replayed {
+					first[c.path+c.key] = rec.Body.String()
+					continue
+				}
+				if rec.Body.String() != first[c.path+c.key] || rec.Header().Get("Content-Type") != "application/json" {
+					t.Errorf("call %d replayed %q, want %q", i, rec.Body.String(), first[c.path+c.key])
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareConcurrent(t *testing.T) {
+	store, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	handled := 0
+	handler := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		handled++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(handler, call{path: "/pay", key: "k1"})
+		}()
+	}
+	wg.Wait()
+
+	if handled != 1 {
+		t.Fatalf("handled %d times, want once", handled)
+	}
+}
+
+func TestStorePersisted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	handled := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(store.Middleware(next), call{path: "/pay", key: "k1"})
+	store.Close()
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	rec := serve(reopened.Middleware(next), call{path: "/pay", key: "k1"})
+	if handled != 1 || rec.Code != http.StatusCreated || rec.Header().Get(HeaderReplayed) != "true" {
+		t.Fatalf("handled = %d, status = %d, want response replayed after reopen", handled, rec.Code)
+	}
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	handled := 0
+	fail := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled++
+		if fail {
+			return nil, status.Error(codes.Aborted, "conflict")
+		}
+		return wrapperspb.String(fmt.Sprintf("call %d", handled)), nil
+	}
+	call := func(store *Store, method, key string) (interface{}, error) {
+		ctx := context.Background()
+		if key != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(HeaderKey, key))
+		}
+		return store.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fail = true
+	if _, err = call(store, "/item.ItemService/Purchase", "k1"); status.Code(err) != codes.Aborted {
+		t.Fatalf("failed call error = %v, want aborted", err)
+	}
+	fail = false
+	first, err := call(store, "/item.ItemService/Purchase", "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = call(store, "/item.ItemService/Confirm", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// reply is replayed after reopen, decoded into its own message type
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	replayed, err := call(reopened, "/item.ItemService/Purchase", "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled != 3 || !proto.Equal(replayed.(proto.Message), first.(proto.Message)) {
+		t.Fatalf("handled = %d, replayed %v, want %v replayed", handled, replayed, first)
+	}
+
+	if _, err = call(reopened, "/item.ItemService/Purchase", ""); err != nil || handled != 4 {
+		t.Fatalf("call without key handled = %d, error = %v", handled, err)
+	}
+}
+
+func serve(handler http.Handler, c call) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader("{}"))
+	if c.key != "" {
+		req.Header.Set(HeaderKey, c.key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
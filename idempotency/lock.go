@@ -0,0 +1,39 @@
+package idempotency
+
+import "sync"
+
+// KeyLock serializes work done with the same key, while work with
+// different keys goes on concurrently
+type KeyLock struct {
+	mu     sync.Mutex
+	locked map[string]chan struct{}
+}
+
+// NewKeyLock will create new key lock with no key locked
+func NewKeyLock() *KeyLock {
+	return &KeyLock{locked: make(map[string]chan struct{})}
+}
+
+// Lock will wait until no other caller holds given key, and then lock it
+func (l *KeyLock) Lock(key string) {
+	for {
+		l.mu.Lock()
+		done, ok := l.locked[key]
+		if !ok {
+			l.locked[key] = make(chan struct{})
+			l.mu.Unlock()
+			return
+		}
+		l.mu.Unlock()
+		<-done
+	}
+}
+
+// Unlock will release given key, waking up callers waiting for it
+func (l *KeyLock) Unlock(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	close(l.locked[key])
+	delete(l.locked, key)
+}
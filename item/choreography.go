@@ -0,0 +1,94 @@
+package item
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/eventbus"
+	"github.com/cikupin/saga-simple-example/events"
+	"github.com/cikupin/saga-simple-example/outbox"
+)
+
+// lastIDKey is store key of the latest purchase item ID
+const lastIDKey = "item-last-id"
+
+// SubscribeEvents will make item service take part in choreography saga.
+// It reserves item when purchase is requested, confirms it once payment
+// is completed, and rolls it back when order fails or is cancelled.
+// Item reserved by another saga is not purchased, and fault plan of the
+// purchase may fail or delay its step. Item and event telling
+// about it are committed together into store, which relays the event.
+func SubscribeEvents(bus *eventbus.Bus, store *outbox.Outbox) {
+	bus.Subscribe("item", func(e eventbus.Event) error {
+		var purchase events.Purchase
+		if err := json.Unmarshal(e.Data, &purchase); err != nil {
+			return err
+		}
+
+		switch e.Type {
+		case events.PurchaseRequested:
+			time.Sleep(purchase.Faults.Action(events.StepPurchaseItem).Latency)
+		case events.OrderFailed, events.OrderCancelled:
+			time.Sleep(purchase.Faults.Compensation(events.StepPurchaseItem).Latency)
+		}
+
+		return store.Update(e.ID, func(tx *outbox.Tx) error {
+			key := "item/" + purchase.Item
+
+			var purchased Item
+			found, err := tx.Get(key, &purchased)
+			if err != nil {
+				return err
+			}
+
+			switch e.Type {
+			case events.PurchaseRequested:
+				if purchase.Faults.Action(events.StepPurchaseItem).Fail {
+					log.Printf("purchase item %s : FAILED!!! (injected fault)\n", purchase.Item)
+					return tx.Publish(events.ItemReservationFailed, e.SagaID, purchase)
+				}
+				if found && purchased.Status == StatusReserved && purchased.SagaID != e.SagaID {
+					log.Printf("purchase item %s : %s (saga %s)\n", purchase.Item, ErrItemReserved.Error(), purchased.SagaID)
+					return tx.Publish(events.ItemReservationFailed, e.SagaID, purchase)
+				}
+
+				purchase.PurchaseItemID, err = tx.Sequence(lastIDKey, firstID)
+				if err != nil {
+					return err
+				}
+				err = tx.Set(key, Item{
+					Item:           purchase.Item,
+					PurchaseItemID: purchase.PurchaseItemID,
+					SagaID:         e.SagaID,
+					Status:         StatusReserved,
+				})
+				if err != nil {
+					return err
+				}
+
+				log.Printf("[purchase item ID %d] purchase item %s : reserved\n", purchase.PurchaseItemID, purchase.Item)
+				return tx.Publish(events.ItemReserved, e.SagaID, purchase)
+			case events.PaymentCompleted:
+				if !found || purchased.SagaID != e.SagaID {
+					return nil
+				}
+				purchased.Status = StatusPurchased
+
+				log.Printf("[purchase item ID %d] confirm purchased item : success\n", purchased.PurchaseItemID)
+				return tx.Set(key, purchased)
+			case events.OrderFailed, events.OrderCancelled:
+				if found && purchased.SagaID == e.SagaID {
+					purchased.Status = StatusReleased
+					if err := tx.Set(key, purchased); err != nil {
+						return err
+					}
+				}
+
+				log.Printf("[rollback] rollback purchase_item_id %d : success\n", purchase.PurchaseItemID)
+				return tx.Publish(events.ItemReleased, e.SagaID, purchase)
+			}
+			return nil
+		})
+	})
+}
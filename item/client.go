@@ -0,0 +1,76 @@
+package item
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/cikupin/saga-simple-example/broker"
+	"github.com/cikupin/saga-simple-example/config"
+	"github.com/cikupin/saga-simple-example/httpclient"
+	"github.com/cikupin/saga-simple-example/proto/itempb"
+	"github.com/cikupin/saga-simple-example/transport"
+	"google.golang.org/grpc"
+)
+
+// Channel is the message broker channel of item service commands, named
+// after its config section as msg:// URL of orchestrator config must be
+const Channel = config.SectionItem
+
+// Client calls item service
+type Client struct {
+	client transport.Transport
+}
+
+// NewClient will create item service client with given base URL
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	return &Client{
+		client: httpclient.New("item", baseURL, httpClient),
+	}
+}
+
+// NewGRPCClient will create item service client calling ItemService of
+// its gRPC server through conn
+func NewGRPCClient(conn *grpc.ClientConn) *Client {
+	return &Client{
+		client: grpcClient{rpc: itempb.NewItemServiceClient(conn)},
+	}
+}
+
+// NewBrokerClient will create item service client sending commands into
+// given message broker channel
+func NewBrokerClient(b broker.Broker, channel string) *Client {
+	return &Client{
+		client: broker.NewClient(b, channel, channel+"-replies"),
+	}
+}
+
+// Purchase will reserve item for the saga in request. It fails with
+// ErrItemReserved cause when item is reserved by another saga.
+func (c *Client) Purchase(ctx context.Context, req Request) (Response, error) {
+	var resp Response
+	err := c.client.Post(ctx, "/item-purchased", req, &resp)
+	return resp, httpclient.WithCause(err, http.StatusConflict, ErrItemReserved)
+}
+
+// Confirm will confirm reserved item, releasing its lock
+func (c *Client) Confirm(ctx context.Context, req ConfirmationRequest) (Response, error) {
+	var resp Response
+	err := c.client.Post(ctx, "/item-confirmed", req, &resp)
+	err = httpclient.WithCause(err, http.StatusNotFound, ErrPurchaseNotFound)
+	return resp, httpclient.WithCause(err, http.StatusConflict, ErrPurchaseFinished)
+}
+
+// Compensate will roll back reserved item, releasing its lock
+func (c *Client) Compensate(ctx context.Context, req CompensationRequest) (Response, error) {
+	var resp Response
+	err := c.client.Post(ctx, "/item-compensated", req, &resp)
+	return resp, httpclient.WithCause(err, http.StatusConflict, ErrPurchaseFinished)
+}
+
+// Get will return latest purchase of given item
+func (c *Client) Get(ctx context.Context, name string) (Item, error) {
+	var it Item
+	err := c.client.Get(ctx, "/items/"+url.PathEscape(name), &it)
+	return it, err
+}
@@ -0,0 +1,112 @@
+package item
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cikupin/saga-simple-example/httpclient"
+	"github.com/cikupin/saga-simple-example/idempotency"
+	"github.com/cikupin/saga-simple-example/outbox"
+	"github.com/gorilla/mux"
+)
+
+func TestClient(t *testing.T) {
+	store, err := outbox.OpenDir("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items = newInventory(store)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/item-purchased", purchaseItem).Methods(http.MethodPost)
+	r.HandleFunc("/item-confirmed", purchaseItemConfirmed).Methods(http.MethodPost)
+	r.HandleFunc("/item-compensated", purchaseItemCompensated).Methods(http.MethodPost)
+	r.HandleFunc("/items/{item}", getItem).Methods(http.MethodGet)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	c := NewClient(srv.URL, httpclient.NewHTTPClient(httpclient.DefaultConfig))
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() (Response, error)
+		// wantID is purchase item ID of successful call
+		wantID     int
+		wantCause  error
+		wantStatus int
+	}{
+		{
+			name:   "purchase",
+			call:   func() (Response, error) { return c.Purchase(ctx, Request{Item: "book", SagaID: "s1"}) },
+			wantID: 66,
+		},
+		{
+			name: "purchase again with the same key",
+			call: func() (Response, error) {
+				ctx := httpclient.WithHeader(ctx, idempotency.HeaderKey, "s1/item")
+				return c.Purchase(ctx, Request{Item: "book", SagaID: "s1"})
+			},
+			wantID: 66,
+		},
+		{
+			name:       "item reserved by another saga",
+			call:       func() (Response, error) { return c.Purchase(ctx, Request{Item: "book", SagaID: "s2"}) },
+			wantCause:  ErrItemReserved,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "unknown purchase",
+			call:       func() (Response, error) { return c.Confirm(ctx, ConfirmationRequest{PurchaseItemID: 99}) },
+			wantCause:  ErrPurchaseNotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:   "confirm",
+			call:   func() (Response, error) { return c.Confirm(ctx, ConfirmationRequest{PurchaseItemID: 66}) },
+			wantID: 66,
+		},
+		{
+			name:       "compensate confirmed purchase",
+			call:       func() (Response, error) { return c.Compensate(ctx, CompensationRequest{PurchaseItemID: 66}) },
+			wantCause:  ErrPurchaseFinished,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "compensate unknown purchase",
+			call: func() (Response, error) { return c.Compensate(ctx, CompensationRequest{PurchaseItemID: 99}) },
+		},
+	}
+
+	for _, tt := range tests {
+		resp, err := tt.call()
+		if tt.wantCause == nil {
+			if err != nil {
+				t.Fatalf("%s : %v", tt.name, err)
+			}
+			if !resp.Success || resp.PuchaseItemID != tt.wantID {
+				t.Fatalf("%s : response = %+v, want purchase item ID %d", tt.name, resp, tt.wantID)
+			}
+			continue
+		}
+
+		var se *httpclient.StatusError
+		if !errors.Is(err, tt.wantCause) || !errors.As(err, &se) || se.StatusCode != tt.wantStatus {
+			t.Fatalf("%s : err = %v, want %d with cause %v", tt.name, err, tt.wantStatus, tt.wantCause)
+		}
+	}
+
+	it, err := c.Get(ctx, "book")
+	if err != nil || it.Status != StatusPurchased || it.SagaID != "s1" {
+		t.Errorf("item = %+v, %v, want purchased by s1", it, err)
+	}
+
+	_, err = c.Get(ctx, "pen")
+	var se *httpclient.StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusNotFound || !se.IsClientError() {
+		t.Errorf("err of unknown item = %v, want 404", err)
+	}
+}
@@ -0,0 +1,117 @@
+package item
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/cikupin/saga-simple-example/grpctransport"
+	"github.com/cikupin/saga-simple-example/idempotency"
+	"github.com/cikupin/saga-simple-example/proto/itempb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type (
+	// grpcServer serves ItemService of proto/item.proto
+	grpcServer struct {
+		itempb.UnimplementedItemServiceServer
+	}
+
+	// grpcClient calls item service endpoints through ItemService client
+	grpcClient struct {
+		rpc itempb.ItemServiceClient
+	}
+)
+
+// Purchase will reserve item for the saga in request. It fails with
+// Aborted status when item is reserved by another saga.
+func (grpcServer) Purchase(ctx context.Context, req *itempb.Request) (*itempb.Response, error) {
+	purchased, err := items.reserve(grpctransport.IncomingHeader(ctx, idempotency.HeaderKey), req.Item, req.SagaId)
+	if err != nil {
+		log.Printf("purchase item %s : %s (saga %s)\n", req.Item, err.Error(), purchased.SagaID)
+
+		if err != ErrItemReserved {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	log.Printf("[purchase item ID %d] purchase item %s : reserved\n", purchased.PurchaseItemID, req.Item)
+	return &itempb.Response{
+		PurchaseItemId: int64(purchased.PurchaseItemID),
+		Success:        true,
+	}, nil
+}
+
+// Confirm will confirm reserved item once the saga which reserved it has
+// succeeded, releasing its lock
+func (grpcServer) Confirm(ctx context.Context, req *itempb.ConfirmationRequest) (*itempb.Response, error) {
+	if _, err := items.confirm(grpctransport.IncomingHeader(ctx, idempotency.HeaderKey), int(req.PurchaseItemId)); err != nil {
+		log.Printf("confirm purchase_item_id %d : %s\n", req.PurchaseItemId, err.Error())
+
+		switch err {
+		case ErrPurchaseNotFound:
+			return nil, status.Error(codes.NotFound, err.Error())
+		case ErrPurchaseFinished:
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("[purchase item ID %d] confirm purchased item : success\n", req.PurchaseItemId)
+	return &itempb.Response{
+		PurchaseItemId: req.PurchaseItemId,
+		Success:        true,
+	}, nil
+}
+
+// Compensate will roll back reserved item, releasing its lock. Unknown
+// purchase has nothing to roll back.
+func (grpcServer) Compensate(ctx context.Context, req *itempb.CompensationRequest) (*itempb.Response, error) {
+	if _, err := items.release(grpctransport.IncomingHeader(ctx, idempotency.HeaderKey), int(req.PurchaseItemId)); err != nil && err != ErrPurchaseNotFound {
+		log.Printf("[rollback] rollback purchase_item_id %d : %s\n", req.PurchaseItemId, err.Error())
+
+		if err != ErrPurchaseFinished {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	log.Printf("[rollback] rollback purchase_item_id %d : success\n", req.PurchaseItemId)
+	return &itempb.Response{Success: true}, nil
+}
+
+// Post will call RPC method serving the same operation as given endpoint
+func (c grpcClient) Post(ctx context.Context, path string, in, out interface{}) error {
+	var reply *itempb.Response
+	var err error
+
+	switch path {
+	case "/item-purchased":
+		req := in.(Request)
+		reply, err = c.rpc.Purchase(ctx, &itempb.Request{Item: req.Item, SagaId: req.SagaID})
+	case "/item-confirmed":
+		req := in.(ConfirmationRequest)
+		reply, err = c.rpc.Confirm(ctx, &itempb.ConfirmationRequest{PurchaseItemId: int64(req.PurchaseItemID)})
+	case "/item-compensated":
+		req := in.(CompensationRequest)
+		reply, err = c.rpc.Compensate(ctx, &itempb.CompensationRequest{PurchaseItemId: int64(req.PurchaseItemID)})
+	default:
+		return grpctransport.NotServed("item", http.MethodPost, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp, ok := out.(*Response); ok {
+		resp.PuchaseItemID = int(reply.PurchaseItemId)
+		resp.Success = reply.Success
+	}
+	return nil
+}
+
+// Get is not served through gRPC
+func (c grpcClient) Get(ctx context.Context, path string, out interface{}) error {
+	return grpctransport.NotServed("item", http.MethodGet, path)
+}
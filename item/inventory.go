@@ -0,0 +1,146 @@
+package item
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/cikupin/saga-simple-example/outbox"
+)
+
+const (
+	// StatusReserved means item is purchased by a saga which has not
+	// finished yet. Reserved item is locked, so no other saga can
+	// purchase it until the saga confirms or rolls it back.
+	StatusReserved = "ITEM_RESERVED"
+	// StatusPurchased means purchase of item has been confirmed
+	StatusPurchased = "ITEM_PURCHASED"
+	// StatusReleased means reserved item has been rolled back
+	StatusReleased = "ITEM_RELEASED"
+
+	// firstID is ID of the first purchase
+	firstID = 66
+)
+
+var (
+	// ErrItemReserved means item is locked by another saga
+	ErrItemReserved = errors.New("item is reserved by another saga")
+	// ErrPurchaseNotFound means there is no purchase with given ID
+	ErrPurchaseNotFound = errors.New("purchase item not found")
+	// ErrPurchaseFinished means purchase has already been confirmed or
+	// rolled back, so it can no longer change the other way
+	ErrPurchaseFinished = errors.New("purchase item is already finished")
+)
+
+// Item defines purchase of an item, kept by item service. SagaID is the
+// saga which purchased item, holding its lock while item is reserved.
+type Item struct {
+	Item           string `json:"item"`
+	PurchaseItemID int    `json:"purchase_item_id"`
+	SagaID         string `json:"saga_id"`
+	Status         string `json:"status"`
+}
+
+// inventory defines purchases of item service, kept in its store together
+// with reply of every request changing them. Only latest purchase of an
+// item may hold its lock.
+type inventory struct {
+	store *outbox.Outbox
+}
+
+func newInventory(store *outbox.Outbox) *inventory {
+	return &inventory{store: store}
+}
+
+// reserve will lock item for given saga. Saga reserving item it already
+// holds gets its existing reservation back. Request with the same key
+// gets the same reservation, even when it is sent again after a crash.
+func (inv *inventory) reserve(key, name, sagaID string) (Item, error) {
+	var it Item
+	err := inv.store.Do(outbox.RequestKey("reserve", key), &it, func(tx *outbox.Tx) error {
+		var id int
+		found, err := tx.Get(latestKey(name), &id)
+		if err != nil {
+			return err
+		}
+		if found {
+			if _, err = tx.Get(purchaseKey(id), &it); err != nil {
+				return err
+			}
+			if it.Status == StatusReserved {
+				if it.SagaID != sagaID {
+					return ErrItemReserved
+				}
+				return nil
+			}
+		}
+
+		id, err = tx.Sequence(lastIDKey, firstID)
+		if err != nil {
+			return err
+		}
+		it = Item{
+			Item:           name,
+			PurchaseItemID: id,
+			SagaID:         sagaID,
+			Status:         StatusReserved,
+		}
+		if err = tx.Set(purchaseKey(id), it); err != nil {
+			return err
+		}
+		return tx.Set(latestKey(name), id)
+	})
+	return it, err
+}
+
+// confirm will mark reserved item as purchased, releasing its lock
+func (inv *inventory) confirm(key string, purchaseItemID int) (Item, error) {
+	return inv.finish(outbox.RequestKey("confirm", key), purchaseItemID, StatusPurchased)
+}
+
+// release will roll back reserved item, releasing its lock
+func (inv *inventory) release(key string, purchaseItemID int) (Item, error) {
+	return inv.finish(outbox.RequestKey("release", key), purchaseItemID, StatusReleased)
+}
+
+// finish will move reserved purchase into given status. Finishing purchase
+// again with the same status does nothing.
+func (inv *inventory) finish(key string, purchaseItemID int, status string) (Item, error) {
+	var it Item
+	err := inv.store.Do(key, &it, func(tx *outbox.Tx) error {
+		found, err := tx.Get(purchaseKey(purchaseItemID), &it)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrPurchaseNotFound
+		}
+		if it.Status != StatusReserved && it.Status != status {
+			return ErrPurchaseFinished
+		}
+
+		it.Status = status
+		return tx.Set(purchaseKey(purchaseItemID), it)
+	})
+	return it, err
+}
+
+// get returns latest purchase of given item
+func (inv *inventory) get(name string) (Item, bool, error) {
+	var id int
+	found, err := inv.store.Get(latestKey(name), &id)
+	if err != nil || !found {
+		return Item{}, false, err
+	}
+
+	var it Item
+	found, err = inv.store.Get(purchaseKey(id), &it)
+	return it, found, err
+}
+
+func purchaseKey(purchaseItemID int) string {
+	return "purchase/" + strconv.Itoa(purchaseItemID)
+}
+
+func latestKey(name string) string {
+	return "latest/" + name
+}
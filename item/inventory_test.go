@@ -0,0 +1,135 @@
+package item
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cikupin/saga-simple-example/outbox"
+)
+
+// step defines one request made to inventory
+type step struct {
+	op     string
+	key    string
+	item   string
+	sagaID string
+	id     int
+	// restart reopens inventory from its store before the request
+	restart bool
+	wantID  int
+	wantErr error
+}
+
+func TestInventory(t *testing.T) {
+	tests := []struct {
+		name       string
+		steps      []step
+		wantStatus string
+	}{
+		{
+			name: "reserve and confirm",
+			steps: []step{
+				{op: "reserve", key: "s1/item", item: "book", sagaID: "s1", wantID: 66},
+				{op: "confirm", key: "s1/item/confirmation", id: 66, wantID: 66},
+			},
+			wantStatus: StatusPurchased,
+		},
+		{
+			name: "reserved item is locked",
+			steps: []step{
+				{op: "reserve", key: "s1/item", item: "book", sagaID: "s1", wantID: 66},
+				{op: "reserve", key: "s2/item", item: "book", sagaID: "s2", wantID: 66, wantErr: ErrItemReserved},
+				{op: "release", key: "s1/item/compensation", id: 66, wantID: 66},
+				{op: "reserve", key: "s2/item", item: "book", sagaID: "s2", wantID: 67},
+			},
+			wantStatus: StatusReserved,
+		},
+		{
+			name: "finished purchase can not change the other way",
+			steps: []step{
+				{op: "reserve", key: "s1/item", item: "book", sagaID: "s1", wantID: 66},
+				{op: "release", key: "s1/item/compensation", id: 66, wantID: 66},
+				{op: "confirm", key: "s1/item/confirmation", id: 66, wantID: 66, wantErr: ErrPurchaseFinished},
+				{op: "release", id: 66, wantID: 66},
+			},
+			wantStatus: StatusReleased,
+		},
+		{
+			name: "unknown purchase",
+			steps: []step{
+				{op: "confirm", key: "s1/item/confirmation", id: 99, wantErr: ErrPurchaseNotFound},
+			},
+		},
+		{
+			name: "request sent again after restart gets the same reply",
+			steps: []step{
+				{op: "reserve", key: "s1/item", item: "book", sagaID: "s1", wantID: 66},
+				{op: "release", key: "s1/item/compensation", id: 66, wantID: 66},
+				{op: "reserve", key: "s1/item", item: "book", sagaID: "s1", wantID: 66, restart: true},
+				{op: "reserve", key: "s2/item", item: "book", sagaID: "s2", wantID: 67},
+			},
+			wantStatus: StatusReserved,
+		},
+		{
+			name: "request without key is processed every time",
+			steps: []step{
+				{op: "reserve", item: "book", sagaID: "s1", wantID: 66},
+				{op: "release", id: 66, wantID: 66},
+				{op: "reserve", item: "book", sagaID: "s1", wantID: 67, restart: true},
+			},
+			wantStatus: StatusReserved,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "item")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			store, err := outbox.OpenDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inv := newInventory(store)
+
+			for i, s := range tt.steps {
+				if s.restart {
+					store.Close()
+					if store, err = outbox.OpenDir(dir); err != nil {
+						t.Fatal(err)
+					}
+					inv = newInventory(store)
+				}
+
+				var it Item
+				switch s.op {
+				case "reserve":
+					it, err = inv.reserve(s.key, s.item, s.sagaID)
+				case "confirm":
+					it, err = inv.confirm(s.key, s.id)
+				case "release":
+					it, err = inv.release(s.key, s.id)
+				}
+				if err != s.wantErr {
+					t.Fatalf("step %d %s : err = %v, want %v", i, s.op, err, s.wantErr)
+				}
+				if it.PurchaseItemID != s.wantID {
+					t.Fatalf("step %d %s : purchase item ID = %d, want %d", i, s.op, it.PurchaseItemID, s.wantID)
+				}
+			}
+
+			it, found, err := inv.get("book")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != (tt.wantStatus != "") || it.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", it.Status, tt.wantStatus)
+			}
+			store.Close()
+		})
+	}
+}
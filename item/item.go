@@ -4,19 +4,36 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
 
+	"github.com/cikupin/saga-simple-example/broker"
+	"github.com/cikupin/saga-simple-example/chaos"
+	"github.com/cikupin/saga-simple-example/config"
+	"github.com/cikupin/saga-simple-example/fault"
+	"github.com/cikupin/saga-simple-example/grpctransport"
+	"github.com/cikupin/saga-simple-example/idempotency"
+	"github.com/cikupin/saga-simple-example/outbox"
+	"github.com/cikupin/saga-simple-example/proto/itempb"
 	"github.com/gorilla/mux"
 	"github.com/urfave/cli"
+	"google.golang.org/grpc"
 )
 
 type (
-	// Request defines item request
+	// Request defines item request. Item is reserved for the saga with
+	// given ID until the saga confirms or rolls it back.
 	Request struct {
-		Item string `json:"item"`
+		Item   string `json:"item"`
+		SagaID string `json:"saga_id"`
+	}
+
+	// ConfirmationRequest defines item confirmation request
+	ConfirmationRequest struct {
+		PurchaseItemID int `json:"purchase_item_id"`
 	}
 
 	// CompensationRequest defines item compensation request
@@ -37,29 +54,81 @@ var Serve = cli.Command{
 	Usage:       "Run item service",
 	Description: "Execute this command to start item service",
 	Action:      startPurchaseItemService,
+	Flags:       config.Flags(config.SectionItem),
 }
 
+// items holds purchases made through item service
+var items *inventory
+
 func startPurchaseItemService(c *cli.Context) {
-	r := mux.NewRouter()
-	r.HandleFunc("/item-success", purchaseItemSuceess).Methods(http.MethodPost)
-	r.HandleFunc("/item-failed", purchaseItemFailed).Methods(http.MethodPost)
-	r.HandleFunc("/item-compensated", purchaseItemCompensated).Methods(http.MethodPost)
+	cfg, err := config.FromContext(c, config.SectionItem)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	store, err := idempotency.Open(cfg.Item.IdempotencyDir)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
 
-	srv := &http.Server{
-		Addr:         ":8001",
-		WriteTimeout: time.Second * 3,
-		ReadTimeout:  time.Second * 3,
-		IdleTimeout:  time.Second * 10,
-		Handler:      r,
+	state, err := outbox.OpenDir(cfg.Item.StoreDir)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	items = newInventory(state)
+
+	monkey, err := chaos.New(cfg.Item.Chaos.Config())
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if monkey.Config().Enabled() {
+		log.Printf("chaos mode is enabled : %+v\n", monkey.Config())
 	}
 
+	r := mux.NewRouter()
+	r.Use(monkey.Middleware)
+	r.Use(fault.Middleware)
+	r.Use(store.Middleware)
+	r.Handle(chaos.AdminPath, monkey).Methods(http.MethodGet, http.MethodPut)
+	r.HandleFunc("/item-purchased", purchaseItem).Methods(http.MethodPost)
+	r.HandleFunc("/item-confirmed", purchaseItemConfirmed).Methods(http.MethodPost)
+	r.HandleFunc("/item-compensated", purchaseItemCompensated).Methods(http.MethodPost)
+	r.HandleFunc("/items/{item}", getItem).Methods(http.MethodGet)
+
+	srv := cfg.Item.Server.HTTPServer(r)
 	go func() {
-		log.Println("item service is running on port 8001")
+		log.Printf("item service is running on %s\n", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil {
 			log.Println(err)
 		}
 	}()
 
+	var grpcSrv *grpc.Server
+	if addr := cfg.Item.GRPCAddr; addr != "" {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		grpcSrv = grpctransport.NewServer(monkey.UnaryInterceptor, fault.UnaryInterceptor, store.UnaryInterceptor)
+		itempb.RegisterItemServiceServer(grpcSrv, grpcServer{})
+		go func() {
+			log.Printf("item gRPC service is running on %s\n", addr)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	msgBroker, err := broker.FromConfig(cfg.Item.Broker)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if msgBroker != nil {
+		broker.Serve(msgBroker, Channel, r)
+		log.Printf("item service is receiving commands from %s message broker channel %s\n", cfg.Item.Broker.Engine, Channel)
+	}
+
 	chanSignal := make(chan os.Signal, 1)
 	signal.Notify(chanSignal, os.Interrupt)
 	<-chanSignal
@@ -69,18 +138,40 @@ func startPurchaseItemService(c *cli.Context) {
 	defer cancel()
 
 	srv.Shutdown(ctx)
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+	if msgBroker != nil {
+		msgBroker.Close()
+	}
+	store.Close()
+	state.Close()
 	log.Println("shutting down")
 	os.Exit(0)
 }
 
-func purchaseItemSuceess(w http.ResponseWriter, r *http.Request) {
+func purchaseItem(w http.ResponseWriter, r *http.Request) {
 	var payload Request
 	json.NewDecoder(r.Body).Decode(&payload)
 
-	log.Printf("[purchase item ID 66] purchase item %s : success\n", payload.Item)
+	purchased, err := items.reserve(r.Header.Get(idempotency.HeaderKey), payload.Item, payload.SagaID)
+	if err != nil {
+		log.Printf("purchase item %s : %s (saga %s)\n", payload.Item, err.Error(), purchased.SagaID)
+
+		status := http.StatusConflict
+		if err != ErrItemReserved {
+			status = http.StatusInternalServerError
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{Success: false})
+		return
+	}
+
+	log.Printf("[purchase item ID %d] purchase item %s : reserved\n", purchased.PurchaseItemID, payload.Item)
 
 	resp := Response{
-		PuchaseItemID: 66,
+		PuchaseItemID: purchased.PurchaseItemID,
 		Success:       true,
 	}
 
@@ -89,25 +180,59 @@ func purchaseItemSuceess(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func purchaseItemFailed(w http.ResponseWriter, r *http.Request) {
-	var payload Request
+// purchaseItemConfirmed will confirm reserved item once the saga which
+// reserved it has succeeded, releasing its lock
+func purchaseItemConfirmed(w http.ResponseWriter, r *http.Request) {
+	var payload ConfirmationRequest
 	json.NewDecoder(r.Body).Decode(&payload)
 
-	log.Printf("purchase item %s : FAILED!!!\n", payload.Item)
+	if _, err := items.confirm(r.Header.Get(idempotency.HeaderKey), payload.PurchaseItemID); err != nil {
+		log.Printf("confirm purchase_item_id %d : %s\n", payload.PurchaseItemID, err.Error())
+
+		status := http.StatusInternalServerError
+		switch err {
+		case ErrPurchaseNotFound:
+			status = http.StatusNotFound
+		case ErrPurchaseFinished:
+			status = http.StatusConflict
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{Success: false})
+		return
+	}
+
+	log.Printf("[purchase item ID %d] confirm purchased item : success\n", payload.PurchaseItemID)
 
 	resp := Response{
-		Success: false,
+		PuchaseItemID: payload.PurchaseItemID,
+		Success:       true,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(resp)
 }
 
+// purchaseItemCompensated will roll back reserved item, releasing its lock.
+// Unknown purchase has nothing to roll back.
 func purchaseItemCompensated(w http.ResponseWriter, r *http.Request) {
 	var payload CompensationRequest
 	json.NewDecoder(r.Body).Decode(&payload)
 
+	if _, err := items.release(r.Header.Get(idempotency.HeaderKey), payload.PurchaseItemID); err != nil && err != ErrPurchaseNotFound {
+		log.Printf("[rollback] rollback purchase_item_id %d : %s\n", payload.PurchaseItemID, err.Error())
+
+		status := http.StatusConflict
+		if err != ErrPurchaseFinished {
+			status = http.StatusInternalServerError
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{Success: false})
+		return
+	}
+
 	log.Printf("[rollback] rollback purchase_item_id %d : success\n", payload.PurchaseItemID)
 
 	resp := Response{
@@ -118,3 +243,22 @@ func purchaseItemCompensated(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(resp)
 }
+
+// getItem will show latest purchase of an item, including whether it is
+// still reserved by a running saga
+func getItem(w http.ResponseWriter, r *http.Request) {
+	purchased, ok, err := items.get(mux.Vars(r)["item"])
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "item not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(purchased)
+}
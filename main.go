@@ -4,6 +4,8 @@ import (
 	"os"
 	"sort"
 
+	"github.com/cikupin/saga-simple-example/choreography"
+	"github.com/cikupin/saga-simple-example/config"
 	"github.com/cikupin/saga-simple-example/item"
 	"github.com/cikupin/saga-simple-example/orchestrator"
 	"github.com/cikupin/saga-simple-example/order"
@@ -23,6 +25,8 @@ func main() {
 		item.Serve,
 		order.Serve,
 		payment.Serve,
+		choreography.Serve,
+		config.Command,
 	}
 
 	sort.Sort(cli.FlagsByName(app.Flags))
@@ -0,0 +1,111 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/cikupin/saga-simple-example/saga"
+	"github.com/gorilla/mux"
+)
+
+// handlerDeadLetters defines dead-lettered sagas handler
+func handlerDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := sagaCoordinator.DeadLetters()
+	if err != nil {
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(letters)
+}
+
+// handlerRedrive defines dead-lettered saga re-drive handler. Re-drive is
+// executed by worker pool, so it goes on after admin client goes away.
+func handlerRedrive(w http.ResponseWriter, r *http.Request) {
+	intervene(w, r, func(sagaID string, op saga.Operator) error {
+		return sagaCoordinator.SubmitRedrive(sagaID, op)
+	}, generateAcceptedResponse)
+}
+
+// handlerRetryStep defines sub-transaction retry handler. Retry is
+// executed by worker pool, so it goes on after admin client goes away.
+func handlerRetryStep(w http.ResponseWriter, r *http.Request) {
+	intervene(w, r, func(sagaID string, op saga.Operator) error {
+		return sagaCoordinator.SubmitRetryStep(sagaID, mux.Vars(r)["step"], op)
+	}, generateAcceptedResponse)
+}
+
+// handlerRetryCompensation defines compensation retry handler
+func handlerRetryCompensation(w http.ResponseWriter, r *http.Request) {
+	intervene(w, r, func(sagaID string, op saga.Operator) error {
+		return sagaCoordinator.RetryCompensation(r.Context(), sagaID, mux.Vars(r)["step"], op)
+	}, generateStatusResponse)
+}
+
+// handlerMarkCompensated defines manual compensation handler
+func handlerMarkCompensated(w http.ResponseWriter, r *http.Request) {
+	intervene(w, r, func(sagaID string, op saga.Operator) error {
+		return sagaCoordinator.MarkCompensated(sagaID, mux.Vars(r)["step"], op)
+	}, generateStatusResponse)
+}
+
+// handlerForceComplete defines saga force complete handler
+func handlerForceComplete(w http.ResponseWriter, r *http.Request) {
+	intervene(w, r, func(sagaID string, op saga.Operator) error {
+		return sagaCoordinator.ForceComplete(sagaID, op)
+	}, generateStatusResponse)
+}
+
+// intervene will decode operator from request body, run admin action
+// and respond afterwards
+func intervene(w http.ResponseWriter, r *http.Request, action func(sagaID string, op saga.Operator) error, respond func(w http.ResponseWriter, sagaID string)) {
+	var op saga.Operator
+	if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
+		generateErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sagaID := mux.Vars(r)["id"]
+	if err := action(sagaID, op); err != nil {
+		generateAdminErrorResponse(w, err)
+		return
+	}
+
+	respond(w, sagaID)
+}
+
+// generateStatusResponse will respond with current saga status
+func generateStatusResponse(w http.ResponseWriter, sagaID string) {
+	status, err := sagaCoordinator.Status(sagaID)
+	if err != nil {
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+// generateAdminErrorResponse will map saga coordinator error into response
+func generateAdminErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case err == saga.ErrOperatorRequired:
+		generateErrorResponse(w, http.StatusBadRequest, err)
+	case err == saga.ErrSagaNotFound:
+		generateErrorResponse(w, http.StatusNotFound, err)
+	case err == saga.ErrNotDeadLettered, err == saga.ErrSagaRunning, errors.Is(err, saga.ErrActionNotAllowed):
+		generateErrorResponse(w, http.StatusConflict, err)
+	case err == saga.ErrQueueFull:
+		generateErrorResponse(w, http.StatusServiceUnavailable, err)
+	default:
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+	}
+}
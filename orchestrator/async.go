@@ -0,0 +1,59 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/cikupin/saga-simple-example/saga"
+)
+
+// preferAsync is the Prefer header value asking saga to run asynchronously
+const preferAsync = "respond-async"
+
+// isAsync returns true if client asks saga to run asynchronously
+func isAsync(r *http.Request) bool {
+	for _, prefer := range r.Header["Prefer"] {
+		for _, value := range strings.Split(prefer, ",") {
+			if strings.TrimSpace(value) == preferAsync {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// submitSaga will queue saga to worker pool and respond with 202 Accepted.
+// started, if set, is called once saga start is recorded.
+func submitSaga(w http.ResponseWriter, sagaType string, input buyItemRequest, started func(exec *saga.Execution)) {
+	sagaInstance, err := sagaCoordinator.Submit(sagaType, input)
+	if err == saga.ErrQueueFull {
+		generateErrorResponse(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if started != nil {
+		started(sagaInstance)
+	}
+	generateAcceptedResponse(w, sagaInstance.ID)
+}
+
+// generateAcceptedResponse will respond with 202 Accepted and saga status URL
+func generateAcceptedResponse(w http.ResponseWriter, sagaID string) {
+	response := asyncResponse{
+		SagaID:    sagaID,
+		StatusURL: fmt.Sprintf("/sagas/%s", sagaID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", response.StatusURL)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
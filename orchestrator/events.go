@@ -0,0 +1,205 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/saga"
+	"github.com/gorilla/mux"
+)
+
+const (
+	eventBufferSize   = 16
+	keepAliveInterval = 15 * time.Second
+)
+
+var errStreamingUnsupported = errors.New("response streaming is not supported")
+
+// connKey is the context key of connection serving request
+type connKey struct{}
+
+// eventNames maps saga log record type into server-sent event name
+var eventNames = map[saga.LogType]string{
+	saga.SagaStart:        "saga.started",
+	saga.SagaAbort:        "saga.aborting",
+	saga.SagaDeadLetter:   "saga.dead_lettered",
+	saga.SagaIntervention: "saga.intervention",
+	saga.SagaParentAbort:  "saga.parent_aborting",
+	saga.SagaEnd:          "saga.finished",
+	saga.ActionStart:      "step.started",
+	saga.ActionEnd:        "step.succeeded",
+	saga.SubSagaStart:     "step.sub_saga_started",
+	saga.ActionSkip:       "step.skipped",
+	saga.ActionRetry:      "step.retrying",
+	saga.ActionFailed:     "step.failed",
+	saga.CompensateStart:  "compensation.started",
+	saga.CompensateEnd:    "compensation.finished",
+	saga.CompensateRetry:  "compensation.retrying",
+	saga.CompensateFailed: "compensation.failed",
+}
+
+// eventBroker fans out saga log records to event stream subscribers of each saga
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan saga.Log]struct{}
+}
+
+var events = &eventBroker{
+	subscribers: make(map[string]map[chan saga.Log]struct{}),
+}
+
+// listen will publish saga log record to subscribers of its saga.
+// Subscriber which can not keep up is dropped, and its client resumes
+// the stream with Last-Event-ID header.
+func (b *eventBroker) listen(l saga.Log) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[l.SagaID] {
+		select {
+		case ch <- l:
+		default:
+			b.remove(l.SagaID, ch)
+		}
+	}
+}
+
+func (b *eventBroker) subscribe(sagaID string) chan saga.Log {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan saga.Log, eventBufferSize)
+	if b.subscribers[sagaID] == nil {
+		b.subscribers[sagaID] = make(map[chan saga.Log]struct{})
+	}
+	b.subscribers[sagaID][ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(sagaID string, ch chan saga.Log) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(sagaID, ch)
+}
+
+func (b *eventBroker) remove(sagaID string, ch chan saga.Log) {
+	if _, ok := b.subscribers[sagaID][ch]; !ok {
+		return
+	}
+
+	close(ch)
+	delete(b.subscribers[sagaID], ch)
+	if len(b.subscribers[sagaID]) == 0 {
+		delete(b.subscribers, sagaID)
+	}
+}
+
+// withConn will keep connection in context of its requests, so event
+// stream can lift write timeout of its own connection
+func withConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+// handlerSagaEvents streams saga progress as server-sent events.
+// Past records are replayed first, then new records are streamed live
+// until saga is finished.
+func handlerSagaEvents(w http.ResponseWriter, r *http.Request) {
+	sagaID := mux.Vars(r)["id"]
+
+	ch := events.subscribe(sagaID)
+	defer events.unsubscribe(sagaID, ch)
+
+	logs, err := sagaCoordinator.Logs(sagaID)
+	if err == saga.ErrSagaNotFound {
+		generateErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		generateErrorResponse(w, http.StatusInternalServerError, errStreamingUnsupported)
+		return
+	}
+
+	// event stream outlives server write timeout
+	if conn, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+		conn.SetWriteDeadline(time.Time{})
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	lastSeq, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	for _, l := range logs {
+		if l.Seq <= lastSeq {
+			continue
+		}
+		if err = writeEvent(w, l); err != nil {
+			return
+		}
+		lastSeq = l.Seq
+	}
+	flusher.Flush()
+
+	// stream of finished saga is closed, even when client has already
+	// received its last record
+	if logs[len(logs)-1].Type == saga.SagaEnd {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case l, ok := <-ch:
+			if !ok {
+				return
+			}
+			if l.Seq <= lastSeq {
+				continue
+			}
+			if err = writeEvent(w, l); err != nil {
+				return
+			}
+			flusher.Flush()
+			lastSeq = l.Seq
+			if l.Type == saga.SagaEnd {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err = fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, l saga.Log) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", l.Seq, eventNames[l.Type], data)
+	return err
+}
@@ -0,0 +1,240 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/saga"
+	"github.com/cikupin/saga-simple-example/storage"
+	"github.com/gorilla/mux"
+)
+
+// sseEvent defines one received server-sent event
+type sseEvent struct {
+	id   string
+	name string
+}
+
+// startEventsTest will serve saga events of a coordinator running a saga
+// with two sub-transactions, where the second one waits for release.
+// Zero writeTimeout means no write timeout.
+func startEventsTest(t *testing.T, writeTimeout time.Duration) (*httptest.Server, chan struct{}) {
+	release := make(chan struct{})
+	ok := func(ctx context.Context, exec *saga.Execution) (interface{}, error) {
+		return nil, nil
+	}
+	wait := func(ctx context.Context, exec *saga.Execution) (interface{}, error) {
+		<-release
+		return nil, nil
+	}
+
+	registry := saga.NewRegistry()
+	if err := registry.Register(saga.NewDefinition("test").AddSubTxDef("first", ok, nil).AddSubTxDef("second", wait, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	prevCoordinator, prevEvents := sagaCoordinator, events
+	sagaCoordinator = saga.NewCoordinator(registry, storage.NewMemoryStorage())
+	events = &eventBroker{subscribers: make(map[string]map[chan saga.Log]struct{})}
+	sagaCoordinator.Subscribe(events.listen)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/sagas/{id}/events", handlerSagaEvents).Methods(http.MethodGet)
+	srv := httptest.NewUnstartedServer(r)
+	srv.Config.ConnContext = withConn
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Start()
+
+	t.Cleanup(func() {
+		srv.Close()
+		sagaCoordinator, events = prevCoordinator, prevEvents
+	})
+	return srv, release
+}
+
+// startTestSaga will start test saga and return its ID once it is recorded
+func startTestSaga(t *testing.T) (string, chan struct{}) {
+	started := make(chan string, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := sagaCoordinator.ExecuteFunc(context.Background(), "test", nil, func(exec *saga.Execution) {
+			started <- exec.ID
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	return <-started, done
+}
+
+// readEvents will read server-sent events until stream is closed, or
+// until given number of events is read when n is positive
+func readEvents(t *testing.T, scanner *bufio.Scanner, n int) []sseEvent {
+	var received []sseEvent
+	var e sseEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case line == "" && e.id != "":
+			received = append(received, e)
+			e = sseEvent{}
+			if len(received) == n {
+				return received
+			}
+		}
+	}
+	return received
+}
+
+func getEvents(t *testing.T, url, lastEventID string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestSagaEventsReplay(t *testing.T) {
+	all := []sseEvent{
+		{"1", "saga.started"},
+		{"2", "step.started"},
+		{"3", "step.succeeded"},
+		{"4", "step.started"},
+		{"5", "step.succeeded"},
+		{"6", "saga.finished"},
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []sseEvent
+	}{
+		{
+			name: "every record of finished saga",
+			want: all,
+		},
+		{
+			name:        "records after Last-Event-ID",
+			lastEventID: "3",
+			want:        all[3:],
+		},
+		{
+			name:        "nothing after the last record",
+			lastEventID: "6",
+		},
+	}
+
+	srv, release := startEventsTest(t, 0)
+	close(release)
+	sagaID, done := startTestSaga(t)
+	<-done
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := getEvents(t, srv.URL+"/sagas/"+sagaID+"/events", tt.lastEventID)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q, want text/event-stream", ct)
+			}
+
+			// stream of finished saga is closed after its last record
+			received := readEvents(t, bufio.NewScanner(resp.Body), 0)
+			if !reflect.DeepEqual(received, tt.want) {
+				t.Errorf("events = %v, want %v", received, tt.want)
+			}
+		})
+	}
+}
+
+func TestSagaEventsLive(t *testing.T) {
+	srv, release := startEventsTest(t, 0)
+	sagaID, done := startTestSaga(t)
+
+	resp := getEvents(t, srv.URL+"/sagas/"+sagaID+"/events", "")
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+
+	// records written before subscribing are replayed, then the stream
+	// waits for the running sub-transaction
+	replayed := readEvents(t, scanner, 4)
+	if len(replayed) != 4 || replayed[3] != (sseEvent{"4", "step.started"}) {
+		t.Fatalf("replayed events = %v, want 4 events ending with second step start", replayed)
+	}
+
+	close(release)
+	<-done
+
+	live := readEvents(t, scanner, 0)
+	want := []sseEvent{{"5", "step.succeeded"}, {"6", "saga.finished"}}
+	if !reflect.DeepEqual(live, want) {
+		t.Errorf("live events = %v, want %v", live, want)
+	}
+}
+
+// plainWriter hides http.Flusher of underlying response writer
+type plainWriter struct {
+	http.ResponseWriter
+}
+
+func TestSagaEventsErrors(t *testing.T) {
+	srv, release := startEventsTest(t, 0)
+	close(release)
+	sagaID, done := startTestSaga(t)
+	<-done
+
+	resp := getEvents(t, srv.URL+"/sagas/unknown/events", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status of unknown saga = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	rec := httptest.NewRecorder()
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/sagas/"+sagaID+"/events", nil), map[string]string{"id": sagaID})
+	handlerSagaEvents(plainWriter{rec}, req)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), errStreamingUnsupported.Error()) {
+		t.Errorf("response without streaming = %d %s, want %d", rec.Code, rec.Body.String(), http.StatusInternalServerError)
+	}
+}
+
+// TestSagaEventsWriteTimeout checks that event stream outlives server
+// write timeout
+func TestSagaEventsWriteTimeout(t *testing.T) {
+	srv, release := startEventsTest(t, 50*time.Millisecond)
+	sagaID, done := startTestSaga(t)
+
+	resp := getEvents(t, srv.URL+"/sagas/"+sagaID+"/events", "")
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	readEvents(t, scanner, 4)
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	<-done
+
+	if live := readEvents(t, scanner, 0); len(live) != 2 {
+		t.Errorf("events after write timeout = %v, want 2", live)
+	}
+}
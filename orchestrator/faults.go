@@ -0,0 +1,50 @@
+package orchestrator
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cikupin/saga-simple-example/fault"
+)
+
+// injectFaults will set fault plan of saga input. Plan in X-Fault-Plan
+// header is added to plan in request body, and request without any plan
+// gets plan from --fault-config. Plan is kept in saga input, so retried
+// and recovered steps see the same faults, until operator acts on saga.
+func injectFaults(r *http.Request, input *buyItemRequest) error {
+	if value := r.Header.Get(fault.HeaderPlan); value != "" {
+		plan, err := fault.ParsePlan(value)
+		if err != nil {
+			return err
+		}
+
+		if input.Faults == nil {
+			input.Faults = make(fault.Plan)
+		}
+		for subTxID, step := range plan {
+			input.Faults[subTxID] = step
+		}
+	}
+
+	if len(input.Faults) == 0 {
+		input.Faults = defaultFaults
+	}
+	return validateFaults(input.Faults)
+}
+
+// validateFaults returns error if plan injects faults into unknown
+// sub-transaction, or into compensation of sub-transaction which has none
+func validateFaults(plan fault.Plan) error {
+	err := plan.Validate(labelPurchaseItem, labelOrder, labelPayment, labelConfirmItem, labelApproveOrder)
+	if err != nil {
+		return err
+	}
+
+	for subTxID, step := range plan {
+		compensation := step.FailCompensation || step.CompensationLatency > 0
+		if compensation && subTxID != labelPurchaseItem && subTxID != labelOrder {
+			return fmt.Errorf("step %s has no compensation to inject fault into", subTxID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package orchestrator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/fault"
+)
+
+func TestInjectFaults(t *testing.T) {
+	defaults := fault.Plan{labelPayment: {Fail: true}}
+
+	tests := []struct {
+		name    string
+		header  string
+		body    fault.Plan
+		want    fault.Plan
+		wantErr bool
+	}{
+		{
+			name: "default plan without any plan",
+			want: defaults,
+		},
+		{
+			name: "plan in body",
+			body: fault.Plan{labelOrder: {FailCompensation: true}},
+			want: fault.Plan{labelOrder: {FailCompensation: true}},
+		},
+		{
+			name:   "plan in header",
+			header: "latency=purchase-item:200ms",
+			want:   fault.Plan{labelPurchaseItem: {Latency: fault.Duration(200 * time.Millisecond)}},
+		},
+		{
+			name:   "header is added to body",
+			header: "fail=payment, fail-compensation=order",
+			body:   fault.Plan{labelOrder: {Latency: fault.Duration(time.Second)}, labelConfirmItem: {Fail: true}},
+			want: fault.Plan{
+				labelOrder:       {FailCompensation: true},
+				labelPayment:     {Fail: true},
+				labelConfirmItem: {Fail: true},
+			},
+		},
+		{
+			name:    "invalid header",
+			header:  "fail",
+			wantErr: true,
+		},
+		{
+			name:    "unknown step",
+			header:  "fail=shipping",
+			wantErr: true,
+		},
+		{
+			name:    "step without compensation",
+			body:    fault.Plan{labelPayment: {FailCompensation: true}},
+			wantErr: true,
+		},
+		{
+			name:    "latency of missing compensation",
+			header:  "compensation-latency=approve-order:1s",
+			wantErr: true,
+		},
+	}
+
+	prev := defaultFaults
+	defaultFaults = defaults
+	defer func() { defaultFaults = prev }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/buy", nil)
+			if tt.header != "" {
+				r.Header.Set(fault.HeaderPlan, tt.header)
+			}
+			input := buyItemRequest{Item: "book", Faults: tt.body}
+
+			err := injectFaults(r, &input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("injectFaults() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(input.Faults, tt.want) {
+				t.Errorf("faults = %+v, want %+v", input.Faults, tt.want)
+			}
+		})
+	}
+}
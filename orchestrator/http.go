@@ -0,0 +1,180 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cikupin/saga-simple-example/broker"
+	"github.com/cikupin/saga-simple-example/fault"
+	"github.com/cikupin/saga-simple-example/grpctransport"
+	"github.com/cikupin/saga-simple-example/httpclient"
+	"github.com/cikupin/saga-simple-example/idempotency"
+	"github.com/cikupin/saga-simple-example/item"
+	"github.com/cikupin/saga-simple-example/order"
+	"github.com/cikupin/saga-simple-example/payment"
+	"github.com/cikupin/saga-simple-example/saga"
+	"google.golang.org/grpc"
+)
+
+const (
+	// grpcScheme is the URL scheme of participant called through gRPC
+	grpcScheme = "grpc://"
+	// brokerScheme is the URL scheme of participant receiving commands
+	// from message broker, followed by its channel
+	brokerScheme = "msg://"
+)
+
+var (
+	itemClient    *item.Client
+	orderClient   *order.Client
+	paymentClient *payment.Client
+)
+
+// newClients will create participant service clients. Participant whose
+// URL has grpc:// scheme is called through its gRPC server, participant
+// whose URL has msg:// scheme is sent commands through b, and others share
+// one pooled http.Client. Returned connections must be closed on shutdown.
+func newClients(itemURL, orderURL, paymentURL string, cfg httpclient.Config, trace bool, b broker.Broker) ([]*grpc.ClientConn, error) {
+	middlewares := []httpclient.Middleware{
+		httpclient.Header("User-Agent", "saga-orchestrator"),
+	}
+	if trace {
+		middlewares = append(middlewares, httpclient.Trace())
+	}
+
+	httpClient := httpclient.NewHTTPClient(cfg, middlewares...)
+	itemClient = item.NewClient(itemURL, httpClient)
+	orderClient = order.NewClient(orderURL, httpClient)
+	paymentClient = payment.NewClient(paymentURL, httpClient)
+
+	var conns []*grpc.ClientConn
+	dial := func(rawURL string) (*grpc.ClientConn, error) {
+		conn, err := grpctransport.Dial(strings.TrimPrefix(rawURL, grpcScheme))
+		if err != nil {
+			return nil, fmt.Errorf("dial %s : %s", rawURL, err.Error())
+		}
+		conns = append(conns, conn)
+		return conn, nil
+	}
+	channel := func(rawURL string) (string, error) {
+		if b == nil {
+			return "", fmt.Errorf("participant URL %s needs --broker", rawURL)
+		}
+		return strings.TrimPrefix(rawURL, brokerScheme), nil
+	}
+
+	switch {
+	case strings.HasPrefix(itemURL, grpcScheme):
+		conn, err := dial(itemURL)
+		if err != nil {
+			return conns, err
+		}
+		itemClient = item.NewGRPCClient(conn)
+	case strings.HasPrefix(itemURL, brokerScheme):
+		ch, err := channel(itemURL)
+		if err != nil {
+			return conns, err
+		}
+		itemClient = item.NewBrokerClient(b, ch)
+	}
+
+	switch {
+	case strings.HasPrefix(orderURL, grpcScheme):
+		conn, err := dial(orderURL)
+		if err != nil {
+			return conns, err
+		}
+		orderClient = order.NewGRPCClient(conn)
+	case strings.HasPrefix(orderURL, brokerScheme):
+		ch, err := channel(orderURL)
+		if err != nil {
+			return conns, err
+		}
+		orderClient = order.NewBrokerClient(b, ch)
+	}
+
+	switch {
+	case strings.HasPrefix(paymentURL, grpcScheme):
+		conn, err := dial(paymentURL)
+		if err != nil {
+			return conns, err
+		}
+		paymentClient = payment.NewGRPCClient(conn)
+	case strings.HasPrefix(paymentURL, brokerScheme):
+		ch, err := channel(paymentURL)
+		if err != nil {
+			return conns, err
+		}
+		paymentClient = payment.NewBrokerClient(b, ch)
+	}
+	return conns, nil
+}
+
+// callContext returns ctx of participant call. The call is cancelled once
+// sub-transaction deadline is exceeded. Participant service uses key to
+// recognize a retried call, and honors injected fault f. Command sent
+// through message broker is correlated with saga and step of key.
+func callContext(ctx context.Context, exec *saga.Execution, key string, f fault.Fault) context.Context {
+	ctx = broker.WithCorrelation(ctx, exec.ID, strings.TrimPrefix(key, exec.ID+"/"))
+	ctx = httpclient.WithHeader(ctx, idempotency.HeaderKey, key)
+	if !f.IsZero() {
+		ctx = httpclient.WithHeader(ctx, fault.HeaderInjection, f.String())
+	}
+	return ctx
+}
+
+// classify will attach error class to failed participant call, so retry
+// policy can tell conflict, rejected and failed calls apart. Network error
+// is classified by saga as is.
+func classify(err error) error {
+	var se *httpclient.StatusError
+	if !errors.As(err, &se) {
+		return err
+	}
+
+	switch {
+	case se.StatusCode == http.StatusConflict:
+		return saga.ClassError(saga.ErrorConflict, err)
+	case se.IsServerError():
+		return saga.ClassError(saga.ErrorServer, err)
+	default:
+		return saga.ClassError(saga.ErrorClient, err)
+	}
+}
+
+// stepKey returns idempotency key of sub-transaction call, derived from
+// saga ID and sub-transaction, so every attempt of a step shares it
+func stepKey(exec *saga.Execution, subTxID string) string {
+	return exec.ID + "/" + subTxID
+}
+
+// compensationKey returns idempotency key of compensation call
+func compensationKey(exec *saga.Execution, subTxID string) string {
+	return stepKey(exec, subTxID) + "/compensation"
+}
+
+// actionFault returns fault which saga fault plan injects into action of
+// given sub-transaction
+func actionFault(exec *saga.Execution, subTxID string) fault.Fault {
+	return faults(exec).Action(subTxID)
+}
+
+// compensationFault returns fault which saga fault plan injects into
+// compensation of given sub-transaction
+func compensationFault(exec *saga.Execution, subTxID string) fault.Fault {
+	return faults(exec).Compensation(subTxID)
+}
+
+// faults returns fault plan of saga. Saga which operator has acted on has
+// no faults, so redriven or retried saga is not failed again by the plan
+// which made it fail.
+func faults(exec *saga.Execution) fault.Plan {
+	var input buyItemRequest
+	if exec.Intervened() || exec.Input(&input) != nil {
+		return nil
+	}
+	return input.Faults
+}
@@ -0,0 +1,148 @@
+package orchestrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/idempotency"
+	"github.com/cikupin/saga-simple-example/saga"
+	"github.com/cikupin/saga-simple-example/storage"
+)
+
+const (
+	// idempotencyLogPrefix is prepended to hashed idempotency key to build its logID
+	idempotencyLogPrefix = "idempotency_"
+
+	maxIdempotencyKeyLength = 255
+)
+
+var (
+	errIdempotencyKeyTooLong = fmt.Errorf("idempotency key must not exceed %d characters", maxIdempotencyKeyLength)
+	errIdempotencyKeyReused  = errors.New("idempotency key has already been used with a different request")
+)
+
+type (
+	idempotencyRecord struct {
+		Key         string    `json:"key"`
+		RequestHash string    `json:"request_hash"`
+		SagaID      string    `json:"saga_id"`
+		Time        time.Time `json:"time"`
+	}
+
+	// idempotencyStore remembers saga started with every idempotency key
+	// in saga log storage. Lookup and saga start of the same key are
+	// serialized, so concurrent retries never start two sagas.
+	idempotencyStore struct {
+		storage storage.Storage
+		keys    *idempotency.KeyLock
+	}
+)
+
+var idempotencyKeys *idempotencyStore
+
+func newIdempotencyStore(logStorage storage.Storage) *idempotencyStore {
+	return &idempotencyStore{
+		storage: logStorage,
+		keys:    idempotency.NewKeyLock(),
+	}
+}
+
+// begin will lock given key and return saga previously started with it.
+// If there is none, key stays locked until commit or abandon is called.
+func (s *idempotencyStore) begin(key, requestHash string) (*idempotencyRecord, error) {
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, errIdempotencyKeyTooLong
+	}
+
+	s.keys.Lock(key)
+	record, err := s.lookup(key)
+	if err == nil && record != nil && record.RequestHash != requestHash {
+		err = errIdempotencyKeyReused
+	}
+	if err != nil || record != nil {
+		s.keys.Unlock(key)
+	}
+	return record, err
+}
+
+// commit will remember saga started with given key and unlock the key
+func (s *idempotencyStore) commit(key, requestHash, sagaID string) {
+	defer s.keys.Unlock(key)
+
+	data, _ := json.Marshal(idempotencyRecord{
+		Key:         key,
+		RequestHash: requestHash,
+		SagaID:      sagaID,
+		Time:        time.Now(),
+	})
+	if err := s.storage.AppendLog(idempotencyLogID(key), string(data)); err != nil {
+		log.Printf("saga %s : failed to record idempotency key : %s\n", sagaID, err.Error())
+	}
+}
+
+// abandon will unlock given key without starting any saga
+func (s *idempotencyStore) abandon(key string) {
+	s.keys.Unlock(key)
+}
+
+func (s *idempotencyStore) lookup(key string) (*idempotencyRecord, error) {
+	data, err := s.storage.Lookup(idempotencyLogID(key))
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	var record idempotencyRecord
+	if err = json.Unmarshal([]byte(data[len(data)-1]), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// idempotencyLogID hashes idempotency key, since key may contain
+// characters which are not allowed in logID
+func idempotencyLogID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return idempotencyLogPrefix + hex.EncodeToString(sum[:])
+}
+
+// requestHash returns hash of saga type and decoded request, so the same
+// request is recognized regardless of JSON formatting
+func requestHash(sagaType string, input buyItemRequest) string {
+	payloadBytes, _ := json.Marshal(input)
+
+	sum := sha256.Sum256(append([]byte(sagaType+"\n"), payloadBytes...))
+	return hex.EncodeToString(sum[:])
+}
+
+// replaySaga will respond with saga previously started with the same
+// idempotency key. Finished saga responds like a synchronous request,
+// and running saga responds with 202 Accepted.
+func replaySaga(w http.ResponseWriter, record *idempotencyRecord) {
+	status, err := sagaCoordinator.Status(record.SagaID)
+	if err != nil {
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(idempotency.HeaderReplayed, "true")
+	if status.EndedAt == nil {
+		generateAcceptedResponse(w, status.ID)
+		return
+	}
+
+	response := buyItemResponse{
+		SagaID:  status.ID,
+		Success: status.State == saga.StateCompleted || status.State == saga.StateForceCompleted,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
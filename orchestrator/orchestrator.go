@@ -10,21 +10,39 @@ import (
 	"sync"
 	"time"
 
-	saga "github.com/cikupin/go-saga"
-	_ "github.com/cikupin/go-saga/storage/kafka" // use kafka as saga log storage engine
+	_ "github.com/cikupin/go-saga/storage/kafka" // register kafka as default saga log storage engine
+	"github.com/cikupin/saga-simple-example/broker"
+	"github.com/cikupin/saga-simple-example/config"
+	"github.com/cikupin/saga-simple-example/fault"
+	"github.com/cikupin/saga-simple-example/httpclient"
+	"github.com/cikupin/saga-simple-example/idempotency"
+	"github.com/cikupin/saga-simple-example/saga"
 	"github.com/gorilla/mux"
 	"github.com/urfave/cli"
 )
 
 type (
 	buyItemRequest struct {
-		Item          string `json:"item"`
-		Price         int    `json:"price"`
-		PaymentMethod string `json:"payment_method"`
+		Item          string     `json:"item"`
+		Price         int        `json:"price"`
+		PaymentMethod string     `json:"payment_method"`
+		Digital       bool       `json:"digital,omitempty"`
+		CallbackURL   string     `json:"callback_url,omitempty"`
+		Faults        fault.Plan `json:"faults,omitempty"`
 	}
 
 	buyItemResponse struct {
-		Success bool `json:"success"`
+		SagaID  string `json:"saga_id"`
+		Success bool   `json:"success"`
+	}
+
+	asyncResponse struct {
+		SagaID    string `json:"saga_id"`
+		StatusURL string `json:"status_url"`
+	}
+
+	errorResponse struct {
+		Error string `json:"error"`
 	}
 )
 
@@ -35,50 +53,112 @@ var (
 		Usage:       "Run saga orchestrator",
 		Description: "Execute this command to start saga orchestrator",
 		Action:      startOrchestrator,
+		Flags:       config.Flags(config.SectionOrchestrator),
 	}
 
-	sagaOnce sync.Once
+	sagaCoordinator *saga.Coordinator
+
+	// defaultFaults is fault plan of saga whose request has none
+	defaultFaults fault.Plan
 )
 
 const (
-	sagaTopicID       = 12
 	labelPurchaseItem = "purchase-item"
 	labelOrder        = "order"
 	labelPayment      = "payment"
+	labelReservation  = "reservation"
+	labelConfirmItem  = "confirm-item"
+	labelApproveOrder = "approve-order"
 )
 
-type orderProperty struct {
-	PurchaseItemID int
-	OrderID        int
-}
+func startOrchestrator(c *cli.Context) {
+	cfg, err := config.FromContext(c, config.SectionOrchestrator)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
 
-func init() {
-	sagaOnce.Do(func() {
-		saga.StorageConfig.Kafka.ZkAddrs = []string{"0.0.0.0:2181"}
-		saga.StorageConfig.Kafka.BrokerAddrs = []string{"0.0.0.0:9092"}
-		saga.StorageConfig.Kafka.Partitions = 1
-		saga.StorageConfig.Kafka.Replicas = 1
-		saga.StorageConfig.Kafka.ReturnDuration = 50 * time.Millisecond
-	})
-}
+	o := cfg.Orchestrator
+	setKafkaConfig(o.Kafka)
+	logStorage, err := newStorage(o.Storage.Engine, o.Storage.Dir)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	log.Printf("using %s saga log storage\n", o.Storage.Engine)
 
-func startOrchestrator(c *cli.Context) {
-	r := mux.NewRouter()
-	r.HandleFunc("/normal-flow", handlerNormalFlow).Methods(http.MethodPost)
-	r.HandleFunc("/purchase-failed", handlerPurchaseItemFailed).Methods(http.MethodPost)
-	r.HandleFunc("/order-failed", handlerOrderFailed).Methods(http.MethodPost)
-	r.HandleFunc("/payment-failed", handlerPaymentFailed).Methods(http.MethodPost)
+	if path := o.FaultConfig; path != "" {
+		if defaultFaults, err = fault.LoadPlan(path); err != nil {
+			log.Fatalln(err.Error())
+		}
+		if err = validateFaults(defaultFaults); err != nil {
+			log.Fatalln(err.Error())
+		}
+		log.Printf("injecting faults from %s\n", path)
+	}
+
+	clientConfig := httpclient.DefaultConfig
+	clientConfig.Timeout = time.Duration(o.Client.Timeout)
+	clientConfig.MaxIdleConnsPerHost = o.Client.MaxIdleConns
+	msgBroker, err := broker.FromConfig(o.Broker)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	conns, err := newClients(o.ItemURL, o.OrderURL, o.PaymentURL, clientConfig, o.Client.Trace, msgBroker)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
 
-	srv := &http.Server{
-		Addr:         ":8000",
-		WriteTimeout: time.Second * 3,
-		ReadTimeout:  time.Second * 3,
-		IdleTimeout:  time.Second * 10,
-		Handler:      r,
+	retryOn := []saga.ErrorClass{saga.ErrorNetwork, saga.ErrorServer, saga.ErrorConflict}
+	if o.UsesBroker() {
+		// late command stays queued, and retry carrying the same idempotency
+		// key gets the outcome of whichever command is processed first
+		retryOn = append(retryOn, saga.ErrorTimeout)
+		log.Printf("sending participant commands through %s message broker\n", o.Broker.Engine)
 	}
 
+	webhooks = newWebhookSender(logStorage, o.Webhook.Secret, o.Webhook.MaxAttempts)
+	idempotencyKeys = newIdempotencyStore(logStorage)
+	registry := newRegistry(definitionConfig{
+		StepTimeout: time.Duration(o.StepTimeout),
+		Retry: saga.RetryPolicy{
+			MaxAttempts:    o.Retry.MaxAttempts,
+			InitialBackoff: time.Duration(o.Retry.Backoff),
+			MaxBackoff:     time.Duration(o.Retry.MaxBackoff),
+			RetryOn:        retryOn,
+		},
+		CompensationRetry: saga.RetryPolicy{
+			MaxAttempts:    o.CompensationRetry.MaxAttempts,
+			InitialBackoff: time.Duration(o.CompensationRetry.Backoff),
+			MaxBackoff:     time.Duration(o.CompensationRetry.MaxBackoff),
+			RetryOn:        saga.AllErrorClasses,
+		},
+	})
+	sagaCoordinator = saga.NewCoordinator(registry, logStorage)
+	sagaCoordinator.Subscribe(webhooks.listen)
+	sagaCoordinator.Subscribe(events.listen)
+	sagaCoordinator.StartWorkers(context.Background(), o.Workers, o.QueueSize)
+	go func() {
+		if err := sagaCoordinator.Recover(context.Background()); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/buy", handlerBuy).Methods(http.MethodPost)
+	r.HandleFunc("/nested-buy", handlerNestedBuy).Methods(http.MethodPost)
+	r.HandleFunc("/sagas/{id}", handlerSagaStatus).Methods(http.MethodGet)
+	r.HandleFunc("/sagas/{id}/webhooks", handlerWebhookDeliveries).Methods(http.MethodGet)
+	r.HandleFunc("/sagas/{id}/events", handlerSagaEvents).Methods(http.MethodGet)
+	r.HandleFunc("/admin/dead-letters", handlerDeadLetters).Methods(http.MethodGet)
+	r.HandleFunc("/admin/dead-letters/{id}/redrive", handlerRedrive).Methods(http.MethodPost)
+	r.HandleFunc("/admin/sagas/{id}/steps/{step}/retry", handlerRetryStep).Methods(http.MethodPost)
+	r.HandleFunc("/admin/sagas/{id}/steps/{step}/compensation/retry", handlerRetryCompensation).Methods(http.MethodPost)
+	r.HandleFunc("/admin/sagas/{id}/steps/{step}/mark-compensated", handlerMarkCompensated).Methods(http.MethodPost)
+	r.HandleFunc("/admin/sagas/{id}/force-complete", handlerForceComplete).Methods(http.MethodPost)
+
+	srv := o.Server.HTTPServer(r)
+	srv.ConnContext = withConn
 	go func() {
-		log.Println("order service is running on port 8000")
+		log.Printf("saga orchestrator is running on %s\n", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil {
 			log.Println(err)
 		}
@@ -93,6 +173,13 @@ func startOrchestrator(c *cli.Context) {
 	defer cancel()
 
 	srv.Shutdown(ctx)
+	for _, conn := range conns {
+		conn.Close()
+	}
+	if msgBroker != nil {
+		msgBroker.Close()
+	}
+	logStorage.Close()
 	log.Println("shutting down")
 	os.Exit(0)
 }
@@ -105,106 +192,87 @@ func getInput(r *http.Request) (buyItemRequest, error) {
 	return req, err
 }
 
-// handlerNormalFlow defines normal flow handler
-func handlerNormalFlow(w http.ResponseWriter, r *http.Request) {
-	input, err := getInput(r)
-	if err != nil {
-		log.Panicln(err.Error())
-		return
-	}
-
-	saga.AddSubTxDef(labelPurchaseItem, purchaseItemSuccess, compensatePurchaseItem).
-		AddSubTxDef(labelOrder, orderSuccess, compensateOrder).
-		AddSubTxDef(labelPayment, paymentSuccess, compensatePayment)
-
-	ctx := context.Background()
-	property := &orderProperty{}
-	sagaInstance := saga.StartSaga(ctx, sagaTopicID).
-		ExecSub(labelPurchaseItem, property, input.Item).
-		ExecSub(labelOrder, property, input.Item, input.Price).
-		ExecSub(labelPayment, property, input.PaymentMethod, input.Price).
-		EndSaga()
+// handlerBuy defines buy handler
+func handlerBuy(w http.ResponseWriter, r *http.Request) {
+	executeSaga(w, r, sagaBuy)
+}
 
-	generateResponse(w, sagaInstance.IsAborted())
-	return
+// handlerNestedBuy defines buy handler with reservation child saga
+func handlerNestedBuy(w http.ResponseWriter, r *http.Request) {
+	executeSaga(w, r, sagaNestedBuy)
 }
 
-// handlerPurchaseItemFailed defines puchase item failed hanlder
-func handlerPurchaseItemFailed(w http.ResponseWriter, r *http.Request) {
+// executeSaga will run saga registered under given saga type
+func executeSaga(w http.ResponseWriter, r *http.Request, sagaType string) {
 	input, err := getInput(r)
 	if err != nil {
-		log.Panicln(err.Error())
+		generateErrorResponse(w, http.StatusBadRequest, err)
 		return
 	}
 
-	saga.AddSubTxDef(labelPurchaseItem, purchaseItemFailed, compensatePurchaseItem).
-		AddSubTxDef(labelOrder, orderSuccess, compensateOrder).
-		AddSubTxDef(labelPayment, paymentSuccess, compensatePayment)
-
-	ctx := context.Background()
-	property := &orderProperty{}
-	sagaInstance := saga.StartSaga(ctx, sagaTopicID).
-		ExecSub(labelPurchaseItem, property, input.Item).
-		ExecSub(labelOrder, property, input.Item, input.Price).
-		ExecSub(labelPayment, property, input.PaymentMethod, input.Price).
-		EndSaga()
-
-	generateResponse(w, sagaInstance.IsAborted())
-	return
-}
-
-// handlerOrderFailed defines order failed handler
-func handlerOrderFailed(w http.ResponseWriter, r *http.Request) {
-	input, err := getInput(r)
-	if err != nil {
-		log.Panicln(err.Error())
+	if err = injectFaults(r, &input); err != nil {
+		generateErrorResponse(w, http.StatusBadRequest, err)
 		return
 	}
 
-	saga.AddSubTxDef(labelPurchaseItem, purchaseItemSuccess, compensatePurchaseItem).
-		AddSubTxDef(labelOrder, orderFailed, compensateOrder).
-		AddSubTxDef(labelPayment, paymentSuccess, compensatePayment)
+	var started func(exec *saga.Execution)
+	if key := r.Header.Get(idempotency.HeaderKey); key != "" {
+		hash := requestHash(sagaType, input)
+		record, err := idempotencyKeys.begin(key, hash)
+		switch {
+		case err == errIdempotencyKeyTooLong:
+			generateErrorResponse(w, http.StatusBadRequest, err)
+			return
+		case err == errIdempotencyKeyReused:
+			generateErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		case err != nil:
+			log.Println(err.Error())
+			generateErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		case record != nil:
+			replaySaga(w, record)
+			return
+		}
 
-	ctx := context.Background()
-	property := &orderProperty{}
-	sagaInstance := saga.StartSaga(ctx, sagaTopicID).
-		ExecSub(labelPurchaseItem, property, input.Item).
-		ExecSub(labelOrder, property, input.Item, input.Price).
-		ExecSub(labelPayment, property, input.PaymentMethod, input.Price).
-		EndSaga()
+		var once sync.Once
+		started = func(exec *saga.Execution) {
+			once.Do(func() { idempotencyKeys.commit(key, hash, exec.ID) })
+		}
+		defer once.Do(func() { idempotencyKeys.abandon(key) })
+	}
 
-	generateResponse(w, sagaInstance.IsAborted())
-	return
-}
+	if isAsync(r) {
+		submitSaga(w, sagaType, input, started)
+		return
+	}
 
-// handlerPaymentFailed defines payment failed handler
-func handlerPaymentFailed(w http.ResponseWriter, r *http.Request) {
-	input, err := getInput(r)
+	sagaInstance, err := sagaCoordinator.ExecuteFunc(r.Context(), sagaType, input, started)
 	if err != nil {
-		log.Panicln(err.Error())
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	saga.AddSubTxDef(labelPurchaseItem, purchaseItemSuccess, compensatePurchaseItem).
-		AddSubTxDef(labelOrder, orderSuccess, compensateOrder).
-		AddSubTxDef(labelPayment, paymentFailed, compensatePayment)
-
-	ctx := context.Background()
-	property := &orderProperty{}
-	sagaInstance := saga.StartSaga(ctx, sagaTopicID).
-		ExecSub(labelPurchaseItem, property, input.Item).
-		ExecSub(labelOrder, property, input.Item, input.Price).
-		ExecSub(labelPayment, property, input.PaymentMethod, input.Price).
-		EndSaga()
-
-	generateResponse(w, sagaInstance.IsAborted())
+	generateResponse(w, sagaInstance)
 	return
 }
 
-func generateResponse(w http.ResponseWriter, isAborted bool) {
-	response := buyItemResponse{Success: !isAborted}
+func generateResponse(w http.ResponseWriter, sagaInstance *saga.Execution) {
+	response := buyItemResponse{
+		SagaID:  sagaInstance.ID,
+		Success: !sagaInstance.IsAborted(),
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
+
+func generateErrorResponse(w http.ResponseWriter, statusCode int, err error) {
+	response := errorResponse{Error: err.Error()}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
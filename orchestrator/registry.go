@@ -0,0 +1,126 @@
+package orchestrator
+
+import (
+	"context"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/saga"
+)
+
+const (
+	sagaBuy       = "buy"
+	sagaNestedBuy = "nested-buy"
+
+	// sagaReservation is child saga which purchases item and records order
+	sagaReservation = "reservation"
+)
+
+// definitionConfig defines execution settings shared by every saga type
+type definitionConfig struct {
+	StepTimeout       time.Duration
+	Retry             saga.RetryPolicy
+	CompensationRetry saga.RetryPolicy
+}
+
+// newDefinition will create new saga definition buying item.
+// Purchasing item and recording order do not depend on each other so they
+// run concurrently, while payment needs both of them. Item stays reserved
+// and order stays pending until payment is done, then both are confirmed.
+// Digital item is not purchased from item service, and free item is not paid.
+func newDefinition(cfg definitionConfig) *saga.Definition {
+	def := saga.NewDefinition(sagaBuy).
+		SetStepTimeout(cfg.StepTimeout).
+		SetCompensationRetryPolicy(cfg.CompensationRetry).
+		AddSubTxDef(labelPurchaseItem, purchaseItem, compensatePurchaseItem).
+		AddSubTxDef(labelOrder, createOrder, compensateOrder).
+		AddSubTxDef(labelPayment, pay, nil).
+		SetDependencies(labelOrder).
+		SetDependencies(labelPayment, labelPurchaseItem, labelOrder).
+		SetCondition(labelPurchaseItem, isPhysicalItem).
+		SetCondition(labelPayment, isPaidItem).
+		SetRetryPolicy(labelPurchaseItem, cfg.Retry).
+		SetRetryPolicy(labelOrder, cfg.Retry).
+		SetRetryPolicy(labelPayment, cfg.Retry)
+	return addConfirmations(def, cfg)
+}
+
+// newReservationDefinition will create child saga definition which purchases
+// item and records order concurrently
+func newReservationDefinition(cfg definitionConfig) *saga.Definition {
+	return saga.NewDefinition(sagaReservation).
+		SetStepTimeout(cfg.StepTimeout).
+		SetCompensationRetryPolicy(cfg.CompensationRetry).
+		AddSubTxDef(labelPurchaseItem, purchaseItem, compensatePurchaseItem).
+		AddSubTxDef(labelOrder, createOrder, compensateOrder).
+		SetDependencies(labelOrder).
+		SetCondition(labelPurchaseItem, isPhysicalItem).
+		SetRetryPolicy(labelPurchaseItem, cfg.Retry).
+		SetRetryPolicy(labelOrder, cfg.Retry)
+}
+
+// newNestedDefinition will create saga definition which reserves item and
+// order as a single reservation child saga before payment. Failed payment
+// compensates the whole reservation saga.
+func newNestedDefinition(cfg definitionConfig) *saga.Definition {
+	def := saga.NewDefinition(sagaNestedBuy).
+		SetStepTimeout(cfg.StepTimeout).
+		SetCompensationRetryPolicy(cfg.CompensationRetry).
+		AddSubSagaDef(labelReservation, sagaReservation, nil).
+		AddSubTxDef(labelPayment, pay, nil).
+		SetCondition(labelPayment, isPaidItem).
+		SetRetryPolicy(labelPayment, cfg.Retry)
+	return addConfirmations(def, cfg)
+}
+
+// addConfirmations will append sub-transactions which confirm reserved item
+// and approve pending order once payment is done, releasing their semantic
+// locks. Payment is the pivot of saga: customer has been charged, so
+// nothing is rolled back after it. Confirmations have no compensation and
+// are retried like compensations, and saga whose confirmation still fails
+// is dead-lettered until operator retries it.
+func addConfirmations(def *saga.Definition, cfg definitionConfig) *saga.Definition {
+	return def.
+		SetPivot(labelPayment).
+		AddSubTxDef(labelConfirmItem, confirmItem, nil).
+		AddSubTxDef(labelApproveOrder, approveOrder, nil).
+		SetDependencies(labelConfirmItem, labelPayment).
+		SetDependencies(labelApproveOrder, labelPayment).
+		SetCondition(labelConfirmItem, isPhysicalItem).
+		SetRetryPolicy(labelConfirmItem, cfg.CompensationRetry).
+		SetRetryPolicy(labelApproveOrder, cfg.CompensationRetry)
+}
+
+// isPhysicalItem returns true if bought item is not digital
+func isPhysicalItem(ctx context.Context, exec *saga.Execution) (bool, error) {
+	var input buyItemRequest
+	if err := exec.Input(&input); err != nil {
+		return false, err
+	}
+	return !input.Digital, nil
+}
+
+// isPaidItem returns true if bought item is not free
+func isPaidItem(ctx context.Context, exec *saga.Execution) (bool, error) {
+	var input buyItemRequest
+	if err := exec.Input(&input); err != nil {
+		return false, err
+	}
+	return input.Price > 0, nil
+}
+
+// newRegistry will register every saga type used by orchestrator
+func newRegistry(cfg definitionConfig) *saga.Registry {
+	registry := saga.NewRegistry()
+	definitions := []*saga.Definition{
+		newDefinition(cfg),
+		newReservationDefinition(cfg),
+		newNestedDefinition(cfg),
+	}
+
+	for _, def := range definitions {
+		if err := registry.Register(def); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
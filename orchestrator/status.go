@@ -0,0 +1,28 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/cikupin/saga-simple-example/saga"
+	"github.com/gorilla/mux"
+)
+
+// handlerSagaStatus defines saga status handler
+func handlerSagaStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := sagaCoordinator.Status(mux.Vars(r)["id"])
+	if err == saga.ErrSagaNotFound {
+		generateErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
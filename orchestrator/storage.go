@@ -0,0 +1,34 @@
+package orchestrator
+
+import (
+	"fmt"
+	"time"
+
+	gosaga "github.com/cikupin/go-saga"
+	"github.com/cikupin/saga-simple-example/config"
+	"github.com/cikupin/saga-simple-example/storage"
+)
+
+// newStorage will create selected saga log storage engine.
+// Kafka engine is provided by go-saga, which registers it on import.
+func newStorage(engine, dir string) (storage.Storage, error) {
+	switch engine {
+	case storage.EngineKafka:
+		return gosaga.StorageProvider(gosaga.StorageConfig), nil
+	case storage.EngineMemory:
+		return storage.NewMemoryStorage(), nil
+	case storage.EngineFile:
+		return storage.NewFileStorage(dir)
+	default:
+		return nil, fmt.Errorf("unknown saga log storage engine %s", engine)
+	}
+}
+
+// setKafkaConfig will configure kafka storage engine provided by go-saga
+func setKafkaConfig(cfg config.Kafka) {
+	gosaga.StorageConfig.Kafka.ZkAddrs = cfg.ZkAddrs
+	gosaga.StorageConfig.Kafka.BrokerAddrs = cfg.BrokerAddrs
+	gosaga.StorageConfig.Kafka.Partitions = cfg.Partitions
+	gosaga.StorageConfig.Kafka.Replicas = cfg.Replicas
+	gosaga.StorageConfig.Kafka.ReturnDuration = time.Duration(cfg.ReturnDuration)
+}
@@ -1,92 +1,68 @@
 package orchestrator
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
-	"io/ioutil"
 	"log"
-	"net/http"
 
 	"github.com/cikupin/saga-simple-example/item"
+	"github.com/cikupin/saga-simple-example/saga"
 )
 
-// purchaseItemSuccess will purchase item and success
-func purchaseItemSuccess(ctx context.Context, prop *orderProperty, itemName string) error {
-	payload := item.Request{
-		Item: itemName,
+// purchaseItem will purchase item
+func purchaseItem(ctx context.Context, exec *saga.Execution) (interface{}, error) {
+	var input buyItemRequest
+	if err := exec.Input(&input); err != nil {
+		return nil, err
 	}
-	payloadBytes, _ := json.Marshal(payload)
 
-	resp, err := http.Post("http://localhost:8001/item-success", "application/json", bytes.NewReader(payloadBytes))
+	ctx = callContext(ctx, exec, stepKey(exec, labelPurchaseItem), actionFault(exec, labelPurchaseItem))
+	response, err := itemClient.Purchase(ctx, item.Request{
+		Item:   input.Item,
+		SagaID: exec.ID,
+	})
 	if err != nil {
+		err = classify(err)
 		log.Println(err.Error())
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 500 {
-		err = errors.New("request error to service item")
-		log.Println(err.Error())
-		return err
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-
-	var response item.Response
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-
-	prop.PurchaseItemID = response.PuchaseItemID
-	return nil
+	return response, nil
 }
 
-// purchaseItemFailed will purchase item and failed
-func purchaseItemFailed(ctx context.Context, prop *orderProperty, itemName string) error {
-	payload := item.Request{
-		Item: itemName,
+// confirmItem will confirm item reserved by purchase-item step, releasing
+// its lock once the saga has succeeded
+func confirmItem(ctx context.Context, exec *saga.Execution) (interface{}, error) {
+	var purchased item.Response
+	if err := exec.Output(labelPurchaseItem, &purchased); err != nil {
+		return nil, err
 	}
-	payloadBytes, _ := json.Marshal(payload)
 
-	resp, err := http.Post("http://localhost:8001/item-failed", "application/json", bytes.NewReader(payloadBytes))
+	ctx = callContext(ctx, exec, stepKey(exec, labelConfirmItem), actionFault(exec, labelConfirmItem))
+	response, err := itemClient.Confirm(ctx, item.ConfirmationRequest{
+		PurchaseItemID: purchased.PuchaseItemID,
+	})
 	if err != nil {
+		err = classify(err)
 		log.Println(err.Error())
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 500 {
-		err = errors.New("request error to service item")
-		log.Println(err.Error())
-		return err
+		return nil, err
 	}
+	return response, nil
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Println(err.Error())
-		return err
+// compensatePurchaseItem will rollback purchased item
+func compensatePurchaseItem(ctx context.Context, exec *saga.Execution) (interface{}, error) {
+	var purchased item.Response
+	if err := exec.Output(labelPurchaseItem, &purchased); err != nil {
+		return nil, err
 	}
 
-	var response item.Response
-	err = json.Unmarshal(body, &response)
+	ctx = callContext(ctx, exec, compensationKey(exec, labelPurchaseItem), compensationFault(exec, labelPurchaseItem))
+	response, err := itemClient.Compensate(ctx, item.CompensationRequest{
+		PurchaseItemID: purchased.PuchaseItemID,
+	})
 	if err != nil {
+		err = classify(err)
 		log.Println(err.Error())
-		return err
+		return nil, err
 	}
-
-	prop.PurchaseItemID = response.PuchaseItemID
-	return nil
-}
-
-// compensatePurchaseItem will return nil
-func compensatePurchaseItem(ctx context.Context, prop *orderProperty, itemName string) error {
-	return nil
+	return response, nil
 }
@@ -1,127 +1,69 @@
 package orchestrator
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
-	"io/ioutil"
 	"log"
-	"net/http"
 
-	"github.com/cikupin/saga-simple-example/item"
 	"github.com/cikupin/saga-simple-example/order"
+	"github.com/cikupin/saga-simple-example/saga"
 )
 
-// orderSuccess will record order data and success
-func orderSuccess(ctx context.Context, prop *orderProperty, itemName string, price int) error {
-	payload := order.Request{
-		Item:  itemName,
-		Price: price,
+// createOrder will record order data
+func createOrder(ctx context.Context, exec *saga.Execution) (interface{}, error) {
+	var input buyItemRequest
+	if err := exec.Input(&input); err != nil {
+		return nil, err
 	}
-	payloadBytes, _ := json.Marshal(payload)
 
-	resp, err := http.Post("http://localhost:8002/order-success", "application/json", bytes.NewReader(payloadBytes))
+	ctx = callContext(ctx, exec, stepKey(exec, labelOrder), actionFault(exec, labelOrder))
+	response, err := orderClient.Create(ctx, order.Request{
+		Item:   input.Item,
+		Price:  input.Price,
+		SagaID: exec.ID,
+	})
 	if err != nil {
+		err = classify(err)
 		log.Println(err.Error())
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 500 {
-		err = errors.New("request error to service order")
-		log.Println(err.Error())
-		return err
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-
-	var response order.Response
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-
-	prop.OrderID = response.OrderID
-	return nil
+	return response, nil
 }
 
-// orderFailed will record order data and failed
-func orderFailed(ctx context.Context, prop *orderProperty, itemName string, price int) error {
-	payload := order.Request{
-		Item:  itemName,
-		Price: price,
-	}
-	payloadBytes, _ := json.Marshal(payload)
-
-	resp, err := http.Post("http://localhost:8002/order-failed", "application/json", bytes.NewReader(payloadBytes))
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 500 {
-		err = errors.New("request error to service order")
-		log.Println(err.Error())
-		return err
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Println(err.Error())
-		return err
+// approveOrder will approve order recorded as pending by order step once
+// the saga has succeeded
+func approveOrder(ctx context.Context, exec *saga.Execution) (interface{}, error) {
+	var recorded order.Response
+	if err := exec.Output(labelOrder, &recorded); err != nil {
+		return nil, err
 	}
 
-	var response order.Response
-	err = json.Unmarshal(body, &response)
+	ctx = callContext(ctx, exec, stepKey(exec, labelApproveOrder), actionFault(exec, labelApproveOrder))
+	response, err := orderClient.Approve(ctx, order.ApprovalRequest{
+		OrderID: recorded.OrderID,
+	})
 	if err != nil {
+		err = classify(err)
 		log.Println(err.Error())
-		return err
+		return nil, err
 	}
-
-	prop.OrderID = response.OrderID
-	return nil
+	return response, nil
 }
 
-// compensateOrder will rollback item purchase if fail to request order
-func compensateOrder(ctx context.Context, prop *orderProperty, itemName string, price int) error {
-	payload := item.CompensationRequest{
-		PurchaseItemID: prop.PurchaseItemID,
-	}
-	payloadBytes, _ := json.Marshal(payload)
-
-	resp, err := http.Post("http://localhost:8001/item-compensated", "application/json", bytes.NewReader(payloadBytes))
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 500 {
-		err = errors.New("request error to rollback item")
-		log.Println(err.Error())
-		return err
+// compensateOrder will rollback created order
+func compensateOrder(ctx context.Context, exec *saga.Execution) (interface{}, error) {
+	var created order.Response
+	if err := exec.Output(labelOrder, &created); err != nil {
+		return nil, err
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	ctx = callContext(ctx, exec, compensationKey(exec, labelOrder), compensationFault(exec, labelOrder))
+	response, err := orderClient.Compensate(ctx, order.CompensationRequest{
+		OrderID: created.OrderID,
+	})
 	if err != nil {
+		err = classify(err)
 		log.Println(err.Error())
-		return err
+		return nil, err
 	}
-
-	var response item.Response
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-
-	prop.PurchaseItemID = response.PuchaseItemID
-	return nil
+	return response, nil
 }
@@ -1,123 +1,36 @@
 package orchestrator
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
-	"io/ioutil"
 	"log"
-	"net/http"
 
 	"github.com/cikupin/saga-simple-example/order"
 	"github.com/cikupin/saga-simple-example/payment"
+	"github.com/cikupin/saga-simple-example/saga"
 )
 
-// paymentSuccess will do payment and success
-func paymentSuccess(ctx context.Context, prop *orderProperty, paymentMethod string, price int) error {
-	payload := payment.Request{
-		PaymentMethod: paymentMethod,
-		Price:         price,
-		OrderID:       prop.OrderID,
+// pay will do payment
+func pay(ctx context.Context, exec *saga.Execution) (interface{}, error) {
+	var input buyItemRequest
+	if err := exec.Input(&input); err != nil {
+		return nil, err
 	}
-	payloadBytes, _ := json.Marshal(payload)
 
-	resp, err := http.Post("http://localhost:8003/payment-success", "application/json", bytes.NewReader(payloadBytes))
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 500 {
-		err = errors.New("request error to service payment")
-		log.Println(err.Error())
-		return err
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-
-	var response payment.Response
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-	return nil
-}
-
-// paymentFailed will do payment and failed
-func paymentFailed(ctx context.Context, prop *orderProperty, paymentMethod string, price int) error {
-	payload := payment.Request{
-		PaymentMethod: paymentMethod,
-		Price:         price,
-		OrderID:       prop.OrderID,
-	}
-	payloadBytes, _ := json.Marshal(payload)
-
-	resp, err := http.Post("http://localhost:8003/payment-failed", "application/json", bytes.NewReader(payloadBytes))
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 500 {
-		err = errors.New("request error to service payment")
-		log.Println(err.Error())
-		return err
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-
-	var response payment.Response
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-	return nil
-}
-
-// compensatePayment will rollback order if fail to request payment
-func compensatePayment(ctx context.Context, prop *orderProperty, paymentMethod string, price int) error {
-	payload := order.CompensationRequest{
-		OrderID: prop.OrderID,
-	}
-	payloadBytes, _ := json.Marshal(payload)
-
-	resp, err := http.Post("http://localhost:8002/order-compensated", "application/json", bytes.NewReader(payloadBytes))
-	if err != nil {
-		log.Println(err.Error())
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 500 {
-		err = errors.New("request error to rollback order")
-		log.Println(err.Error())
-		return err
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Println(err.Error())
-		return err
+	var created order.Response
+	if err := exec.Output(labelOrder, &created); err != nil {
+		return nil, err
 	}
 
-	var response payment.Response
-	err = json.Unmarshal(body, &response)
+	ctx = callContext(ctx, exec, stepKey(exec, labelPayment), actionFault(exec, labelPayment))
+	response, err := paymentClient.Pay(ctx, payment.Request{
+		PaymentMethod: input.PaymentMethod,
+		Price:         input.Price,
+		OrderID:       created.OrderID,
+	})
 	if err != nil {
+		err = classify(err)
 		log.Println(err.Error())
-		return err
+		return nil, err
 	}
-	return nil
+	return response, nil
 }
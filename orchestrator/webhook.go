@@ -0,0 +1,236 @@
+package orchestrator
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/saga"
+	"github.com/cikupin/saga-simple-example/storage"
+	"github.com/gorilla/mux"
+)
+
+const (
+	eventSagaCompleted          = "saga.completed"
+	eventSagaAborted            = "saga.aborted"
+	eventSagaCompensationFailed = "saga.compensation_failed"
+	eventSagaStepFailed         = "saga.step_failed"
+
+	// webhookLogPrefix is prepended to saga ID to build webhook delivery logID
+	webhookLogPrefix = "webhook_"
+
+	webhookInitialBackoff = time.Second
+	webhookMaxBackoff     = time.Minute
+)
+
+type (
+	webhookEvent struct {
+		Event  string       `json:"event"`
+		SagaID string       `json:"saga_id"`
+		Time   time.Time    `json:"time"`
+		Saga   *saga.Status `json:"saga"`
+	}
+
+	webhookDelivery struct {
+		Event      string    `json:"event"`
+		URL        string    `json:"url"`
+		Attempt    int       `json:"attempt"`
+		Time       time.Time `json:"time"`
+		StatusCode int       `json:"status_code,omitempty"`
+		Error      string    `json:"error,omitempty"`
+		Delivered  bool      `json:"delivered"`
+	}
+
+	// webhookSender posts signed saga outcome events to callback URL
+	// and records every delivery attempt in saga log storage
+	webhookSender struct {
+		storage     storage.Storage
+		secret      []byte
+		maxAttempts int
+		backoff     time.Duration
+		client      *http.Client
+	}
+)
+
+var webhooks *webhookSender
+
+func newWebhookSender(logStorage storage.Storage, secret string, maxAttempts int) *webhookSender {
+	if secret == "" {
+		log.Println("webhook secret is empty, webhook events will not be signed")
+	}
+
+	return &webhookSender{
+		storage:     logStorage,
+		secret:      []byte(secret),
+		maxAttempts: maxAttempts,
+		backoff:     webhookInitialBackoff,
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// listen will send webhook event once saga is finished
+func (s *webhookSender) listen(l saga.Log) {
+	if l.Type != saga.SagaEnd {
+		return
+	}
+	go s.send(l.SagaID)
+}
+
+func (s *webhookSender) send(sagaID string) {
+	status, err := sagaCoordinator.Status(sagaID)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	// outcome of child saga is reported with its parent saga
+	if status.ParentID != "" {
+		return
+	}
+
+	var input buyItemRequest
+	if err = json.Unmarshal(status.Input, &input); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if input.CallbackURL == "" {
+		return
+	}
+
+	s.post(input.CallbackURL, webhookEvent{
+		Event:  outcomeEvent(status),
+		SagaID: sagaID,
+		Time:   time.Now(),
+		Saga:   status,
+	})
+}
+
+// post will deliver webhook event to callback URL, retrying with
+// exponential backoff up to maxAttempts times
+func (s *webhookSender) post(url string, event webhookEvent) {
+	payloadBytes, _ := json.Marshal(event)
+
+	backoff := s.backoff
+	for attempt := 1; attempt <= s.maxAttempts; attempt++ {
+		delivery := s.deliver(url, event.Event, payloadBytes)
+		delivery.Attempt = attempt
+		s.record(event.SagaID, delivery)
+
+		if delivery.Delivered {
+			return
+		}
+		if attempt == s.maxAttempts {
+			break
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+	log.Printf("saga %s : giving up webhook delivery to %s after %d attempts\n", event.SagaID, url, s.maxAttempts)
+}
+
+// deliver will post webhook event once
+func (s *webhookSender) deliver(url, event string, payloadBytes []byte) webhookDelivery {
+	delivery := webhookDelivery{
+		Event: event,
+		URL:   url,
+		Time:  time.Now(),
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payloadBytes))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Saga-Event", event)
+	if len(s.secret) > 0 {
+		req.Header.Set("X-Saga-Signature", "sha256="+s.sign(payloadBytes))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Delivered {
+		delivery.Error = fmt.Sprintf("callback responded with status %d", resp.StatusCode)
+	}
+	return delivery
+}
+
+// sign returns hex encoded HMAC-SHA256 of payload
+func (s *webhookSender) sign(payloadBytes []byte) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payloadBytes)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *webhookSender) record(sagaID string, delivery webhookDelivery) {
+	data, _ := json.Marshal(delivery)
+	if err := s.storage.AppendLog(webhookLogPrefix+sagaID, string(data)); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// deliveries will return every recorded delivery attempt of a saga
+func (s *webhookSender) deliveries(sagaID string) ([]webhookDelivery, error) {
+	data, err := s.storage.Lookup(webhookLogPrefix + sagaID)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]webhookDelivery, 0, len(data))
+	for _, d := range data {
+		var delivery webhookDelivery
+		if err = json.Unmarshal([]byte(d), &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// outcomeEvent returns webhook event name of finished saga
+func outcomeEvent(status *saga.Status) string {
+	for _, subTx := range status.SubTxs {
+		if subTx.State == saga.StateCompensationFailed {
+			return eventSagaCompensationFailed
+		}
+	}
+	switch status.State {
+	case saga.StateAborted:
+		return eventSagaAborted
+	case saga.StateDeadLettered:
+		// saga has failed after payment and waits to be retried
+		return eventSagaStepFailed
+	}
+	return eventSagaCompleted
+}
+
+// handlerWebhookDeliveries defines webhook delivery attempts handler
+func handlerWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := webhooks.deliveries(mux.Vars(r)["id"])
+	if err != nil {
+		log.Println(err.Error())
+		generateErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deliveries)
+}
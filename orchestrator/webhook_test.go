@@ -0,0 +1,239 @@
+package orchestrator
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/saga"
+	"github.com/cikupin/saga-simple-example/storage"
+)
+
+// callback records webhook requests, failing the first ones
+type callback struct {
+	mu       sync.Mutex
+	fail     int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (c *callback) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests = append(c.requests, r)
+	c.bodies = append(c.bodies, body)
+	if len(c.requests) <= c.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *callback) received() ([]*http.Request, [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.requests, c.bodies
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	payload := []byte(`{"event":"saga.completed"}`)
+
+	tests := []struct {
+		name          string
+		secret        string
+		fail          int
+		wantDelivered bool
+		wantStatus    int
+	}{
+		{
+			name:          "signed",
+			secret:        "s3cret",
+			wantDelivered: true,
+			wantStatus:    http.StatusNoContent,
+		},
+		{
+			name:          "unsigned without secret",
+			wantDelivered: true,
+			wantStatus:    http.StatusNoContent,
+		},
+		{
+			name:          "callback failure",
+			secret:        "s3cret",
+			fail:          1,
+			wantDelivered: false,
+			wantStatus:    http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &callback{fail: tt.fail}
+			srv := httptest.NewServer(cb)
+			defer srv.Close()
+
+			s := newWebhookSender(storage.NewMemoryStorage(), tt.secret, 1)
+			delivery := s.deliver(srv.URL, eventSagaCompleted, payload)
+
+			if delivery.Delivered != tt.wantDelivered || delivery.StatusCode != tt.wantStatus {
+				t.Errorf("delivery = %+v, want delivered %v with status %d", delivery, tt.wantDelivered, tt.wantStatus)
+			}
+			if !tt.wantDelivered && delivery.Error == "" {
+				t.Error("failed delivery has no error")
+			}
+
+			requests, bodies := cb.received()
+			if len(requests) != 1 {
+				t.Fatalf("callback got %d requests, want 1", len(requests))
+			}
+			r := requests[0]
+			if got := r.Header.Get("X-Saga-Event"); got != eventSagaCompleted {
+				t.Errorf("X-Saga-Event = %q, want %q", got, eventSagaCompleted)
+			}
+			if string(bodies[0]) != string(payload) {
+				t.Errorf("body = %s, want %s", bodies[0], payload)
+			}
+
+			signature := r.Header.Get("X-Saga-Signature")
+			if tt.secret == "" {
+				if signature != "" {
+					t.Errorf("X-Saga-Signature = %q, want none", signature)
+				}
+				return
+			}
+			mac := hmac.New(sha256.New, []byte(tt.secret))
+			mac.Write(payload)
+			if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+				t.Errorf("X-Saga-Signature = %q, want %q", signature, want)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliverUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	s := newWebhookSender(storage.NewMemoryStorage(), "", 1)
+	delivery := s.deliver(url, eventSagaAborted, []byte(`{}`))
+	if delivery.Delivered || delivery.StatusCode != 0 || delivery.Error == "" {
+		t.Errorf("delivery = %+v, want failed delivery with error", delivery)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name          string
+		fail          int
+		maxAttempts   int
+		wantAttempts  int
+		wantDelivered bool
+	}{
+		{
+			name:          "first attempt delivered",
+			maxAttempts:   3,
+			wantAttempts:  1,
+			wantDelivered: true,
+		},
+		{
+			name:          "delivered after retry",
+			fail:          2,
+			maxAttempts:   3,
+			wantAttempts:  3,
+			wantDelivered: true,
+		},
+		{
+			name:          "giving up after max attempts",
+			fail:          5,
+			maxAttempts:   2,
+			wantAttempts:  2,
+			wantDelivered: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &callback{fail: tt.fail}
+			srv := httptest.NewServer(cb)
+			defer srv.Close()
+
+			s := newWebhookSender(storage.NewMemoryStorage(), "s3cret", tt.maxAttempts)
+			s.backoff = time.Millisecond
+			s.post(srv.URL, webhookEvent{Event: eventSagaCompleted, SagaID: "s1", Time: time.Now()})
+
+			if requests, _ := cb.received(); len(requests) != tt.wantAttempts {
+				t.Errorf("callback got %d requests, want %d", len(requests), tt.wantAttempts)
+			}
+
+			// every attempt is recorded in delivery log of the saga
+			deliveries, err := s.deliveries("s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deliveries) != tt.wantAttempts {
+				t.Fatalf("recorded %d deliveries, want %d", len(deliveries), tt.wantAttempts)
+			}
+			for i, d := range deliveries {
+				if d.Attempt != i+1 || d.URL != srv.URL || d.Event != eventSagaCompleted {
+					t.Errorf("delivery %d = %+v", i, d)
+				}
+			}
+			if last := deliveries[len(deliveries)-1]; last.Delivered != tt.wantDelivered {
+				t.Errorf("last delivery delivered = %v, want %v", last.Delivered, tt.wantDelivered)
+			}
+		})
+	}
+}
+
+func TestOutcomeEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		status saga.Status
+		want   string
+	}{
+		{
+			name:   "completed",
+			status: saga.Status{State: saga.StateCompleted},
+			want:   eventSagaCompleted,
+		},
+		{
+			name:   "aborted",
+			status: saga.Status{State: saga.StateAborted},
+			want:   eventSagaAborted,
+		},
+		{
+			name:   "dead-lettered",
+			status: saga.Status{State: saga.StateDeadLettered},
+			want:   eventSagaStepFailed,
+		},
+		{
+			name: "failed compensation",
+			status: saga.Status{
+				State: saga.StateDeadLettered,
+				SubTxs: []*saga.SubTxStatus{
+					{ID: "order", State: saga.StateCompensated},
+					{ID: "payment", State: saga.StateCompensationFailed},
+				},
+			},
+			want: eventSagaCompensationFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outcomeEvent(&tt.status); got != tt.want {
+				t.Errorf("outcomeEvent() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
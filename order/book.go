@@ -0,0 +1,133 @@
+package order
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/cikupin/saga-simple-example/outbox"
+)
+
+const (
+	// StatusPending means order is recorded by a saga which has not
+	// finished yet, so it must not be treated as final
+	StatusPending = "ORDER_PENDING"
+	// StatusApproved means order has been approved once its saga succeeded
+	StatusApproved = "ORDER_APPROVED"
+	// StatusCancelled means pending order has been rolled back
+	StatusCancelled = "ORDER_CANCELLED"
+
+	// firstID is ID of the first order
+	firstID = 32
+)
+
+var (
+	// ErrOrderNotFound means there is no order with given ID
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrOrderFinished means order has already been approved or cancelled,
+	// so it can no longer change the other way
+	ErrOrderFinished = errors.New("order is already finished")
+)
+
+// Order defines order recorded within a saga, kept by order service
+type Order struct {
+	OrderID int    `json:"order_id"`
+	SagaID  string `json:"saga_id,omitempty"`
+	Item    string `json:"item"`
+	Price   int    `json:"price"`
+	Status  string `json:"status"`
+}
+
+// book defines orders of order service, kept in its store together with
+// reply of every request changing them
+type book struct {
+	store *outbox.Outbox
+}
+
+func newBook(store *outbox.Outbox) *book {
+	return &book{store: store}
+}
+
+// create will record pending order of given saga. Saga recording order
+// again gets its existing order back. Request with the same key gets the
+// same order, even when it is sent again after a crash.
+func (b *book) create(key, sagaID, item string, price int) (Order, error) {
+	var o Order
+	err := b.store.Do(outbox.RequestKey("create", key), &o, func(tx *outbox.Tx) error {
+		var id int
+		found, err := tx.Get(sagaKey(sagaID), &id)
+		if err != nil {
+			return err
+		}
+		if found {
+			_, err = tx.Get(orderKey(id), &o)
+			return err
+		}
+
+		id, err = tx.Sequence(lastIDKey, firstID)
+		if err != nil {
+			return err
+		}
+		o = Order{
+			OrderID: id,
+			SagaID:  sagaID,
+			Item:    item,
+			Price:   price,
+			Status:  StatusPending,
+		}
+		if err = tx.Set(orderKey(id), o); err != nil {
+			return err
+		}
+		if sagaID == "" {
+			return nil
+		}
+		return tx.Set(sagaKey(sagaID), id)
+	})
+	return o, err
+}
+
+// approve will mark pending order as approved
+func (b *book) approve(key string, orderID int) (Order, error) {
+	return b.finish(outbox.RequestKey("approve", key), orderID, StatusApproved)
+}
+
+// cancel will roll back pending order
+func (b *book) cancel(key string, orderID int) (Order, error) {
+	return b.finish(outbox.RequestKey("cancel", key), orderID, StatusCancelled)
+}
+
+// finish will move pending order into given status. Finishing order again
+// with the same status does nothing.
+func (b *book) finish(key string, orderID int, status string) (Order, error) {
+	var o Order
+	err := b.store.Do(key, &o, func(tx *outbox.Tx) error {
+		found, err := tx.Get(orderKey(orderID), &o)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrOrderNotFound
+		}
+		if o.Status != StatusPending && o.Status != status {
+			return ErrOrderFinished
+		}
+
+		o.Status = status
+		return tx.Set(orderKey(orderID), o)
+	})
+	return o, err
+}
+
+// get returns order with given ID
+func (b *book) get(orderID int) (Order, bool, error) {
+	var o Order
+	found, err := b.store.Get(orderKey(orderID), &o)
+	return o, found, err
+}
+
+func orderKey(orderID int) string {
+	return "orders/" + strconv.Itoa(orderID)
+}
+
+func sagaKey(sagaID string) string {
+	return "sagas/" + sagaID
+}
@@ -0,0 +1,103 @@
+package order
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cikupin/saga-simple-example/outbox"
+)
+
+func TestBook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	open := func() *book {
+		store, err := outbox.OpenDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newBook(store)
+	}
+
+	tests := []struct {
+		name string
+		// restart reopens book from its store before the request
+		restart bool
+		call    func(b *book) (Order, error)
+		wantID  int
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "create",
+			call:   func(b *book) (Order, error) { return b.create("s1/order", "s1", "book", 10) },
+			wantID: 32,
+			want:   StatusPending,
+		},
+		{
+			name:   "saga creating order again gets it back",
+			call:   func(b *book) (Order, error) { return b.create("", "s1", "book", 10) },
+			wantID: 32,
+			want:   StatusPending,
+		},
+		{
+			name:   "order without saga",
+			call:   func(b *book) (Order, error) { return b.create("", "", "pen", 5) },
+			wantID: 33,
+			want:   StatusPending,
+		},
+		{
+			name:   "cancel",
+			call:   func(b *book) (Order, error) { return b.cancel("s1/order/compensation", 32) },
+			wantID: 32,
+			want:   StatusCancelled,
+		},
+		{
+			name:    "cancelled order can not be approved",
+			call:    func(b *book) (Order, error) { return b.approve("s1/order/approval", 32) },
+			wantID:  32,
+			want:    StatusCancelled,
+			wantErr: ErrOrderFinished,
+		},
+		{
+			name:    "unknown order",
+			call:    func(b *book) (Order, error) { return b.approve("s9/order/approval", 99) },
+			wantErr: ErrOrderNotFound,
+		},
+		{
+			name:    "request sent again after restart gets the same reply",
+			restart: true,
+			call:    func(b *book) (Order, error) { return b.create("s1/order", "s1", "book", 10) },
+			wantID:  32,
+			want:    StatusPending,
+		},
+		{
+			name:    "ID goes on after restart",
+			restart: true,
+			call:    func(b *book) (Order, error) { return b.create("s2/order", "s2", "book", 10) },
+			wantID:  34,
+			want:    StatusPending,
+		},
+	}
+
+	b := open()
+	for _, tt := range tests {
+		if tt.restart {
+			b.store.Close()
+			b = open()
+		}
+
+		o, err := tt.call(b)
+		if err != tt.wantErr {
+			t.Fatalf("%s : err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if o.OrderID != tt.wantID || o.Status != tt.want {
+			t.Fatalf("%s : order %d is %q, want order %d %q", tt.name, o.OrderID, o.Status, tt.wantID, tt.want)
+		}
+	}
+	b.store.Close()
+}
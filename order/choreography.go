@@ -0,0 +1,89 @@
+package order
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/eventbus"
+	"github.com/cikupin/saga-simple-example/events"
+	"github.com/cikupin/saga-simple-example/outbox"
+)
+
+// lastIDKey is store key of the latest order ID
+const lastIDKey = "order-last-id"
+
+// SubscribeEvents will make order service take part in choreography saga.
+// It records pending order once item is reserved, approves it once payment
+// is completed, and rolls it back when payment fails. Recorded order and
+// event telling about it are committed together into store, which relays
+// the event.
+func SubscribeEvents(bus *eventbus.Bus, store *outbox.Outbox) {
+	bus.Subscribe("order", func(e eventbus.Event) error {
+		var purchase events.Purchase
+		if err := json.Unmarshal(e.Data, &purchase); err != nil {
+			return err
+		}
+
+		switch e.Type {
+		case events.ItemReserved:
+			time.Sleep(purchase.Faults.Action(events.StepOrder).Latency)
+		case events.PaymentFailed:
+			time.Sleep(purchase.Faults.Compensation(events.StepOrder).Latency)
+		}
+
+		return store.Update(e.ID, func(tx *outbox.Tx) error {
+			key := "order/" + e.SagaID
+
+			switch e.Type {
+			case events.ItemReserved:
+				if purchase.Faults.Action(events.StepOrder).Fail {
+					log.Printf("purchase item %s for price $%d : FAILED!!! (injected fault)\n", purchase.Item, purchase.Price)
+					return tx.Publish(events.OrderFailed, e.SagaID, purchase)
+				}
+
+				orderID, err := tx.Sequence(lastIDKey, firstID)
+				if err != nil {
+					return err
+				}
+				purchase.OrderID = orderID
+				err = tx.Set(key, Order{
+					OrderID: purchase.OrderID,
+					SagaID:  e.SagaID,
+					Item:    purchase.Item,
+					Price:   purchase.Price,
+					Status:  StatusPending,
+				})
+				if err != nil {
+					return err
+				}
+
+				log.Printf("[order ID %d] purchase item %s for price $%d : pending\n", purchase.OrderID, purchase.Item, purchase.Price)
+				return tx.Publish(events.OrderCreated, e.SagaID, purchase)
+			case events.PaymentCompleted:
+				var order Order
+				found, err := tx.Get(key, &order)
+				if err != nil || !found {
+					return err
+				}
+				order.Status = StatusApproved
+
+				log.Printf("[order ID %d] approve order : success\n", order.OrderID)
+				return tx.Set(key, order)
+			case events.PaymentFailed:
+				var order Order
+				if _, err := tx.Get(key, &order); err != nil {
+					return err
+				}
+				order.Status = StatusCancelled
+				if err := tx.Set(key, order); err != nil {
+					return err
+				}
+
+				log.Printf("[rollback] rollback order_id %d : success\n", purchase.OrderID)
+				return tx.Publish(events.OrderCancelled, e.SagaID, purchase)
+			}
+			return nil
+		})
+	})
+}
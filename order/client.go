@@ -0,0 +1,75 @@
+package order
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/cikupin/saga-simple-example/broker"
+	"github.com/cikupin/saga-simple-example/config"
+	"github.com/cikupin/saga-simple-example/httpclient"
+	"github.com/cikupin/saga-simple-example/proto/orderpb"
+	"github.com/cikupin/saga-simple-example/transport"
+	"google.golang.org/grpc"
+)
+
+// Channel is the message broker channel of order service commands, named
+// after its config section as msg:// URL of orchestrator config must be
+const Channel = config.SectionOrder
+
+// Client calls order service
+type Client struct {
+	client transport.Transport
+}
+
+// NewClient will create order service client with given base URL
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	return &Client{
+		client: httpclient.New("order", baseURL, httpClient),
+	}
+}
+
+// NewGRPCClient will create order service client calling OrderService of
+// its gRPC server through conn
+func NewGRPCClient(conn *grpc.ClientConn) *Client {
+	return &Client{
+		client: grpcClient{rpc: orderpb.NewOrderServiceClient(conn)},
+	}
+}
+
+// NewBrokerClient will create order service client sending commands into
+// given message broker channel
+func NewBrokerClient(b broker.Broker, channel string) *Client {
+	return &Client{
+		client: broker.NewClient(b, channel, channel+"-replies"),
+	}
+}
+
+// Create will record pending order for the saga in request
+func (c *Client) Create(ctx context.Context, req Request) (Response, error) {
+	var resp Response
+	err := c.client.Post(ctx, "/order-created", req, &resp)
+	return resp, err
+}
+
+// Approve will approve pending order
+func (c *Client) Approve(ctx context.Context, req ApprovalRequest) (Response, error) {
+	var resp Response
+	err := c.client.Post(ctx, "/order-approved", req, &resp)
+	err = httpclient.WithCause(err, http.StatusNotFound, ErrOrderNotFound)
+	return resp, httpclient.WithCause(err, http.StatusConflict, ErrOrderFinished)
+}
+
+// Compensate will roll back pending order
+func (c *Client) Compensate(ctx context.Context, req CompensationRequest) (Response, error) {
+	var resp Response
+	err := c.client.Post(ctx, "/order-compensated", req, &resp)
+	return resp, httpclient.WithCause(err, http.StatusConflict, ErrOrderFinished)
+}
+
+// Get will return order with given ID
+func (c *Client) Get(ctx context.Context, orderID int) (Order, error) {
+	var o Order
+	err := c.client.Get(ctx, "/orders/"+strconv.Itoa(orderID), &o)
+	return o, err
+}
@@ -0,0 +1,112 @@
+package order
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/cikupin/saga-simple-example/grpctransport"
+	"github.com/cikupin/saga-simple-example/idempotency"
+	"github.com/cikupin/saga-simple-example/proto/orderpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type (
+	// grpcServer serves OrderService of proto/order.proto
+	grpcServer struct {
+		orderpb.UnimplementedOrderServiceServer
+	}
+
+	// grpcClient calls order service endpoints through OrderService client
+	grpcClient struct {
+		rpc orderpb.OrderServiceClient
+	}
+)
+
+// Create will record pending order for the saga in request
+func (grpcServer) Create(ctx context.Context, req *orderpb.Request) (*orderpb.Response, error) {
+	order, err := orders.create(grpctransport.IncomingHeader(ctx, idempotency.HeaderKey), req.SagaId, req.Item, int(req.Price))
+	if err != nil {
+		log.Printf("purchase item %s for price $%d : %s\n", req.Item, req.Price, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("[order ID %d] purchase item %s for price $%d : pending\n", order.OrderID, req.Item, req.Price)
+	return &orderpb.Response{
+		OrderId: int64(order.OrderID),
+		Success: true,
+	}, nil
+}
+
+// Approve will approve pending order once the saga which recorded it has
+// succeeded
+func (grpcServer) Approve(ctx context.Context, req *orderpb.ApprovalRequest) (*orderpb.Response, error) {
+	if _, err := orders.approve(grpctransport.IncomingHeader(ctx, idempotency.HeaderKey), int(req.OrderId)); err != nil {
+		log.Printf("approve order_id %d : %s\n", req.OrderId, err.Error())
+
+		switch err {
+		case ErrOrderNotFound:
+			return nil, status.Error(codes.NotFound, err.Error())
+		case ErrOrderFinished:
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("[order ID %d] approve order : success\n", req.OrderId)
+	return &orderpb.Response{
+		OrderId: req.OrderId,
+		Success: true,
+	}, nil
+}
+
+// Compensate will roll back pending order. Unknown order has nothing to
+// roll back.
+func (grpcServer) Compensate(ctx context.Context, req *orderpb.CompensationRequest) (*orderpb.Response, error) {
+	if _, err := orders.cancel(grpctransport.IncomingHeader(ctx, idempotency.HeaderKey), int(req.OrderId)); err != nil && err != ErrOrderNotFound {
+		log.Printf("[rollback] rollback order_id %d : %s\n", req.OrderId, err.Error())
+
+		if err != ErrOrderFinished {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	log.Printf("[rollback] rollback order_id %d : success\n", req.OrderId)
+	return &orderpb.Response{Success: true}, nil
+}
+
+// Post will call RPC method serving the same operation as given endpoint
+func (c grpcClient) Post(ctx context.Context, path string, in, out interface{}) error {
+	var reply *orderpb.Response
+	var err error
+
+	switch path {
+	case "/order-created":
+		req := in.(Request)
+		reply, err = c.rpc.Create(ctx, &orderpb.Request{Item: req.Item, Price: int64(req.Price), SagaId: req.SagaID})
+	case "/order-approved":
+		req := in.(ApprovalRequest)
+		reply, err = c.rpc.Approve(ctx, &orderpb.ApprovalRequest{OrderId: int64(req.OrderID)})
+	case "/order-compensated":
+		req := in.(CompensationRequest)
+		reply, err = c.rpc.Compensate(ctx, &orderpb.CompensationRequest{OrderId: int64(req.OrderID)})
+	default:
+		return grpctransport.NotServed("order", http.MethodPost, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp, ok := out.(*Response); ok {
+		resp.OrderID = int(reply.OrderId)
+		resp.Success = reply.Success
+	}
+	return nil
+}
+
+// Get is not served through gRPC
+func (c grpcClient) Get(ctx context.Context, path string, out interface{}) error {
+	return grpctransport.NotServed("order", http.MethodGet, path)
+}
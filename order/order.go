@@ -4,20 +4,38 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 
+	"github.com/cikupin/saga-simple-example/broker"
+	"github.com/cikupin/saga-simple-example/chaos"
+	"github.com/cikupin/saga-simple-example/config"
+	"github.com/cikupin/saga-simple-example/fault"
+	"github.com/cikupin/saga-simple-example/grpctransport"
+	"github.com/cikupin/saga-simple-example/idempotency"
+	"github.com/cikupin/saga-simple-example/outbox"
+	"github.com/cikupin/saga-simple-example/proto/orderpb"
 	"github.com/gorilla/mux"
 	"github.com/urfave/cli"
+	"google.golang.org/grpc"
 )
 
 type (
-	// Request defines order request
+	// Request defines order request. Order stays pending until the saga
+	// with given ID approves or rolls it back.
 	Request struct {
-		Item  string `json:"item"`
-		Price int    `json:"price"`
+		Item   string `json:"item"`
+		Price  int    `json:"price"`
+		SagaID string `json:"saga_id"`
+	}
+
+	// ApprovalRequest defines order approval request
+	ApprovalRequest struct {
+		OrderID int `json:"order_id"`
 	}
 
 	// CompensationRequest defines order compensation request
@@ -38,30 +56,82 @@ var Serve = cli.Command{
 	Usage:       "Run order service",
 	Description: "Execute this command to start order service",
 	Action:      startOrderService,
+	Flags:       config.Flags(config.SectionOrder),
 }
 
+// orders holds orders recorded through order service
+var orders *book
+
 // startOrderService will start order service
 func startOrderService(c *cli.Context) {
-	r := mux.NewRouter()
-	r.HandleFunc("/order-success", orderSuccess).Methods(http.MethodPost)
-	r.HandleFunc("/order-failed", orderFailed).Methods(http.MethodPost)
-	r.HandleFunc("/order-compensated", orderCompensation).Methods(http.MethodPost)
+	cfg, err := config.FromContext(c, config.SectionOrder)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	store, err := idempotency.Open(cfg.Order.IdempotencyDir)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
 
-	srv := &http.Server{
-		Addr:         ":8002",
-		WriteTimeout: time.Second * 3,
-		ReadTimeout:  time.Second * 3,
-		IdleTimeout:  time.Second * 10,
-		Handler:      r,
+	state, err := outbox.OpenDir(cfg.Order.StoreDir)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	orders = newBook(state)
+
+	monkey, err := chaos.New(cfg.Order.Chaos.Config())
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if monkey.Config().Enabled() {
+		log.Printf("chaos mode is enabled : %+v\n", monkey.Config())
 	}
 
+	r := mux.NewRouter()
+	r.Use(monkey.Middleware)
+	r.Use(fault.Middleware)
+	r.Use(store.Middleware)
+	r.Handle(chaos.AdminPath, monkey).Methods(http.MethodGet, http.MethodPut)
+	r.HandleFunc("/order-created", createOrder).Methods(http.MethodPost)
+	r.HandleFunc("/order-approved", orderApproval).Methods(http.MethodPost)
+	r.HandleFunc("/order-compensated", orderCompensation).Methods(http.MethodPost)
+	r.HandleFunc("/orders/{id}", getOrder).Methods(http.MethodGet)
+
+	srv := cfg.Order.Server.HTTPServer(r)
 	go func() {
-		log.Println("order service is running on port 8002")
+		log.Printf("order service is running on %s\n", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil {
 			log.Println(err)
 		}
 	}()
 
+	var grpcSrv *grpc.Server
+	if addr := cfg.Order.GRPCAddr; addr != "" {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		grpcSrv = grpctransport.NewServer(monkey.UnaryInterceptor, fault.UnaryInterceptor, store.UnaryInterceptor)
+		orderpb.RegisterOrderServiceServer(grpcSrv, grpcServer{})
+		go func() {
+			log.Printf("order gRPC service is running on %s\n", addr)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	msgBroker, err := broker.FromConfig(cfg.Order.Broker)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if msgBroker != nil {
+		broker.Serve(msgBroker, Channel, r)
+		log.Printf("order service is receiving commands from %s message broker channel %s\n", cfg.Order.Broker.Engine, Channel)
+	}
+
 	chanSignal := make(chan os.Signal, 1)
 	signal.Notify(chanSignal, os.Interrupt)
 	<-chanSignal
@@ -71,19 +141,37 @@ func startOrderService(c *cli.Context) {
 	defer cancel()
 
 	srv.Shutdown(ctx)
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+	if msgBroker != nil {
+		msgBroker.Close()
+	}
+	store.Close()
+	state.Close()
 	log.Println("shutting down")
 	os.Exit(0)
 }
 
-// orderSuccess defines order success logic
-func orderSuccess(w http.ResponseWriter, r *http.Request) {
+// createOrder defines order creation logic
+func createOrder(w http.ResponseWriter, r *http.Request) {
 	var payload Request
 	json.NewDecoder(r.Body).Decode(&payload)
 
-	log.Printf("[order ID 32] purchase item %s for price $%d : success\n", payload.Item, payload.Price)
+	order, err := orders.create(r.Header.Get(idempotency.HeaderKey), payload.SagaID, payload.Item, payload.Price)
+	if err != nil {
+		log.Printf("purchase item %s for price $%d : %s\n", payload.Item, payload.Price, err.Error())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{Success: false})
+		return
+	}
+
+	log.Printf("[order ID %d] purchase item %s for price $%d : pending\n", order.OrderID, payload.Item, payload.Price)
 
 	resp := Response{
-		OrderID: 32,
+		OrderID: order.OrderID,
 		Success: true,
 	}
 
@@ -92,27 +180,59 @@ func orderSuccess(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// orderFailed defines order failed logic
-func orderFailed(w http.ResponseWriter, r *http.Request) {
-	var payload Request
+// orderApproval defines order approval logic, run once the saga which
+// recorded pending order has succeeded
+func orderApproval(w http.ResponseWriter, r *http.Request) {
+	var payload ApprovalRequest
 	json.NewDecoder(r.Body).Decode(&payload)
 
-	log.Printf("purchase item %s for price $%d : FAILED!!!\n", payload.Item, payload.Price)
+	if _, err := orders.approve(r.Header.Get(idempotency.HeaderKey), payload.OrderID); err != nil {
+		log.Printf("approve order_id %d : %s\n", payload.OrderID, err.Error())
+
+		status := http.StatusInternalServerError
+		switch err {
+		case ErrOrderNotFound:
+			status = http.StatusNotFound
+		case ErrOrderFinished:
+			status = http.StatusConflict
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{Success: false})
+		return
+	}
+
+	log.Printf("[order ID %d] approve order : success\n", payload.OrderID)
 
 	resp := Response{
-		Success: false,
+		OrderID: payload.OrderID,
+		Success: true,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(resp)
 }
 
-// orderCompensation defines order compensation logic
+// orderCompensation defines order compensation logic. Unknown order has
+// nothing to roll back.
 func orderCompensation(w http.ResponseWriter, r *http.Request) {
 	var payload CompensationRequest
 	json.NewDecoder(r.Body).Decode(&payload)
 
+	if _, err := orders.cancel(r.Header.Get(idempotency.HeaderKey), payload.OrderID); err != nil && err != ErrOrderNotFound {
+		log.Printf("[rollback] rollback order_id %d : %s\n", payload.OrderID, err.Error())
+
+		status := http.StatusConflict
+		if err != ErrOrderFinished {
+			status = http.StatusInternalServerError
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{Success: false})
+		return
+	}
+
 	log.Printf("[rollback] rollback order_id %d : success\n", payload.OrderID)
 
 	resp := Response{
@@ -123,3 +243,27 @@ func orderCompensation(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(resp)
 }
+
+// getOrder will show order, including whether it is still pending
+func getOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	order, ok, err := orders.get(orderID)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(order)
+}
@@ -0,0 +1,485 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/storage"
+	"github.com/cikupin/saga-simple-example/uid"
+)
+
+const (
+	// entryLogID is the logID holding every committed entry
+	entryLogID = "outbox_entries"
+	// deliveredLogID is the logID holding ID of every delivered message
+	deliveredLogID = "outbox_delivered"
+	// snapshotLogPrefix is prepended to slot to build logID of snapshot
+	snapshotLogPrefix = "outbox_snapshot_"
+
+	// compactEvery is number of committed entries after which outbox is
+	// compacted into a snapshot
+	compactEvery = 1000
+	// keyRetention is how long key of committed entry is kept, so input
+	// delivered again within this period is recognized
+	keyRetention = 24 * time.Hour
+)
+
+type (
+	// Message defines outgoing message kept in outbox until it is delivered.
+	// Its ID is kept on every delivery, so receiver can recognize a message
+	// delivered more than once.
+	Message struct {
+		ID     string          `json:"id"`
+		Type   string          `json:"type"`
+		SagaID string          `json:"saga_id"`
+		Data   json.RawMessage `json:"data,omitempty"`
+	}
+
+	// entry defines state changes, messages and reply of one Update or Do.
+	// Entry is written as a single record, so all of them are persisted
+	// atomically.
+	entry struct {
+		Key      string                     `json:"key"`
+		State    map[string]json.RawMessage `json:"state,omitempty"`
+		Messages []Message                  `json:"messages,omitempty"`
+		Reply    json.RawMessage            `json:"reply,omitempty"`
+		Time     time.Time                  `json:"time"`
+	}
+
+	// snapshot defines outbox compacted from every entry committed before
+	// it. Only undelivered messages and keys within keyRetention are kept.
+	// It is saved into one of two logs in turn, so outbox still has the
+	// previous snapshot if saving fails halfway.
+	snapshot struct {
+		Seq     int                        `json:"seq"`
+		State   map[string]json.RawMessage `json:"state,omitempty"`
+		Keys    map[string]time.Time       `json:"keys,omitempty"`
+		Replies map[string]json.RawMessage `json:"replies,omitempty"`
+		Pending []Message                  `json:"pending,omitempty"`
+	}
+
+	// Publisher delivers outgoing message
+	Publisher interface {
+		Send(id, messageType, sagaID string, data json.RawMessage) error
+	}
+
+	// Outbox defines local store of a participant service, holding its
+	// state together with messages it has to send
+	Outbox struct {
+		storage storage.Storage
+
+		mu        sync.Mutex
+		state     map[string]json.RawMessage
+		keys      map[string]time.Time
+		replies   map[string]json.RawMessage
+		pending   []Message
+		delivered map[string]bool
+		notify    chan struct{}
+
+		// seq is Seq of the latest snapshot, and entries is number of
+		// entries committed since then
+		seq          int
+		entries      int
+		compactEvery int
+		keyRetention time.Duration
+	}
+
+	// Tx defines changes made within one Update
+	Tx struct {
+		outbox *Outbox
+		entry  entry
+	}
+)
+
+// Open will load outbox kept in given storage
+func Open(s storage.Storage) (*Outbox, error) {
+	o := &Outbox{
+		storage:      s,
+		state:        make(map[string]json.RawMessage),
+		keys:         make(map[string]time.Time),
+		replies:      make(map[string]json.RawMessage),
+		delivered:    make(map[string]bool),
+		notify:       make(chan struct{}, 1),
+		compactEvery: compactEvery,
+		keyRetention: keyRetention,
+	}
+
+	snap := o.lastSnapshot()
+	o.seq = snap.Seq
+	for key, value := range snap.State {
+		o.state[key] = value
+	}
+	for key, t := range snap.Keys {
+		o.keys[key] = t
+	}
+	for key, reply := range snap.Replies {
+		o.replies[key] = reply
+	}
+
+	delivered, err := s.Lookup(deliveredLogID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range delivered {
+		o.delivered[id] = true
+	}
+	o.queue(snap.Pending)
+
+	entries, err := s.Lookup(entryLogID)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range entries {
+		var e entry
+		if err = json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, err
+		}
+		o.apply(e)
+	}
+	o.entries = len(entries)
+	return o, nil
+}
+
+// OpenDir will load outbox kept in given directory, or in memory when
+// dir is empty
+func OpenDir(dir string) (*Outbox, error) {
+	if dir == "" {
+		return Open(storage.NewMemoryStorage())
+	}
+
+	s, err := storage.NewFileStorage(dir)
+	if err != nil {
+		return nil, err
+	}
+	return Open(s)
+}
+
+// Close releases resources held by outbox
+func (o *Outbox) Close() error {
+	return o.storage.Close()
+}
+
+// Update will run fn and persist state changes and messages made by fn
+// as a single entry. Update whose key has already been persisted does
+// nothing, so input delivered more than once is processed once.
+// Nothing is persisted when fn returns error or changes nothing.
+// fn must read state through tx, not through outbox.
+func (o *Outbox) Update(key string, fn func(tx *Tx) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.keys[key]; ok {
+		log.Printf("[outbox] %s is already processed, skipping\n", key)
+		return nil
+	}
+
+	tx := o.begin(key)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.entry.State) == 0 && len(tx.entry.Messages) == 0 {
+		return nil
+	}
+	return o.commit(tx.entry)
+}
+
+// Do will run fn like Update, and persist reply filled by fn together
+// with its changes, even when fn changes nothing. Do whose key has
+// already been persisted decodes the persisted reply into reply instead
+// of running fn, so request sent more than once gets the same reply.
+// Do with empty key is always run.
+func (o *Outbox) Do(key string, reply interface{}, fn func(tx *Tx) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if data, ok := o.replies[key]; ok && key != "" {
+		log.Printf("[outbox] %s is already processed, replaying reply\n", key)
+		return json.Unmarshal(data, reply)
+	}
+
+	tx := o.begin(key)
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	tx.entry.Reply = data
+	return o.commit(tx.entry)
+}
+
+// RequestKey returns key of Do serving request with given idempotency key
+// made to given operation. Request without idempotency key gets empty key,
+// so it is always run.
+func RequestKey(operation, idempotencyKey string) string {
+	if idempotencyKey == "" {
+		return ""
+	}
+	return operation + "/" + idempotencyKey
+}
+
+func (o *Outbox) begin(key string) *Tx {
+	return &Tx{
+		outbox: o,
+		entry: entry{
+			Key:   key,
+			State: make(map[string]json.RawMessage),
+		},
+	}
+}
+
+// commit will persist entry, then compact outbox once enough entries are
+// committed since the latest snapshot. Entry is committed even when
+// compaction fails, since it is already persisted.
+func (o *Outbox) commit(e entry) error {
+	e.Time = time.Now()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err = o.storage.AppendLog(entryLogID, string(data)); err != nil {
+		return err
+	}
+
+	o.apply(e)
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+
+	o.entries++
+	if o.entries >= o.compactEvery {
+		if err = o.compact(); err != nil {
+			log.Printf("[outbox] failed to compact outbox, will retry : %s\n", err.Error())
+		}
+	}
+	return nil
+}
+
+// compact will save whole outbox into a snapshot, then remove entries and
+// delivered message IDs it covers. Key older than keyRetention is dropped
+// together with its reply. When removal fails halfway, the remaining
+// entries are applied on top of the snapshot again, which changes nothing.
+func (o *Outbox) compact() error {
+	now := time.Now()
+	for key, t := range o.keys {
+		if now.Sub(t) > o.keyRetention {
+			delete(o.keys, key)
+			delete(o.replies, key)
+		}
+	}
+
+	data, err := json.Marshal(snapshot{
+		Seq:     o.seq + 1,
+		State:   o.state,
+		Keys:    o.keys,
+		Replies: o.replies,
+		Pending: o.pending,
+	})
+	if err != nil {
+		return err
+	}
+
+	logID := snapshotLogID((o.seq + 1) % 2)
+	if err = o.storage.Cleanup(logID); err != nil {
+		return err
+	}
+	if err = o.storage.AppendLog(logID, string(data)); err != nil {
+		return err
+	}
+	o.seq++
+
+	if err = o.storage.Cleanup(entryLogID); err != nil {
+		return err
+	}
+	o.entries = 0
+	if err = o.storage.Cleanup(deliveredLogID); err != nil {
+		return err
+	}
+	o.delivered = make(map[string]bool)
+	return nil
+}
+
+// lastSnapshot returns snapshot saved last into either of snapshot logs
+func (o *Outbox) lastSnapshot() snapshot {
+	var last snapshot
+	for slot := 0; slot < 2; slot++ {
+		data, err := o.storage.LastLog(snapshotLogID(slot))
+		if err != nil {
+			continue
+		}
+
+		var snap snapshot
+		if err = json.Unmarshal([]byte(data), &snap); err == nil && snap.Seq > last.Seq {
+			last = snap
+		}
+	}
+	return last
+}
+
+// Get will decode state stored under given key into v. It returns false
+// when there is no such state.
+func (o *Outbox) Get(key string, v interface{}) (bool, error) {
+	o.mu.Lock()
+	data, ok := o.state[key]
+	o.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Relay will deliver every undelivered message through publisher until
+// ctx is done. Message is marked as delivered only after publisher
+// succeeds, so every message is delivered at least once. Failed delivery
+// is retried every interval.
+func (o *Outbox) Relay(ctx context.Context, publisher Publisher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, m := range o.undelivered() {
+			if err := publisher.Send(m.ID, m.Type, m.SagaID, m.Data); err != nil {
+				log.Printf("[outbox] failed to deliver %s message %s, will retry : %s\n", m.Type, m.ID, err.Error())
+				break
+			}
+			o.markDelivered(m.ID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *Outbox) undelivered() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	messages := make([]Message, len(o.pending))
+	copy(messages, o.pending)
+	return messages
+}
+
+func (o *Outbox) markDelivered(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.storage.AppendLog(deliveredLogID, id); err != nil {
+		log.Printf("[outbox] failed to mark message %s as delivered : %s\n", id, err.Error())
+	}
+
+	o.delivered[id] = true
+	for i, m := range o.pending {
+		if m.ID == id {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			break
+		}
+	}
+}
+
+// apply will update outbox with persisted entry
+func (o *Outbox) apply(e entry) {
+	if e.Key != "" {
+		o.keys[e.Key] = e.Time
+		if e.Reply != nil {
+			o.replies[e.Key] = e.Reply
+		}
+	}
+	for key, value := range e.State {
+		o.state[key] = value
+	}
+	o.queue(e.Messages)
+}
+
+// queue will add messages which are neither delivered nor pending yet to
+// pending messages
+func (o *Outbox) queue(messages []Message) {
+	for _, m := range messages {
+		if o.delivered[m.ID] || o.isPending(m.ID) {
+			continue
+		}
+		o.pending = append(o.pending, m)
+	}
+}
+
+func (o *Outbox) isPending(id string) bool {
+	for _, m := range o.pending {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func snapshotLogID(slot int) string {
+	return snapshotLogPrefix + strconv.Itoa(slot)
+}
+
+// Get will decode state stored under given key into v, including change
+// made within this transaction. It returns false when there is no such state.
+func (tx *Tx) Get(key string, v interface{}) (bool, error) {
+	data, ok := tx.entry.State[key]
+	if !ok {
+		data, ok = tx.outbox.state[key]
+	}
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Set will store v under given key once transaction is committed
+func (tx *Tx) Set(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tx.entry.State[key] = data
+	return nil
+}
+
+// Sequence will increment counter stored under given key and return its
+// new value. Counter starts from first, and is committed with transaction,
+// so input processed once gets a single ID.
+func (tx *Tx) Sequence(key string, first int) (int, error) {
+	last := first - 1
+	if _, err := tx.Get(key, &last); err != nil {
+		return 0, err
+	}
+
+	last++
+	return last, tx.Set(key, last)
+}
+
+// Publish will queue message to be sent once transaction is committed
+func (tx *Tx) Publish(messageType, sagaID string, data interface{}) error {
+	payloadBytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	id, err := uid.New()
+	if err != nil {
+		return err
+	}
+
+	tx.entry.Messages = append(tx.entry.Messages, Message{
+		ID:     id,
+		Type:   messageType,
+		SagaID: sagaID,
+		Data:   payloadBytes,
+	})
+	return nil
+}
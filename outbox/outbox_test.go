@@ -0,0 +1,490 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/storage"
+)
+
+// publisher records delivered messages, failing while it is down
+type publisher struct {
+	mu   sync.Mutex
+	down bool
+	ids  []string
+}
+
+func (p *publisher) Send(id, messageType, sagaID string, data json.RawMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.down {
+		return errors.New("publisher is down")
+	}
+	p.ids = append(p.ids, id)
+	return nil
+}
+
+func (p *publisher) setDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.down = down
+}
+
+func (p *publisher) delivered() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.ids...)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestUpdateDedup(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name string
+		// keys lists key of every update, counter is incremented and a
+		// message published by each of them
+		keys []string
+		// fail lists keys whose update fails
+		fail        map[string]bool
+		wantCalls   int
+		wantCounter int
+		wantPending int
+	}{
+		{
+			name:        "distinct keys",
+			keys:        []string{"e1", "e2", "e3"},
+			wantCalls:   3,
+			wantCounter: 3,
+			wantPending: 3,
+		},
+		{
+			name:        "duplicate key",
+			keys:        []string{"e1", "e1", "e2", "e1"},
+			wantCalls:   2,
+			wantCounter: 2,
+			wantPending: 2,
+		},
+		{
+			name:        "failed update persists nothing",
+			keys:        []string{"e1", "e2"},
+			fail:        map[string]bool{"e2": true},
+			wantCalls:   2,
+			wantCounter: 1,
+			wantPending: 1,
+		},
+		{
+			name:        "failed update is processed again",
+			keys:        []string{"e1", "e1"},
+			fail:        map[string]bool{"e1": true},
+			wantCalls:   2,
+			wantCounter: 0,
+			wantPending: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			s, err := storage.NewFileStorage(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			o, err := Open(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			calls := 0
+			for _, key := range tt.keys {
+				key := key
+				err = o.Update(key, func(tx *Tx) error {
+					calls++
+					var counter int
+					if _, err := tx.Get("counter", &counter); err != nil {
+						return err
+					}
+					tx.Set("counter", counter+1)
+					tx.Publish("Incremented", key, counter+1)
+					if tt.fail[key] {
+						return errFailed
+					}
+					return nil
+				})
+				if err != nil && err != errFailed {
+					t.Fatal(err)
+				}
+			}
+			o.Close()
+
+			if calls != tt.wantCalls {
+				t.Errorf("update ran %d times, want %d", calls, tt.wantCalls)
+			}
+
+			// state and messages survive restart
+			s, err = storage.NewFileStorage(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reopened, err := Open(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+
+			var counter int
+			if _, err = reopened.Get("counter", &counter); err != nil {
+				t.Fatal(err)
+			}
+			if counter != tt.wantCounter {
+				t.Errorf("counter = %d, want %d", counter, tt.wantCounter)
+			}
+			if pending := len(reopened.undelivered()); pending != tt.wantPending {
+				t.Errorf("pending messages = %d, want %d", pending, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestRelay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s, err := storage.NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := Open(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"e1", "e2"} {
+		if err = o.Update(key, func(tx *Tx) error { return tx.Publish("Created", key, key) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := o.undelivered()
+
+	// messages wait while publisher is down, and are delivered once in order
+	p := &publisher{down: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Relay(ctx, p, 5*time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if len(p.delivered()) != 0 {
+		t.Fatal("message delivered while publisher is down")
+	}
+	p.setDown(false)
+
+	deadline := time.Now().Add(time.Second)
+	for len(p.delivered()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	o.Close()
+
+	delivered := p.delivered()
+	if len(delivered) != len(want) || delivered[0] != want[0].ID || delivered[1] != want[1].ID {
+		t.Fatalf("delivered = %v, want %v then %v once", delivered, want[0].ID, want[1].ID)
+	}
+
+	// delivered messages are not sent again after restart
+	s, err = storage.NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if pending := reopened.undelivered(); len(pending) != 0 {
+		t.Fatalf("pending after restart = %v, want none", pending)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	next := func(o *Outbox, key string) int {
+		var id int
+		err := o.Update(key, func(tx *Tx) error {
+			var err error
+			id, err = tx.Sequence("last-id", 10)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	s, err := storage.NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := Open(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, second := next(o, "e1"), next(o, "e2"); first != 10 || second != 11 {
+		t.Fatalf("ids = %d, %d, want 10, 11", first, second)
+	}
+	o.Close()
+
+	s, err = storage.NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	// duplicate input gets no ID, and counter goes on after restart
+	if id := next(reopened, "e2"); id != 0 {
+		t.Fatalf("duplicate input got id %d", id)
+	}
+	if id := next(reopened, "e3"); id != 12 {
+		t.Fatalf("id after restart = %d, want 12", id)
+	}
+}
+
+func TestDo(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name string
+		// keys lists key of every request, each of them takes the next ID
+		// and replies with it
+		keys []string
+		// fail lists keys whose request fails
+		fail        map[string]bool
+		wantReplies []int
+		wantLastID  int
+	}{
+		{
+			name:        "distinct keys",
+			keys:        []string{"r1", "r2"},
+			wantReplies: []int{1, 2},
+			wantLastID:  2,
+		},
+		{
+			name:        "duplicate key replays reply",
+			keys:        []string{"r1", "r2", "r1"},
+			wantReplies: []int{1, 2, 1},
+			wantLastID:  2,
+		},
+		{
+			name:        "empty key is always run",
+			keys:        []string{"", ""},
+			wantReplies: []int{1, 2},
+			wantLastID:  2,
+		},
+		{
+			name:        "failed request is run again",
+			keys:        []string{"r1", "r1"},
+			fail:        map[string]bool{"r1": true},
+			wantReplies: []int{0, 0},
+			wantLastID:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			o, err := OpenDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			do := func(o *Outbox, key string) int {
+				var reply int
+				err := o.Do(key, &reply, func(tx *Tx) error {
+					id, err := tx.Sequence("last-id", 1)
+					if err != nil {
+						return err
+					}
+					if tt.fail[key] {
+						return errFailed
+					}
+					reply = id
+					return nil
+				})
+				if err != nil && err != errFailed {
+					t.Fatal(err)
+				}
+				return reply
+			}
+
+			for i, key := range tt.keys {
+				if reply := do(o, key); reply != tt.wantReplies[i] {
+					t.Errorf("reply of request %d = %d, want %d", i, reply, tt.wantReplies[i])
+				}
+			}
+			o.Close()
+
+			// replies survive restart
+			reopened, err := OpenDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+
+			var lastID int
+			if _, err = reopened.Get("last-id", &lastID); err != nil {
+				t.Fatal(err)
+			}
+			if lastID != tt.wantLastID {
+				t.Errorf("last ID = %d, want %d", lastID, tt.wantLastID)
+			}
+			for i, key := range tt.keys {
+				if key == "" || tt.fail[key] {
+					continue
+				}
+				if reply := do(reopened, key); reply != tt.wantReplies[i] {
+					t.Errorf("reply of request %d after restart = %d, want %d", i, reply, tt.wantReplies[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention time.Duration
+		// leftover appends entries and delivered IDs covered by snapshot
+		// again, as if outbox crashed before removing them
+		leftover    bool
+		wantRerun   bool
+		wantPending int
+	}{
+		{
+			name:        "keys within retention are kept",
+			retention:   time.Hour,
+			wantRerun:   false,
+			wantPending: 3,
+		},
+		{
+			name:        "expired keys are dropped",
+			retention:   0,
+			wantRerun:   true,
+			wantPending: 3,
+		},
+		{
+			name:        "entries left after snapshot change nothing",
+			retention:   time.Hour,
+			leftover:    true,
+			wantRerun:   false,
+			wantPending: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			s, err := storage.NewFileStorage(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			o, err := Open(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			o.compactEvery = 3
+			o.keyRetention = tt.retention
+
+			update := func(o *Outbox, key string) bool {
+				ran := false
+				err := o.Update(key, func(tx *Tx) error {
+					ran = true
+					tx.Set(key, key)
+					return tx.Publish("Created", key, key)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ran
+			}
+
+			update(o, "e1")
+			update(o, "e2")
+			o.markDelivered(o.undelivered()[0].ID)
+			entries, _ := s.Lookup(entryLogID)
+			delivered, _ := s.Lookup(deliveredLogID)
+
+			// the third entry compacts outbox
+			update(o, "e3")
+			if left, _ := s.Lookup(entryLogID); len(left) != 0 {
+				t.Errorf("entries after compaction = %d, want none", len(left))
+			}
+			if left, _ := s.Lookup(deliveredLogID); len(left) != 0 {
+				t.Errorf("delivered IDs after compaction = %d, want none", len(left))
+			}
+			if tt.leftover {
+				for _, data := range entries {
+					s.AppendLog(entryLogID, data)
+				}
+				for _, id := range delivered {
+					s.AppendLog(deliveredLogID, id)
+				}
+			}
+			update(o, "e4")
+			o.Close()
+
+			// state, undelivered messages and kept keys survive restart
+			s, err = storage.NewFileStorage(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reopened, err := Open(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+
+			for _, key := range []string{"e1", "e2", "e3", "e4"} {
+				var value string
+				if found, err := reopened.Get(key, &value); err != nil || !found || value != key {
+					t.Errorf("state %s = %q, %v, want %q", key, value, err, key)
+				}
+			}
+			if pending := len(reopened.undelivered()); pending != tt.wantPending {
+				t.Errorf("pending messages = %d, want %d", pending, tt.wantPending)
+			}
+			if ran := update(reopened, "e4"); ran {
+				t.Error("key committed after snapshot is processed again")
+			}
+			if ran := update(reopened, "e1"); ran != tt.wantRerun {
+				t.Errorf("key committed before snapshot is processed again = %v, want %v", ran, tt.wantRerun)
+			}
+		})
+	}
+}
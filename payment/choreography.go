@@ -0,0 +1,72 @@
+package payment
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/eventbus"
+	"github.com/cikupin/saga-simple-example/events"
+	"github.com/cikupin/saga-simple-example/outbox"
+)
+
+const (
+	// StatusPaid means order has been paid
+	StatusPaid = "PAID"
+
+	// lastIDKey is store key of the latest payment ID
+	lastIDKey = "payment-last-id"
+)
+
+// Payment defines payment made within a saga, kept in payment service store
+type Payment struct {
+	PaymentID     int    `json:"payment_id"`
+	OrderID       int    `json:"order_id"`
+	Price         int    `json:"price"`
+	PaymentMethod string `json:"payment_method"`
+	Status        string `json:"status"`
+}
+
+// SubscribeEvents will make payment service take part in choreography saga.
+// It pays order once order is created. Payment and event telling about it
+// are committed together into store, which relays the event.
+func SubscribeEvents(bus *eventbus.Bus, store *outbox.Outbox) {
+	bus.Subscribe("payment", func(e eventbus.Event) error {
+		if e.Type != events.OrderCreated {
+			return nil
+		}
+
+		var purchase events.Purchase
+		if err := json.Unmarshal(e.Data, &purchase); err != nil {
+			return err
+		}
+
+		time.Sleep(purchase.Faults.Action(events.StepPayment).Latency)
+
+		return store.Update(e.ID, func(tx *outbox.Tx) error {
+			if purchase.Faults.Action(events.StepPayment).Fail {
+				log.Printf("$%d payment for order_id %d with payment method %s : FAILED!!! (injected fault)\n", purchase.Price, purchase.OrderID, purchase.PaymentMethod)
+				return tx.Publish(events.PaymentFailed, e.SagaID, purchase)
+			}
+
+			paymentID, err := tx.Sequence(lastIDKey, firstID)
+			if err != nil {
+				return err
+			}
+			purchase.PaymentID = paymentID
+			err = tx.Set("payment/"+e.SagaID, Payment{
+				PaymentID:     purchase.PaymentID,
+				OrderID:       purchase.OrderID,
+				Price:         purchase.Price,
+				PaymentMethod: purchase.PaymentMethod,
+				Status:        StatusPaid,
+			})
+			if err != nil {
+				return err
+			}
+
+			log.Printf("[payment ID %d] $%d payment for order_id %d with payment method %s : success\n", purchase.PaymentID, purchase.Price, purchase.OrderID, purchase.PaymentMethod)
+			return tx.Publish(events.PaymentCompleted, e.SagaID, purchase)
+		})
+	})
+}
@@ -0,0 +1,52 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cikupin/saga-simple-example/broker"
+	"github.com/cikupin/saga-simple-example/config"
+	"github.com/cikupin/saga-simple-example/httpclient"
+	"github.com/cikupin/saga-simple-example/proto/paymentpb"
+	"github.com/cikupin/saga-simple-example/transport"
+	"google.golang.org/grpc"
+)
+
+// Channel is the message broker channel of payment service commands, named
+// after its config section as msg:// URL of orchestrator config must be
+const Channel = config.SectionPayment
+
+// Client calls payment service
+type Client struct {
+	client transport.Transport
+}
+
+// NewClient will create payment service client with given base URL
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	return &Client{
+		client: httpclient.New("payment", baseURL, httpClient),
+	}
+}
+
+// NewGRPCClient will create payment service client calling PaymentService of
+// its gRPC server through conn
+func NewGRPCClient(conn *grpc.ClientConn) *Client {
+	return &Client{
+		client: grpcClient{rpc: paymentpb.NewPaymentServiceClient(conn)},
+	}
+}
+
+// NewBrokerClient will create payment service client sending commands into
+// given message broker channel
+func NewBrokerClient(b broker.Broker, channel string) *Client {
+	return &Client{
+		client: broker.NewClient(b, channel, channel+"-replies"),
+	}
+}
+
+// Pay will pay order in request
+func (c *Client) Pay(ctx context.Context, req Request) (Response, error) {
+	var resp Response
+	err := c.client.Post(ctx, "/payment-paid", req, &resp)
+	return resp, err
+}
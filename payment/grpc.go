@@ -0,0 +1,73 @@
+package payment
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/cikupin/saga-simple-example/grpctransport"
+	"github.com/cikupin/saga-simple-example/idempotency"
+	"github.com/cikupin/saga-simple-example/proto/paymentpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type (
+	// grpcServer serves PaymentService of proto/payment.proto
+	grpcServer struct {
+		paymentpb.UnimplementedPaymentServiceServer
+	}
+
+	// grpcClient calls payment service endpoints through PaymentService
+	// client
+	grpcClient struct {
+		rpc paymentpb.PaymentServiceClient
+	}
+)
+
+// Pay will pay order in request
+func (grpcServer) Pay(ctx context.Context, req *paymentpb.Request) (*paymentpb.Response, error) {
+	paid, err := payments.pay(grpctransport.IncomingHeader(ctx, idempotency.HeaderKey), Request{
+		PaymentMethod: req.PaymentMethod,
+		Price:         int(req.Price),
+		OrderID:       int(req.OrderId),
+	})
+	if err != nil {
+		log.Printf("$%d payment for order_id %d with payment method %s : %s\n", req.Price, req.OrderId, req.PaymentMethod, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("[payment ID %d] $%d payment for order_id %d with payment method %s : success\n", paid.PaymentID, req.Price, req.OrderId, req.PaymentMethod)
+	return &paymentpb.Response{
+		PaymentId: int64(paid.PaymentID),
+		Success:   true,
+	}, nil
+}
+
+// Post will call RPC method serving the same operation as given endpoint
+func (c grpcClient) Post(ctx context.Context, path string, in, out interface{}) error {
+	if path != "/payment-paid" {
+		return grpctransport.NotServed("payment", http.MethodPost, path)
+	}
+
+	req := in.(Request)
+	reply, err := c.rpc.Pay(ctx, &paymentpb.Request{
+		PaymentMethod: req.PaymentMethod,
+		Price:         int64(req.Price),
+		OrderId:       int64(req.OrderID),
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp, ok := out.(*Response); ok {
+		resp.PaymentID = int(reply.PaymentId)
+		resp.Success = reply.Success
+	}
+	return nil
+}
+
+// Get is not served through gRPC
+func (c grpcClient) Get(ctx context.Context, path string, out interface{}) error {
+	return grpctransport.NotServed("payment", http.MethodGet, path)
+}
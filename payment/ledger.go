@@ -0,0 +1,43 @@
+package payment
+
+import (
+	"strconv"
+
+	"github.com/cikupin/saga-simple-example/outbox"
+)
+
+// ledger defines payments of payment service, kept in its store together
+// with reply of every request making them
+type ledger struct {
+	store *outbox.Outbox
+}
+
+func newLedger(store *outbox.Outbox) *ledger {
+	return &ledger{store: store}
+}
+
+// pay will record payment of given request. Request with the same key
+// gets the same payment, even when it is sent again after a crash.
+func (l *ledger) pay(key string, req Request) (Payment, error) {
+	var p Payment
+	err := l.store.Do(outbox.RequestKey("pay", key), &p, func(tx *outbox.Tx) error {
+		paymentID, err := tx.Sequence(lastIDKey, firstID)
+		if err != nil {
+			return err
+		}
+
+		p = Payment{
+			PaymentID:     paymentID,
+			OrderID:       req.OrderID,
+			Price:         req.Price,
+			PaymentMethod: req.PaymentMethod,
+			Status:        StatusPaid,
+		}
+		return tx.Set(paymentKey(paymentID), p)
+	})
+	return p, err
+}
+
+func paymentKey(paymentID int) string {
+	return "payments/" + strconv.Itoa(paymentID)
+}
@@ -0,0 +1,73 @@
+package payment
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cikupin/saga-simple-example/outbox"
+)
+
+func TestLedger(t *testing.T) {
+	tests := []struct {
+		name string
+		// keys lists idempotency key of every payment
+		keys []string
+		// restartAt reopens ledger from its store before payment with this
+		// index
+		restartAt int
+		wantIDs   []int
+	}{
+		{
+			name:    "distinct keys",
+			keys:    []string{"s1/payment", "s2/payment"},
+			wantIDs: []int{10, 11},
+		},
+		{
+			name:      "duplicate key after restart",
+			keys:      []string{"s1/payment", "s2/payment", "s1/payment", "s3/payment"},
+			restartAt: 2,
+			wantIDs:   []int{10, 11, 10, 12},
+		},
+		{
+			name:    "payment without key",
+			keys:    []string{"", ""},
+			wantIDs: []int{10, 11},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "payment")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			store, err := outbox.OpenDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := newLedger(store)
+
+			for i, key := range tt.keys {
+				if tt.restartAt > 0 && i == tt.restartAt {
+					store.Close()
+					if store, err = outbox.OpenDir(dir); err != nil {
+						t.Fatal(err)
+					}
+					l = newLedger(store)
+				}
+
+				p, err := l.pay(key, Request{PaymentMethod: "card", Price: 10, OrderID: 32})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.PaymentID != tt.wantIDs[i] || p.Status != StatusPaid {
+					t.Errorf("payment %d = %d %s, want %d %s", i, p.PaymentID, p.Status, tt.wantIDs[i], StatusPaid)
+				}
+			}
+			store.Close()
+		})
+	}
+}
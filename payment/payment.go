@@ -4,13 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
 
+	"github.com/cikupin/saga-simple-example/broker"
+	"github.com/cikupin/saga-simple-example/chaos"
+	"github.com/cikupin/saga-simple-example/config"
+	"github.com/cikupin/saga-simple-example/fault"
+	"github.com/cikupin/saga-simple-example/grpctransport"
+	"github.com/cikupin/saga-simple-example/idempotency"
+	"github.com/cikupin/saga-simple-example/outbox"
+	"github.com/cikupin/saga-simple-example/proto/paymentpb"
 	"github.com/gorilla/mux"
 	"github.com/urfave/cli"
+	"google.golang.org/grpc"
 )
 
 type (
@@ -28,35 +38,88 @@ type (
 	}
 )
 
+// firstID is ID of the first payment
+const firstID = 10
+
+// payments holds payments made through payment service
+var payments *ledger
+
 // Serve will serve payment service
 var Serve = cli.Command{
 	Name:        "payment",
 	Usage:       "Run payment service",
 	Description: "Execute this command to start payment service",
 	Action:      startPaymentService,
+	Flags:       config.Flags(config.SectionPayment),
 }
 
 // startPaymentService wil start payment service
 func startPaymentService(c *cli.Context) {
-	r := mux.NewRouter()
-	r.HandleFunc("/payment-success", paymentSucess).Methods(http.MethodPost)
-	r.HandleFunc("/payment-failed", paymentFailed).Methods(http.MethodPost)
-
-	srv := &http.Server{
-		Addr:         ":8003",
-		WriteTimeout: time.Second * 3,
-		ReadTimeout:  time.Second * 3,
-		IdleTimeout:  time.Second * 10,
-		Handler:      r,
+	cfg, err := config.FromContext(c, config.SectionPayment)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	store, err := idempotency.Open(cfg.Payment.IdempotencyDir)
+	if err != nil {
+		log.Fatalln(err.Error())
 	}
 
+	state, err := outbox.OpenDir(cfg.Payment.StoreDir)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	payments = newLedger(state)
+
+	monkey, err := chaos.New(cfg.Payment.Chaos.Config())
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if monkey.Config().Enabled() {
+		log.Printf("chaos mode is enabled : %+v\n", monkey.Config())
+	}
+
+	r := mux.NewRouter()
+	r.Use(monkey.Middleware)
+	r.Use(fault.Middleware)
+	r.Use(store.Middleware)
+	r.Handle(chaos.AdminPath, monkey).Methods(http.MethodGet, http.MethodPut)
+	r.HandleFunc("/payment-paid", pay).Methods(http.MethodPost)
+
+	srv := cfg.Payment.Server.HTTPServer(r)
 	go func() {
-		log.Println("payment service is running on port 8003")
+		log.Printf("payment service is running on %s\n", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil {
 			log.Println(err)
 		}
 	}()
 
+	var grpcSrv *grpc.Server
+	if addr := cfg.Payment.GRPCAddr; addr != "" {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		grpcSrv = grpctransport.NewServer(monkey.UnaryInterceptor, fault.UnaryInterceptor, store.UnaryInterceptor)
+		paymentpb.RegisterPaymentServiceServer(grpcSrv, grpcServer{})
+		go func() {
+			log.Printf("payment gRPC service is running on %s\n", addr)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	msgBroker, err := broker.FromConfig(cfg.Payment.Broker)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if msgBroker != nil {
+		broker.Serve(msgBroker, Channel, r)
+		log.Printf("payment service is receiving commands from %s message broker channel %s\n", cfg.Payment.Broker.Engine, Channel)
+	}
+
 	chanSignal := make(chan os.Signal, 1)
 	signal.Notify(chanSignal, os.Interrupt)
 	<-chanSignal
@@ -66,39 +129,41 @@ func startPaymentService(c *cli.Context) {
 	defer cancel()
 
 	srv.Shutdown(ctx)
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+	if msgBroker != nil {
+		msgBroker.Close()
+	}
+	store.Close()
+	state.Close()
 	log.Println("shutting down")
 	os.Exit(0)
 }
 
-// paymentSucess defines payment success logic
-func paymentSucess(w http.ResponseWriter, r *http.Request) {
+// pay defines payment logic
+func pay(w http.ResponseWriter, r *http.Request) {
 	var payload Request
 	json.NewDecoder(r.Body).Decode(&payload)
 
-	log.Printf("[payment ID 10] $%d payment for order_id %d with payment method %s : success\n", payload.Price, payload.OrderID, payload.PaymentMethod)
+	paid, err := payments.pay(r.Header.Get(idempotency.HeaderKey), payload)
+	if err != nil {
+		log.Printf("$%d payment for order_id %d with payment method %s : %s\n", payload.Price, payload.OrderID, payload.PaymentMethod, err.Error())
 
-	resp := Response{
-		PaymentID: 10,
-		Success:   true,
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{Success: false})
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(resp)
-}
-
-// defines payment failed logic
-func paymentFailed(w http.ResponseWriter, r *http.Request) {
-	var payload Request
-	json.NewDecoder(r.Body).Decode(&payload)
-
-	log.Printf("$%d payment for order_id %d with payment method %s : FAILED!!!\n", payload.Price, payload.OrderID, payload.PaymentMethod)
+	log.Printf("[payment ID %d] $%d payment for order_id %d with payment method %s : success\n", paid.PaymentID, payload.Price, payload.OrderID, payload.PaymentMethod)
 
 	resp := Response{
-		Success: false,
+		PaymentID: paid.PaymentID,
+		Success:   true,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(resp)
 }
@@ -0,0 +1,35 @@
+package saga
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext keeps values of its parent context, such as headers
+// sent to participant services, but neither its cancellation nor its deadline
+type detachedContext struct {
+	parent context.Context
+}
+
+// detach returns context which is never cancelled, carrying values of ctx.
+// Compensation runs on it, since it must still run after the caller has
+// gone away.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
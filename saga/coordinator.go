@@ -0,0 +1,525 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cikupin/saga-simple-example/storage"
+	"github.com/cikupin/saga-simple-example/uid"
+)
+
+// logPrefix is prepended to saga ID to build its storage logID
+const logPrefix = "saga_"
+
+var (
+	// ErrSagaNotFound is returned when saga has no record in saga log
+	ErrSagaNotFound = errors.New("saga not found")
+	// ErrStepTimeout is returned when sub-transaction or compensation exceeds
+	// its deadline. The call may still take effect in participant service.
+	ErrStepTimeout = errors.New("timed out, outcome unknown")
+)
+
+// Coordinator executes sagas registered in registry and records every
+// step into saga log storage.
+type Coordinator struct {
+	registry *Registry
+	storage  storage.Storage
+
+	mu        sync.Mutex
+	running   map[string]struct{}
+	listeners []Listener
+	jobs      chan job
+}
+
+// Listener is called after every saga log record is written
+type Listener func(l Log)
+
+// NewCoordinator will create new saga execution coordinator
+func NewCoordinator(registry *Registry, logStorage storage.Storage) *Coordinator {
+	return &Coordinator{
+		registry: registry,
+		storage:  logStorage,
+		running:  make(map[string]struct{}),
+	}
+}
+
+// Execute will run saga of given type until it is finished or compensated
+func (c *Coordinator) Execute(ctx context.Context, sagaType string, input interface{}) (*Execution, error) {
+	return c.ExecuteFunc(ctx, sagaType, input, nil)
+}
+
+// ExecuteFunc will run saga like Execute. started, if set, is called once
+// saga start is recorded and before any sub-transaction is executed, so
+// caller can learn saga ID while saga is still running.
+func (c *Coordinator) ExecuteFunc(ctx context.Context, sagaType string, input interface{}, started func(exec *Execution)) (*Execution, error) {
+	def, exec, err := c.start(sagaType, input, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(exec.ID)
+
+	if started != nil {
+		started(exec)
+	}
+	c.run(ctx, def, exec)
+	return exec, nil
+}
+
+// start will record saga start and mark it as running in this process.
+// Child saga is recorded in its parent saga before it is started, so
+// parent saga can always find and compensate it.
+func (c *Coordinator) start(sagaType string, input interface{}, parent *Execution, parentSubTxID string) (*Definition, *Execution, error) {
+	def, err := c.registry.Get(sagaType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id, err := uid.New()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exec := newExecution(id, sagaType)
+	c.claim(id)
+
+	start := Log{Type: SagaStart, SagaType: sagaType, Data: data}
+	if parent != nil {
+		start.ParentID = parent.ID
+		start.SubTxID = parentSubTxID
+		err = c.write(parent, Log{Type: SubSagaStart, SubTxID: parentSubTxID, ChildID: id})
+	}
+	if err == nil {
+		err = c.write(exec, start)
+	}
+	if err != nil {
+		c.release(id)
+		return nil, nil, err
+	}
+	return def, exec, nil
+}
+
+// Recover will finish every saga in saga log which has no end record.
+// Sub-transaction interrupted while running is resolved first, then saga
+// is resumed forward, or compensated if it was aborted or any of its
+// sub-transactions has failed before its pivot. Child saga is left to its parent saga,
+// which resumes or compensates it.
+func (c *Coordinator) Recover(ctx context.Context) error {
+	logIDs, err := c.storage.LogIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, logID := range logIDs {
+		if !strings.HasPrefix(logID, logPrefix) {
+			continue
+		}
+
+		id := strings.TrimPrefix(logID, logPrefix)
+		if !c.claim(id) {
+			continue
+		}
+		c.recover(ctx, id)
+		c.release(id)
+	}
+	return nil
+}
+
+func (c *Coordinator) recover(ctx context.Context, id string) {
+	exec, err := c.load(id)
+	if err != nil {
+		log.Printf("[recovery] failed to load saga %s : %s\n", id, err.Error())
+		return
+	}
+	if exec.ended || exec.parentID != "" {
+		return
+	}
+
+	def, err := c.registry.Get(exec.Type)
+	if err != nil {
+		log.Printf("[recovery] failed to recover saga %s : %s\n", id, err.Error())
+		return
+	}
+
+	c.resolve(ctx, def, exec)
+	failed := len(exec.failures(def)) > 0
+	switch {
+	case exec.aborted || (failed && !def.passedPivot(exec)):
+		log.Printf("[recovery] compensating saga %s\n", id)
+		c.abort(ctx, def, exec)
+		c.settle(def, exec)
+	case failed:
+		log.Printf("[recovery] saga %s has failed after its pivot, leaving it to be retried\n", id)
+		c.settle(def, exec)
+	default:
+		log.Printf("[recovery] resuming saga %s\n", id)
+		c.run(ctx, def, exec)
+	}
+}
+
+// resolve will execute again every sub-transaction which was running when
+// saga was interrupted, so its outcome is recorded before saga goes on.
+// Action is called with the same saga and sub-transaction, so participant
+// service answers with the outcome of the interrupted call instead of
+// executing it twice. Interrupted child saga is compensated instead, and
+// its sub-transaction is recorded as failed.
+func (c *Coordinator) resolve(ctx context.Context, def *Definition, exec *Execution) {
+	for _, subTx := range def.SubTxs {
+		if !exec.pending[subTx.ID] {
+			continue
+		}
+
+		log.Printf("[recovery] saga %s : resolving interrupted sub-transaction %s\n", exec.ID, subTx.ID)
+		childID := exec.child(subTx.ID)
+		if subTx.SubSaga == "" || childID == "" {
+			c.execSub(ctx, def, exec, subTx)
+			continue
+		}
+
+		err := c.compensateChild(detach(ctx), childID)
+		if err == nil {
+			err = fmt.Errorf("saga %s : %w", childID, ErrSubSagaAborted)
+		}
+		c.write(exec, Log{Type: ActionFailed, SubTxID: subTx.ID, Error: err.Error()})
+	}
+}
+
+// load will rebuild saga execution from saga log
+func (c *Coordinator) load(id string) (*Execution, error) {
+	logs, err := c.Logs(id)
+	if err != nil {
+		return nil, err
+	}
+	return replay(id, logs), nil
+}
+
+// Logs will return every saga log record of given saga
+func (c *Coordinator) Logs(id string) ([]Log, error) {
+	data, err := c.storage.Lookup(logPrefix + id)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrSagaNotFound
+	}
+	return unmarshalLogs(data)
+}
+
+// run will execute every unfinished sub-transaction. Sub-transaction is
+// started once all its dependencies have succeeded, so independent
+// sub-transactions run concurrently. After a sub-transaction fails, no
+// other sub-transaction is started, and saga is compensated once running
+// sub-transactions are finished, unless it has passed its pivot.
+func (c *Coordinator) run(ctx context.Context, def *Definition, exec *Execution) {
+	type result struct {
+		subTx SubTx
+		err   error
+	}
+
+	results := make(chan result)
+	started := make(map[string]bool)
+	running := 0
+	failed := false
+
+	for {
+		if !failed {
+			for _, subTx := range def.ready(exec, started) {
+				started[subTx.ID] = true
+				running++
+
+				go func(subTx SubTx) {
+					results <- result{subTx: subTx, err: c.execSub(ctx, def, exec, subTx)}
+				}(subTx)
+			}
+		}
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+		if r.err != nil {
+			log.Printf("saga %s : sub-transaction %s failed : %s\n", exec.ID, r.subTx.ID, r.err.Error())
+			failed = true
+		}
+	}
+
+	if failed && !def.passedPivot(exec) {
+		c.abort(ctx, def, exec)
+	}
+	c.settle(def, exec)
+}
+
+// execSub will execute a sub-transaction and record its output.
+// Sub-transaction whose condition is not met is recorded as skipped.
+// Failed attempt is retried according to sub-transaction retry policy.
+func (c *Coordinator) execSub(ctx context.Context, def *Definition, exec *Execution, subTx SubTx) error {
+	if subTx.When != nil {
+		run, err := subTx.When(ctx, exec)
+		if err != nil {
+			c.write(exec, Log{Type: ActionFailed, SubTxID: subTx.ID, Error: err.Error()})
+			return err
+		}
+		if !run {
+			return c.write(exec, Log{Type: ActionSkip, SubTxID: subTx.ID})
+		}
+	}
+
+	if err := c.write(exec, Log{Type: ActionStart, SubTxID: subTx.ID}); err != nil {
+		return err
+	}
+
+	action := subTx.Action
+	if subTx.SubSaga != "" {
+		action = func(ctx context.Context, exec *Execution) (interface{}, error) {
+			return c.execChild(ctx, exec, subTx)
+		}
+	}
+
+	var output interface{}
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		output, err = call(ctx, def.timeout(subTx), action, exec)
+		if err == nil || attempt >= subTx.Retry.MaxAttempts || !subTx.Retry.retryable(err) {
+			break
+		}
+
+		c.write(exec, Log{Type: ActionRetry, SubTxID: subTx.ID, Attempt: attempt, Error: err.Error()})
+		if !sleep(ctx, subTx.Retry.backoff(attempt)) {
+			err = ctx.Err()
+			break
+		}
+	}
+	if err != nil {
+		c.write(exec, Log{Type: ActionFailed, SubTxID: subTx.ID, Attempt: attempt, Error: err.Error()})
+		return err
+	}
+
+	data, err := marshalOutput(output)
+	if err != nil {
+		c.write(exec, Log{Type: ActionFailed, SubTxID: subTx.ID, Attempt: attempt, Error: err.Error()})
+		return err
+	}
+	return c.write(exec, Log{Type: ActionEnd, SubTxID: subTx.ID, Attempt: attempt, Data: data})
+}
+
+// abort will compensate finished sub-transactions in reverse order.
+// Compensation keeps context values but not its cancellation, since
+// compensation must still run after the caller has gone away. Child saga
+// of unfinished sub-transaction is compensated first.
+func (c *Coordinator) abort(ctx context.Context, def *Definition, exec *Execution) {
+	ctx = detach(ctx)
+	if !exec.aborted {
+		c.write(exec, Log{Type: SagaAbort})
+	}
+
+	for _, subTx := range def.SubTxs {
+		childID := exec.child(subTx.ID)
+		if childID == "" || exec.isCompleted(subTx.ID) {
+			continue
+		}
+
+		if err := c.compensateChild(ctx, childID); err != nil {
+			log.Printf("saga %s : compensation of child saga %s failed : %s\n", exec.ID, childID, err.Error())
+		}
+	}
+
+	for _, id := range exec.uncompensated() {
+		subTx, ok := def.subTx(id)
+		if !ok {
+			continue
+		}
+
+		if err := c.compensate(ctx, def, exec, subTx); err != nil {
+			log.Printf("saga %s : compensation %s failed : %s\n", exec.ID, subTx.ID, err.Error())
+		}
+	}
+}
+
+// compensate will execute compensation of a sub-transaction and record its output.
+// Failed attempt is retried according to compensation retry policy.
+func (c *Coordinator) compensate(ctx context.Context, def *Definition, exec *Execution, subTx SubTx) error {
+	if err := c.write(exec, Log{Type: CompensateStart, SubTxID: subTx.ID}); err != nil {
+		return err
+	}
+
+	fn := subTx.Compensate
+	if subTx.SubSaga != "" {
+		fn = func(ctx context.Context, exec *Execution) (interface{}, error) {
+			return nil, c.compensateChild(ctx, exec.child(subTx.ID))
+		}
+	}
+	if fn == nil {
+		return c.write(exec, Log{Type: CompensateEnd, SubTxID: subTx.ID})
+	}
+
+	policy := def.CompensationRetry
+	var output interface{}
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		output, err = call(ctx, def.timeout(subTx), fn, exec)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			break
+		}
+
+		c.write(exec, Log{Type: CompensateRetry, SubTxID: subTx.ID, Attempt: attempt, Error: err.Error()})
+		sleep(ctx, policy.backoff(attempt))
+	}
+
+	if err == nil {
+		var data json.RawMessage
+		if data, err = marshalOutput(output); err == nil {
+			return c.write(exec, Log{Type: CompensateEnd, SubTxID: subTx.ID, Attempt: attempt, Data: data})
+		}
+	}
+
+	c.write(exec, Log{Type: CompensateFailed, SubTxID: subTx.ID, Attempt: attempt, Error: err.Error()})
+	return err
+}
+
+// call will execute fn within given timeout. Error caused by exceeded
+// deadline is reported as ErrStepTimeout.
+func call(ctx context.Context, timeout time.Duration, fn Func, exec *Execution) (interface{}, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	output, err := fn(ctx, exec)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return nil, ErrStepTimeout
+	}
+	return output, err
+}
+
+// settle will finish saga. Aborted saga which still has uncompensated
+// sub-transactions, or saga which has passed its pivot with failed
+// sub-transactions, is moved to dead-letter store, otherwise it leaves
+// dead-letter store if it was there.
+func (c *Coordinator) settle(def *Definition, exec *Execution) {
+	switch {
+	case exec.aborted && len(exec.uncompensated()) > 0:
+		c.deadLetter(exec, exec.uncompensated())
+	case !exec.aborted && len(exec.failures(def)) > 0:
+		c.deadLetter(exec, exec.failures(def))
+	case exec.wasDead:
+		c.removeDeadLetter(exec.ID)
+	}
+	c.end(exec)
+}
+
+func (c *Coordinator) end(exec *Execution) {
+	c.write(exec, Log{Type: SagaEnd})
+}
+
+// write will apply log record into execution and append it into saga log.
+// Record is applied even if append fails, so finished sub-transaction is
+// still compensated on abort.
+func (c *Coordinator) write(exec *Execution, l Log) error {
+	exec.mu.Lock()
+	l.Seq = exec.seq + 1
+	l.SagaID = exec.ID
+	l.Time = time.Now()
+	exec.apply(l)
+
+	data, err := l.marshal()
+	if err == nil {
+		err = c.storage.AppendLog(logPrefix+exec.ID, data)
+		if err != nil {
+			log.Printf("saga %s : failed to write %s log : %s\n", exec.ID, l.Type, err.Error())
+		}
+	}
+	if err != nil {
+		exec.mu.Unlock()
+		return err
+	}
+	exec.unnotified = append(exec.unnotified, l)
+	exec.mu.Unlock()
+
+	c.notifyExecution(exec)
+	return nil
+}
+
+// notifyExecution will pass written records of saga to listeners after
+// its lock is released, so slow listener does not hold up other
+// sub-transactions. Records written meanwhile by other sub-transactions
+// are passed on by the writer which is already notifying, keeping their order.
+func (c *Coordinator) notifyExecution(exec *Execution) {
+	exec.mu.Lock()
+	if exec.notifying {
+		exec.mu.Unlock()
+		return
+	}
+	exec.notifying = true
+
+	for len(exec.unnotified) > 0 {
+		logs := exec.unnotified
+		exec.unnotified = nil
+		exec.mu.Unlock()
+
+		for _, l := range logs {
+			c.notify(l)
+		}
+		exec.mu.Lock()
+	}
+	exec.notifying = false
+	exec.mu.Unlock()
+}
+
+// Subscribe will register listener notified on every saga log record
+func (c *Coordinator) Subscribe(listener Listener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.listeners = append(c.listeners, listener)
+}
+
+func (c *Coordinator) notify(l Log) {
+	c.mu.Lock()
+	listeners := c.listeners
+	c.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(l)
+	}
+}
+
+// claim will mark saga as running in this process. It returns false if
+// saga is already running.
+func (c *Coordinator) claim(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.running[id]; ok {
+		return false
+	}
+	c.running[id] = struct{}{}
+	return true
+}
+
+func (c *Coordinator) release(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.running, id)
+}
+
+func marshalOutput(output interface{}) (json.RawMessage, error) {
+	if output == nil {
+		return nil, nil
+	}
+	return json.Marshal(output)
+}
@@ -0,0 +1,532 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	output := json.RawMessage(`{"id":1}`)
+
+	tests := []struct {
+		name            string
+		logs            []Log
+		wantPending     []string
+		wantFailed      []string
+		wantUncompensed []string
+		wantAborted     bool
+		wantEnded       bool
+		wantDeadLetter  bool
+	}{
+		{
+			name: "interrupted during sub-transaction",
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionStart, SubTxID: "a"},
+				{Type: ActionEnd, SubTxID: "a", Data: output},
+				{Type: ActionStart, SubTxID: "b"},
+			},
+			wantPending:     []string{"b"},
+			wantUncompensed: []string{"a"},
+		},
+		{
+			name: "retried sub-transaction",
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionStart, SubTxID: "a"},
+				{Type: ActionFailed, SubTxID: "a"},
+				{Type: ActionStart, SubTxID: "a"},
+				{Type: ActionEnd, SubTxID: "a", Data: output},
+			},
+			wantUncompensed: []string{"a"},
+		},
+		{
+			name: "skipped sub-transaction",
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionSkip, SubTxID: "a"},
+				{Type: SagaEnd},
+			},
+			wantEnded: true,
+		},
+		{
+			name: "compensated",
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionStart, SubTxID: "a"},
+				{Type: ActionEnd, SubTxID: "a", Data: output},
+				{Type: ActionStart, SubTxID: "b"},
+				{Type: ActionEnd, SubTxID: "b", Data: output},
+				{Type: ActionStart, SubTxID: "c"},
+				{Type: ActionFailed, SubTxID: "c"},
+				{Type: SagaAbort},
+				{Type: CompensateStart, SubTxID: "b"},
+				{Type: CompensateEnd, SubTxID: "b"},
+			},
+			wantFailed:      []string{"c"},
+			wantUncompensed: []string{"a"},
+			wantAborted:     true,
+		},
+		{
+			name: "dead-lettered",
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionStart, SubTxID: "a"},
+				{Type: ActionEnd, SubTxID: "a", Data: output},
+				{Type: ActionStart, SubTxID: "b"},
+				{Type: ActionFailed, SubTxID: "b"},
+				{Type: SagaAbort},
+				{Type: CompensateStart, SubTxID: "a"},
+				{Type: CompensateFailed, SubTxID: "a"},
+				{Type: SagaDeadLetter},
+				{Type: SagaEnd},
+			},
+			wantFailed:      []string{"b"},
+			wantUncompensed: []string{"a"},
+			wantAborted:     true,
+			wantEnded:       true,
+			wantDeadLetter:  true,
+		},
+		{
+			name: "reopened by intervention",
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionStart, SubTxID: "a"},
+				{Type: ActionFailed, SubTxID: "a"},
+				{Type: SagaDeadLetter},
+				{Type: SagaEnd},
+				{Type: SagaIntervention, SubTxID: "a"},
+			},
+			wantFailed: []string{"a"},
+		},
+	}
+
+	def := NewDefinition("buy").
+		AddSubTxDef("a", nil, nil).
+		AddSubTxDef("b", nil, nil).
+		AddSubTxDef("c", nil, nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.logs {
+				tt.logs[i].Seq = i + 1
+			}
+			exec := replay("s1", tt.logs)
+
+			if exec.Type != "buy" || exec.seq != len(tt.logs) {
+				t.Errorf("type = %s, seq = %d, want buy and %d", exec.Type, exec.seq, len(tt.logs))
+			}
+			if pending := keys(exec.pending); !equal(pending, tt.wantPending) {
+				t.Errorf("pending = %v, want %v", pending, tt.wantPending)
+			}
+			if failed := exec.failures(def); !equal(failed, tt.wantFailed) {
+				t.Errorf("failed = %v, want %v", failed, tt.wantFailed)
+			}
+			if uncompensated := exec.uncompensated(); !equal(uncompensated, tt.wantUncompensed) {
+				t.Errorf("uncompensated = %v, want %v", uncompensated, tt.wantUncompensed)
+			}
+			if exec.aborted != tt.wantAborted || exec.ended != tt.wantEnded || exec.deadLetter != tt.wantDeadLetter {
+				t.Errorf("aborted, ended, dead letter = %v, %v, %v, want %v, %v, %v",
+					exec.aborted, exec.ended, exec.deadLetter, tt.wantAborted, tt.wantEnded, tt.wantDeadLetter)
+			}
+			for _, id := range exec.completed {
+				var v map[string]int
+				if err := exec.Output(id, &v); err != nil || v["id"] != 1 {
+					t.Errorf("output of %s = %v, %v", id, v, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunDependencies(t *testing.T) {
+	tests := []struct {
+		name   string
+		subTxs []string
+		deps   map[string][]string
+		// concurrent lists sub-transactions which must be running at the same time
+		concurrent []string
+	}{
+		{
+			name:   "sequence",
+			subTxs: []string{"a", "b", "c"},
+			deps:   map[string][]string{"b": {"a"}, "c": {"b"}},
+		},
+		{
+			name:       "fan out and fan in",
+			subTxs:     []string{"a", "b", "c", "d"},
+			deps:       map[string][]string{"b": {"a"}, "c": {"a"}, "d": {"b", "c"}},
+			concurrent: []string{"b", "c"},
+		},
+		{
+			name:       "independent",
+			subTxs:     []string{"a", "b", "c", "d"},
+			concurrent: []string{"a", "b", "c", "d"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var events []string
+			barrier := newBarrier(len(tt.concurrent))
+
+			step := func(id string) Func {
+				return func(ctx context.Context, exec *Execution) (interface{}, error) {
+					mu.Lock()
+					events = append(events, "start "+id)
+					mu.Unlock()
+
+					if contains(tt.concurrent, id) && !barrier.wait(time.Second) {
+						t.Errorf("%s is not running concurrently with %v", id, tt.concurrent)
+					}
+
+					mu.Lock()
+					events = append(events, "end "+id)
+					mu.Unlock()
+					return nil, nil
+				}
+			}
+
+			def := NewDefinition("dag")
+			for _, id := range tt.subTxs {
+				def.AddSubTxDef(id, step(id), nil).SetDependencies(id, tt.deps[id]...)
+			}
+			c := newTestCoordinator(t, def)
+
+			if _, err := c.Execute(context.Background(), "dag", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			position := make(map[string]int)
+			for i, event := range events {
+				position[event] = i
+			}
+			for _, subTx := range def.SubTxs {
+				if _, ok := position["end "+subTx.ID]; !ok {
+					t.Fatalf("%s is not executed : %v", subTx.ID, events)
+				}
+				for _, dep := range subTx.DependsOn {
+					if position["start "+subTx.ID] < position["end "+dep] {
+						t.Errorf("%s started before its dependency %s ended : %v", subTx.ID, dep, events)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAbortParallel(t *testing.T) {
+	tests := []struct {
+		name string
+		fail string
+		// wantCompensated lists compensations in expected order
+		wantCompensated []string
+	}{
+		{
+			name:            "parallel sub-transaction fails",
+			fail:            "order",
+			wantCompensated: []string{"compensate item"},
+		},
+		{
+			name:            "joining sub-transaction fails",
+			fail:            "pay",
+			wantCompensated: []string{"compensate order", "compensate item"},
+		},
+		{
+			name:            "first sub-transaction fails",
+			fail:            "item",
+			wantCompensated: []string{"compensate order"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRecorder(tt.fail)
+
+			// item finishes before order, so order is compensated first
+			itemDone := make(chan struct{})
+			item := func(ctx context.Context, exec *Execution) (interface{}, error) {
+				defer close(itemDone)
+				return r.fn("item")(ctx, exec)
+			}
+			order := func(ctx context.Context, exec *Execution) (interface{}, error) {
+				<-itemDone
+				return r.fn("order")(ctx, exec)
+			}
+
+			def := NewDefinition("buy").
+				AddSubTxDef("item", item, r.fn("compensate item")).
+				AddSubTxDef("order", order, r.fn("compensate order")).
+				AddSubTxDef("pay", r.fn("pay"), r.fn("compensate pay")).
+				AddSubTxDef("confirm", r.fn("confirm"), nil).
+				SetDependencies("order").
+				SetDependencies("pay", "item", "order")
+			c := newTestCoordinator(t, def)
+
+			exec, err := c.Execute(context.Background(), "buy", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !exec.IsAborted() {
+				t.Fatal("saga is not aborted")
+			}
+
+			var compensated []string
+			for _, call := range r.reset() {
+				if call == "confirm" || (tt.fail != "pay" && call == "pay") {
+					t.Errorf("%s is executed after failure", call)
+				}
+				if strings.HasPrefix(call, "compensate ") {
+					compensated = append(compensated, call)
+				}
+			}
+			if !equal(compensated, tt.wantCompensated) {
+				t.Errorf("compensated = %v, want %v", compensated, tt.wantCompensated)
+			}
+
+			status, err := c.Status(exec.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.State != StateAborted {
+				t.Errorf("state = %s, want %s", status.State, StateAborted)
+			}
+		})
+	}
+}
+
+func TestRecoverPendingStep(t *testing.T) {
+	output := json.RawMessage(`{}`)
+
+	tests := []struct {
+		name  string
+		fail  []string
+		logs  []Log
+		want  State
+		calls []string
+	}{
+		{
+			name: "interrupted step succeeds",
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionStart, SubTxID: "reserve"},
+			},
+			want:  StateCompleted,
+			calls: []string{"reserve", "pay", "confirm"},
+		},
+		{
+			name: "interrupted step fails",
+			fail: []string{"pay"},
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionStart, SubTxID: "reserve"},
+				{Type: ActionEnd, SubTxID: "reserve", Data: output},
+				{Type: ActionStart, SubTxID: "pay"},
+			},
+			want:  StateAborted,
+			calls: []string{"pay", "release"},
+		},
+		{
+			name: "interrupted step of aborted saga",
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionStart, SubTxID: "reserve"},
+				{Type: ActionStart, SubTxID: "check"},
+				{Type: ActionFailed, SubTxID: "check"},
+				{Type: SagaAbort},
+			},
+			want:  StateAborted,
+			calls: []string{"reserve", "release"},
+		},
+		{
+			name: "interrupted compensation",
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionStart, SubTxID: "reserve"},
+				{Type: ActionEnd, SubTxID: "reserve", Data: output},
+				{Type: ActionStart, SubTxID: "pay"},
+				{Type: ActionFailed, SubTxID: "pay"},
+				{Type: SagaAbort},
+				{Type: CompensateStart, SubTxID: "reserve"},
+			},
+			want:  StateAborted,
+			calls: []string{"release"},
+		},
+		{
+			name: "interrupted step fails after pivot",
+			fail: []string{"confirm"},
+			logs: []Log{
+				{Type: SagaStart, SagaType: "buy"},
+				{Type: ActionStart, SubTxID: "reserve"},
+				{Type: ActionEnd, SubTxID: "reserve", Data: output},
+				{Type: ActionStart, SubTxID: "pay"},
+				{Type: ActionEnd, SubTxID: "pay", Data: output},
+				{Type: ActionStart, SubTxID: "confirm"},
+			},
+			want:  StateDeadLettered,
+			calls: []string{"confirm"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRecorder(tt.fail...)
+			def := NewDefinition("buy").
+				AddSubTxDef("reserve", r.fn("reserve"), r.fn("release")).
+				AddSubTxDef("check", r.fn("check"), nil).
+				AddSubTxDef("pay", r.fn("pay"), nil).
+				AddSubTxDef("confirm", r.fn("confirm"), nil).
+				SetDependencies("check").
+				SetDependencies("pay", "reserve").
+				SetCondition("check", func(ctx context.Context, exec *Execution) (bool, error) { return false, nil }).
+				SetPivot("pay")
+			c := newTestCoordinator(t, def)
+
+			exec := newExecution("s1", "buy")
+			for _, l := range tt.logs {
+				if err := c.write(exec, l); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := c.Recover(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if calls := r.reset(); !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("calls = %v, want %v", calls, tt.calls)
+			}
+
+			status, err := c.Status("s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.State != tt.want {
+				t.Errorf("state = %s, want %s", status.State, tt.want)
+			}
+			for _, subTx := range status.SubTxs {
+				if subTx.State == StateRunning || subTx.State == StateCompensating {
+					t.Errorf("%s is left %s", subTx.ID, subTx.State)
+				}
+			}
+		})
+	}
+}
+
+// barrier releases waiting goroutines once n of them are waiting
+type barrier struct {
+	mu      sync.Mutex
+	n       int
+	waiting int
+	done    chan struct{}
+}
+
+func newBarrier(n int) *barrier {
+	return &barrier{n: n, done: make(chan struct{})}
+}
+
+// wait returns false when other goroutines do not arrive within timeout
+func (b *barrier) wait(timeout time.Duration) bool {
+	b.mu.Lock()
+	b.waiting++
+	if b.waiting == b.n {
+		close(b.done)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func keys(m map[string]bool) []string {
+	ids := []string{}
+	for id := range m {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestSlowListener(t *testing.T) {
+	itemEnded := make(chan struct{})
+	released := make(chan struct{})
+
+	var mu sync.Mutex
+	var seqs []int
+	listener := func(l Log) {
+		mu.Lock()
+		seqs = append(seqs, l.Seq)
+		mu.Unlock()
+
+		// listener is stuck until confirm runs, which needs order to be recorded
+		if l.Type == ActionEnd && l.SubTxID == "item" {
+			close(itemEnded)
+			select {
+			case <-released:
+			case <-time.After(time.Second):
+				t.Error("slow listener held up other sub-transactions")
+			}
+		}
+	}
+
+	r := newRecorder()
+	order := func(ctx context.Context, exec *Execution) (interface{}, error) {
+		<-itemEnded
+		return r.fn("order")(ctx, exec)
+	}
+	confirm := func(ctx context.Context, exec *Execution) (interface{}, error) {
+		close(released)
+		return r.fn("confirm")(ctx, exec)
+	}
+
+	def := NewDefinition("buy").
+		AddSubTxDef("item", r.fn("item"), nil).
+		AddSubTxDef("order", order, nil).
+		AddSubTxDef("confirm", confirm, nil).
+		SetDependencies("order").
+		SetDependencies("confirm", "order")
+	c := newTestCoordinator(t, def)
+	c.Subscribe(listener)
+
+	exec, err := c.Execute(context.Background(), "buy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exec.IsAborted() {
+		t.Fatal("saga is aborted")
+	}
+
+	// listeners still get every record in order
+	mu.Lock()
+	defer mu.Unlock()
+	for i, seq := range seqs {
+		if seq != i+1 {
+			t.Fatalf("listener got records %v, want them in order", seqs)
+		}
+	}
+}
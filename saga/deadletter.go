@@ -0,0 +1,119 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// deadLetterPrefix is prepended to saga ID to build its dead-letter logID
+const deadLetterPrefix = "deadletter_"
+
+// ErrNotDeadLettered is returned when re-driven saga is not in dead-letter store
+var ErrNotDeadLettered = errors.New("saga is not in dead-letter store")
+
+// DeadLetter defines saga which could be neither compensated nor finished.
+// SubTxIDs lists failed compensations of aborted saga, or failed
+// sub-transactions of saga which has passed its pivot. It stays in
+// dead-letter store until it is re-driven successfully.
+type DeadLetter struct {
+	SagaID   string    `json:"saga_id"`
+	SagaType string    `json:"saga_type"`
+	Aborted  bool      `json:"aborted"`
+	SubTxIDs []string  `json:"sub_transactions"`
+	Time     time.Time `json:"time"`
+}
+
+// deadLetter will move saga with failed compensations, or failed
+// sub-transactions after its pivot, into dead-letter store
+func (c *Coordinator) deadLetter(exec *Execution, failed []string) {
+	if exec.aborted {
+		log.Printf("saga %s : moved to dead-letter store, failed compensations : %s\n", exec.ID, strings.Join(failed, ", "))
+	} else {
+		log.Printf("saga %s : moved to dead-letter store, failed sub-transactions : %s\n", exec.ID, strings.Join(failed, ", "))
+	}
+
+	letter := DeadLetter{
+		SagaID:   exec.ID,
+		SagaType: exec.Type,
+		Aborted:  exec.aborted,
+		SubTxIDs: failed,
+		Time:     time.Now(),
+	}
+	data, _ := json.Marshal(letter)
+
+	c.write(exec, Log{Type: SagaDeadLetter, Data: data})
+	if err := c.storage.AppendLog(deadLetterPrefix+exec.ID, string(data)); err != nil {
+		log.Printf("saga %s : failed to write dead-letter : %s\n", exec.ID, err.Error())
+	}
+}
+
+// DeadLetters will return every saga in dead-letter store
+func (c *Coordinator) DeadLetters() ([]DeadLetter, error) {
+	logIDs, err := c.storage.LogIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := []DeadLetter{}
+	for _, logID := range logIDs {
+		if !strings.HasPrefix(logID, deadLetterPrefix) {
+			continue
+		}
+
+		data, err := c.storage.LastLog(logID)
+		if err != nil {
+			return nil, err
+		}
+
+		var letter DeadLetter
+		if err = json.Unmarshal([]byte(data), &letter); err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// removeDeadLetter will remove saga from dead-letter store
+func (c *Coordinator) removeDeadLetter(id string) {
+	if err := c.storage.Cleanup(deadLetterPrefix + id); err != nil {
+		log.Printf("saga %s : failed to leave dead-letter store : %s\n", id, err.Error())
+	}
+}
+
+// Redrive will retry every failed compensation of dead-lettered saga, or
+// resume saga which has failed after its pivot forward. Saga leaves
+// dead-letter store once it finishes, otherwise it is dead-lettered again.
+func (c *Coordinator) Redrive(ctx context.Context, id string, op Operator) error {
+	return c.intervene(id, ActionRedrive, "", op, checkDeadLettered, func(def *Definition, exec *Execution) {
+		c.redrive(ctx, def, exec)
+	})
+}
+
+// SubmitRedrive will record re-drive like Redrive, and queue it to be
+// executed by worker pool, so it does not depend on the caller
+func (c *Coordinator) SubmitRedrive(id string, op Operator) error {
+	return c.submitIntervention(id, ActionRedrive, "", op, checkDeadLettered, c.redrive)
+}
+
+// checkDeadLettered will check that saga is dead-lettered
+func checkDeadLettered(def *Definition, exec *Execution) error {
+	if !exec.deadLetter {
+		return ErrNotDeadLettered
+	}
+	return nil
+}
+
+// redrive will resume saga forward, or compensate it if it is aborted
+func (c *Coordinator) redrive(ctx context.Context, def *Definition, exec *Execution) {
+	if !exec.aborted {
+		c.run(ctx, def, exec)
+		return
+	}
+	c.abort(ctx, def, exec)
+	c.settle(def, exec)
+}
@@ -0,0 +1,278 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Func defines sub-transaction action or compensation. Its output is
+// persisted in saga log, so it must be JSON serializable.
+type Func func(ctx context.Context, exec *Execution) (interface{}, error)
+
+// Predicate decides whether sub-transaction is executed. It is evaluated
+// once dependencies of sub-transaction are done, so it can look at saga
+// input and outputs of previous sub-transactions.
+type Predicate func(ctx context.Context, exec *Execution) (bool, error)
+
+// SubTx defines a sub-transaction and its compensation.
+// DependsOn lists sub-transactions which must be done before it starts,
+// and When, if set, decides whether it is executed or skipped.
+// Timeout overrides step timeout of saga definition when it is set,
+// and Retry defines how failed action is retried. Sub-transaction with
+// SubSaga executes a child saga of that type instead of Action, and
+// compensates the whole child saga instead of calling Compensate.
+// Sub-transaction without Compensate has nothing to roll back.
+type SubTx struct {
+	ID           string
+	Action       Func
+	Compensate   Func
+	SubSaga      string
+	SubSagaInput Func
+	DependsOn    []string
+	When         Predicate
+	Timeout      time.Duration
+	Retry        RetryPolicy
+}
+
+// Definition defines ordered sub-transactions of a saga type.
+// StepTimeout limits every sub-transaction and compensation call,
+// zero means no limit other than the saga context. CompensationRetry
+// defines how failed compensation is retried before saga is moved to
+// dead-letter store. Once Pivot sub-transaction has succeeded, saga can
+// only go forward: failed sub-transaction no longer aborts saga, which is
+// moved to dead-letter store until the sub-transaction is retried.
+type Definition struct {
+	Type              string
+	SubTxs            []SubTx
+	StepTimeout       time.Duration
+	CompensationRetry RetryPolicy
+	Pivot             string
+}
+
+// NewDefinition will create new empty saga definition
+func NewDefinition(sagaType string) *Definition {
+	return &Definition{
+		Type: sagaType,
+	}
+}
+
+// AddSubTxDef will append sub-transaction into saga definition. It depends
+// on previously added sub-transaction, use SetDependencies to change it.
+// compensate may be nil when sub-transaction needs no rollback.
+func (d *Definition) AddSubTxDef(subTxID string, action, compensate Func) *Definition {
+	var dependsOn []string
+	if len(d.SubTxs) > 0 {
+		dependsOn = []string{d.SubTxs[len(d.SubTxs)-1].ID}
+	}
+
+	d.SubTxs = append(d.SubTxs, SubTx{
+		ID:         subTxID,
+		Action:     action,
+		Compensate: compensate,
+		DependsOn:  dependsOn,
+	})
+	return d
+}
+
+// AddSubSagaDef will append sub-transaction which executes child saga of
+// given type into saga definition. input builds child saga input, nil
+// passes saga input as is. Output of sub-transaction is SubSagaOutput.
+// Like AddSubTxDef, it depends on previously added sub-transaction.
+func (d *Definition) AddSubSagaDef(subTxID, sagaType string, input Func) *Definition {
+	d.AddSubTxDef(subTxID, nil, nil)
+
+	subTx := d.mustSubTx(subTxID)
+	subTx.SubSaga = sagaType
+	subTx.SubSagaInput = input
+	return d
+}
+
+// SetDependencies will replace dependencies of given sub-transaction.
+// Sub-transaction without dependency starts as soon as saga starts.
+func (d *Definition) SetDependencies(subTxID string, dependsOn ...string) *Definition {
+	d.mustSubTx(subTxID).DependsOn = dependsOn
+	return d
+}
+
+// SetStepTimeout will set default timeout of every sub-transaction
+func (d *Definition) SetStepTimeout(timeout time.Duration) *Definition {
+	d.StepTimeout = timeout
+	return d
+}
+
+// SetCondition will set predicate deciding whether given sub-transaction is
+// executed. Skipped sub-transaction counts as done for its dependents.
+func (d *Definition) SetCondition(subTxID string, when Predicate) *Definition {
+	d.mustSubTx(subTxID).When = when
+	return d
+}
+
+// SetRetryPolicy will set retry policy of given sub-transaction
+func (d *Definition) SetRetryPolicy(subTxID string, policy RetryPolicy) *Definition {
+	d.mustSubTx(subTxID).Retry = policy
+	return d
+}
+
+// mustSubTx returns sub-transaction with given ID, it panics when absent
+func (d *Definition) mustSubTx(subTxID string) *SubTx {
+	for i := range d.SubTxs {
+		if d.SubTxs[i].ID == subTxID {
+			return &d.SubTxs[i]
+		}
+	}
+	panic(fmt.Sprintf("sub-transaction %s is not defined in saga type %s", subTxID, d.Type))
+}
+
+// SetPivot will set sub-transaction after which saga is never compensated,
+// e.g. the one that cannot be undone
+func (d *Definition) SetPivot(subTxID string) *Definition {
+	d.mustSubTx(subTxID)
+	d.Pivot = subTxID
+	return d
+}
+
+// SetCompensationRetryPolicy will set retry policy of every compensation
+func (d *Definition) SetCompensationRetryPolicy(policy RetryPolicy) *Definition {
+	d.CompensationRetry = policy
+	return d
+}
+
+// timeout returns timeout of given sub-transaction. Step timeout does not
+// limit child saga, since every step of child saga has its own timeout.
+func (d *Definition) timeout(subTx SubTx) time.Duration {
+	if subTx.Timeout > 0 || subTx.SubSaga != "" {
+		return subTx.Timeout
+	}
+	return d.StepTimeout
+}
+
+// passedPivot returns true if pivot sub-transaction of saga has succeeded
+func (d *Definition) passedPivot(exec *Execution) bool {
+	return d.Pivot != "" && exec.isCompleted(d.Pivot)
+}
+
+// subTx will return sub-transaction with given ID
+func (d *Definition) subTx(id string) (SubTx, bool) {
+	for _, subTx := range d.SubTxs {
+		if subTx.ID == id {
+			return subTx, true
+		}
+	}
+	return SubTx{}, false
+}
+
+// ready returns unstarted sub-transactions whose dependencies are all done
+func (d *Definition) ready(exec *Execution, started map[string]bool) []SubTx {
+	subTxs := []SubTx{}
+	for _, subTx := range d.SubTxs {
+		if started[subTx.ID] || exec.isDone(subTx.ID) {
+			continue
+		}
+
+		ready := true
+		for _, dep := range subTx.DependsOn {
+			if !exec.isDone(dep) {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			subTxs = append(subTxs, subTx)
+		}
+	}
+	return subTxs
+}
+
+// validate will check that every dependency is defined, dependencies
+// have no cycle and no sub-transaction executes its own saga type
+func (d *Definition) validate() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	marks := make(map[string]int)
+	for _, subTx := range d.SubTxs {
+		if _, ok := marks[subTx.ID]; ok {
+			return fmt.Errorf("saga type %s : sub-transaction %s is defined twice", d.Type, subTx.ID)
+		}
+		if subTx.SubSaga == d.Type {
+			return fmt.Errorf("saga type %s : sub-transaction %s executes its own saga type", d.Type, subTx.ID)
+		}
+		marks[subTx.ID] = unvisited
+	}
+
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch marks[id] {
+		case visiting:
+			return fmt.Errorf("saga type %s : sub-transaction %s has cyclic dependency", d.Type, id)
+		case visited:
+			return nil
+		}
+
+		marks[id] = visiting
+		subTx, _ := d.subTx(id)
+		for _, dep := range subTx.DependsOn {
+			if _, ok := marks[dep]; !ok {
+				return fmt.Errorf("saga type %s : sub-transaction %s depends on undefined %s", d.Type, id, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		marks[id] = visited
+		return nil
+	}
+
+	for _, subTx := range d.SubTxs {
+		if err := visit(subTx.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Registry holds saga definitions keyed by saga type. Definitions are
+// registered once at startup, and registry is safe for concurrent use.
+type Registry struct {
+	mu          sync.RWMutex
+	definitions map[string]*Definition
+}
+
+// NewRegistry will create new empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		definitions: make(map[string]*Definition),
+	}
+}
+
+// Register will store saga definition under its saga type
+func (r *Registry) Register(def *Definition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.definitions[def.Type]; ok {
+		return fmt.Errorf("saga type %s is already registered", def.Type)
+	}
+	if err := def.validate(); err != nil {
+		return err
+	}
+	r.definitions[def.Type] = def
+	return nil
+}
+
+// Get will return saga definition of given saga type
+func (r *Registry) Get(sagaType string) (*Definition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	def, ok := r.definitions[sagaType]
+	if !ok {
+		return nil, fmt.Errorf("saga type %s is not registered", sagaType)
+	}
+	return def, nil
+}
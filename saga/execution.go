@@ -0,0 +1,216 @@
+package saga
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Execution defines state of a running saga. The state is rebuilt from
+// saga log records, so a saga can be resumed after orchestrator restarts.
+// Independent sub-transactions update it concurrently, so every record
+// is applied under its lock.
+type Execution struct {
+	ID   string
+	Type string
+
+	mu          sync.Mutex
+	seq         int
+	parentID    string
+	input       json.RawMessage
+	outputs     map[string]json.RawMessage
+	completed   []string
+	compensated map[string]bool
+	skipped     map[string]bool
+	pending     map[string]bool
+	children    map[string]string
+	failed      map[string]bool
+	aborted     bool
+	deadLetter  bool
+	wasDead     bool
+	intervened  bool
+	ended       bool
+
+	// records written but not yet passed to listeners, which are
+	// notified in order by one writer at a time
+	unnotified []Log
+	notifying  bool
+}
+
+func newExecution(id, sagaType string) *Execution {
+	return &Execution{
+		ID:          id,
+		Type:        sagaType,
+		outputs:     make(map[string]json.RawMessage),
+		compensated: make(map[string]bool),
+		skipped:     make(map[string]bool),
+		pending:     make(map[string]bool),
+		children:    make(map[string]string),
+		failed:      make(map[string]bool),
+	}
+}
+
+// Input will decode saga input into v
+func (e *Execution) Input(v interface{}) error {
+	return json.Unmarshal(e.input, v)
+}
+
+// Output will decode output of finished sub-transaction into v. Sub-transaction
+// which is not defined in this saga is looked up in finished child sagas.
+func (e *Execution) Output(subTxID string, v interface{}) error {
+	e.mu.Lock()
+	data, ok := e.outputs[subTxID]
+	if !ok {
+		data, ok = e.childOutput(subTxID)
+	}
+	e.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("sub-transaction %s has no output", subTxID)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// childOutput returns output of sub-transaction of finished child saga
+func (e *Execution) childOutput(subTxID string) (json.RawMessage, bool) {
+	for _, id := range e.completed {
+		if e.children[id] == "" {
+			continue
+		}
+
+		var output SubSagaOutput
+		if err := json.Unmarshal(e.outputs[id], &output); err != nil {
+			continue
+		}
+		if data, ok := output.Outputs[subTxID]; ok {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// IsAborted returns true if saga has been compensated
+func (e *Execution) IsAborted() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.aborted
+}
+
+// Intervened returns true if operator has acted on saga manually
+func (e *Execution) Intervened() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.intervened
+}
+
+// apply will update execution state with given log record
+func (e *Execution) apply(l Log) {
+	e.seq = l.Seq
+	switch l.Type {
+	case SagaStart:
+		e.Type = l.SagaType
+		e.parentID = l.ParentID
+		e.input = l.Data
+	case SagaAbort:
+		e.aborted = true
+	case SagaDeadLetter:
+		e.deadLetter = true
+		e.wasDead = true
+	case SagaIntervention:
+		e.deadLetter = false
+		e.intervened = true
+		e.ended = false
+	case SagaParentAbort:
+		e.deadLetter = false
+		e.ended = false
+		e.aborted = true
+	case SagaEnd:
+		e.ended = true
+	case SubSagaStart:
+		e.children[l.SubTxID] = l.ChildID
+	case ActionStart:
+		e.pending[l.SubTxID] = true
+		delete(e.failed, l.SubTxID)
+	case ActionEnd:
+		delete(e.pending, l.SubTxID)
+		e.outputs[l.SubTxID] = l.Data
+		e.completed = append(e.completed, l.SubTxID)
+	case ActionSkip:
+		delete(e.pending, l.SubTxID)
+		e.skipped[l.SubTxID] = true
+	case ActionFailed:
+		delete(e.pending, l.SubTxID)
+		e.failed[l.SubTxID] = true
+	case CompensateEnd:
+		e.compensated[l.SubTxID] = true
+	}
+}
+
+// uncompensated returns finished sub-transactions which have not been
+// compensated, in reverse order of completion. Sub-transaction always
+// completes after its dependencies, so this is reverse dependency order.
+func (e *Execution) uncompensated() []string {
+	ids := []string{}
+	for i := len(e.completed) - 1; i >= 0; i-- {
+		if !e.compensated[e.completed[i]] {
+			ids = append(ids, e.completed[i])
+		}
+	}
+	return ids
+}
+
+// failures returns sub-transactions whose last attempt has failed, in
+// definition order
+func (e *Execution) failures(def *Definition) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids := []string{}
+	for _, subTx := range def.SubTxs {
+		if e.failed[subTx.ID] {
+			ids = append(ids, subTx.ID)
+		}
+	}
+	return ids
+}
+
+// child returns ID of the latest child saga executed by sub-transaction
+func (e *Execution) child(subTxID string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.children[subTxID]
+}
+
+// isCompleted returns true if sub-transaction has succeeded
+func (e *Execution) isCompleted(subTxID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, id := range e.completed {
+		if id == subTxID {
+			return true
+		}
+	}
+	return false
+}
+
+// isDone returns true if sub-transaction has succeeded or has been skipped
+func (e *Execution) isDone(subTxID string) bool {
+	e.mu.Lock()
+	skipped := e.skipped[subTxID]
+	e.mu.Unlock()
+
+	return skipped || e.isCompleted(subTxID)
+}
+
+// replay will rebuild execution state from saga log records
+func replay(id string, logs []Log) *Execution {
+	exec := newExecution(id, "")
+	for _, l := range logs {
+		exec.apply(l)
+	}
+	return exec
+}
@@ -0,0 +1,208 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// ActionRedrive retries every failed compensation of dead-lettered saga,
+	// or resumes saga which has failed after its pivot
+	ActionRedrive = "redrive"
+	// ActionRetryStep retries a sub-transaction and resumes saga forward
+	ActionRetryStep = "retry-step"
+	// ActionRetryCompensation retries compensation of a sub-transaction
+	ActionRetryCompensation = "retry-compensation"
+	// ActionMarkCompensated records that sub-transaction was compensated outside of saga
+	ActionMarkCompensated = "mark-compensated"
+	// ActionForceComplete finishes saga regardless of its state
+	ActionForceComplete = "force-complete"
+)
+
+var (
+	// ErrSagaRunning is returned when saga is being executed in this process
+	ErrSagaRunning = errors.New("saga is running")
+	// ErrOperatorRequired is returned when intervention has no actor or reason
+	ErrOperatorRequired = errors.New("actor and reason are required")
+	// ErrActionNotAllowed is returned when intervention does not fit saga state
+	ErrActionNotAllowed = errors.New("action is not allowed")
+)
+
+// Operator defines who acts on saga manually and why
+type Operator struct {
+	Actor  string `json:"actor"`
+	Reason string `json:"reason"`
+}
+
+// Intervention defines manual action recorded in saga log
+type Intervention struct {
+	Action string `json:"action"`
+	Operator
+}
+
+// RetryStep will execute failed or unfinished sub-transaction again, and
+// then resume the rest of saga forward. Saga dead-lettered after failing
+// past its pivot is reopened. Sub-transaction must be ready, i.e. unfinished
+// with every dependency succeeded, and saga must not be aborted, since
+// compensated saga can not go forward.
+func (c *Coordinator) RetryStep(ctx context.Context, id, subTxID string, op Operator) error {
+	return c.intervene(id, ActionRetryStep, subTxID, op, checkRetryable(subTxID), func(def *Definition, exec *Execution) {
+		c.run(ctx, def, exec)
+	})
+}
+
+// SubmitRetryStep will record sub-transaction retry like RetryStep, and
+// queue it to be executed by worker pool, so it does not depend on the caller
+func (c *Coordinator) SubmitRetryStep(id, subTxID string, op Operator) error {
+	return c.submitIntervention(id, ActionRetryStep, subTxID, op, checkRetryable(subTxID), c.run)
+}
+
+// RetryCompensation will execute compensation of a sub-transaction of
+// aborted saga again
+func (c *Coordinator) RetryCompensation(ctx context.Context, id, subTxID string, op Operator) error {
+	return c.intervene(id, ActionRetryCompensation, subTxID, op, checkCompensable(subTxID), func(def *Definition, exec *Execution) {
+		subTx, _ := def.subTx(subTxID)
+		c.compensate(detach(ctx), def, exec, subTx)
+		c.settle(def, exec)
+	})
+}
+
+// MarkCompensated will record that sub-transaction of aborted saga has
+// been compensated outside of saga, e.g. fixed by hand in participant service
+func (c *Coordinator) MarkCompensated(id, subTxID string, op Operator) error {
+	return c.intervene(id, ActionMarkCompensated, subTxID, op, checkCompensable(subTxID), func(def *Definition, exec *Execution) {
+		c.write(exec, Log{Type: CompensateEnd, SubTxID: subTxID})
+		c.settle(def, exec)
+	})
+}
+
+// ForceComplete will finish saga regardless of its state. Saga leaves
+// dead-letter store, and remaining sub-transactions or compensations are
+// never executed.
+func (c *Coordinator) ForceComplete(id string, op Operator) error {
+	return c.intervene(id, ActionForceComplete, "", op, func(def *Definition, exec *Execution) error {
+		if exec.ended && !exec.deadLetter {
+			return fmt.Errorf("%w : saga is already finished", ErrActionNotAllowed)
+		}
+		return nil
+	}, func(def *Definition, exec *Execution) {
+		if exec.wasDead {
+			c.removeDeadLetter(exec.ID)
+		}
+		c.end(exec)
+	})
+}
+
+// checkRetryable will check that sub-transaction of saga which is not
+// aborted is ready to be executed
+func checkRetryable(subTxID string) func(def *Definition, exec *Execution) error {
+	return func(def *Definition, exec *Execution) error {
+		if exec.aborted {
+			return fmt.Errorf("%w : saga is aborted", ErrActionNotAllowed)
+		}
+		if exec.ended && !exec.deadLetter {
+			return fmt.Errorf("%w : saga is already finished", ErrActionNotAllowed)
+		}
+		for _, subTx := range def.ready(exec, nil) {
+			if subTx.ID == subTxID {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w : sub-transaction %s is finished or waiting for its dependencies", ErrActionNotAllowed, subTxID)
+	}
+}
+
+// checkCompensable will check that sub-transaction of aborted saga has
+// succeeded and has not been compensated
+func checkCompensable(subTxID string) func(def *Definition, exec *Execution) error {
+	return func(def *Definition, exec *Execution) error {
+		if !exec.aborted {
+			return fmt.Errorf("%w : saga is not aborted", ErrActionNotAllowed)
+		}
+		if !exec.isCompleted(subTxID) {
+			return fmt.Errorf("%w : sub-transaction %s has not succeeded", ErrActionNotAllowed, subTxID)
+		}
+		if exec.compensated[subTxID] {
+			return fmt.Errorf("%w : sub-transaction %s is already compensated", ErrActionNotAllowed, subTxID)
+		}
+		return nil
+	}
+}
+
+// intervene will record the intervention and then execute the action
+func (c *Coordinator) intervene(id, action, subTxID string, op Operator, check func(*Definition, *Execution) error, act func(*Definition, *Execution)) error {
+	def, exec, err := c.record(id, action, subTxID, op, check)
+	if err != nil {
+		return err
+	}
+	defer c.release(id)
+
+	act(def, exec)
+	return nil
+}
+
+// submitIntervention will record the intervention and queue the action to
+// worker pool, which executes it on its own context
+func (c *Coordinator) submitIntervention(id, action, subTxID string, op Operator, check func(*Definition, *Execution) error, act func(context.Context, *Definition, *Execution)) error {
+	if c.jobs == nil {
+		return ErrWorkersNotStarted
+	}
+
+	def, exec, err := c.record(id, action, subTxID, op, check)
+	if err != nil {
+		return err
+	}
+
+	if !c.queue(job{id: id, act: func(ctx context.Context) { act(ctx, def, exec) }}) {
+		c.release(id)
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// record will claim and load saga, check that action fits its state and
+// record the intervention. Saga stays claimed unless error is returned.
+func (c *Coordinator) record(id, action, subTxID string, op Operator, check func(*Definition, *Execution) error) (*Definition, *Execution, error) {
+	if op.Actor == "" || op.Reason == "" {
+		return nil, nil, ErrOperatorRequired
+	}
+	if !c.claim(id) {
+		return nil, nil, ErrSagaRunning
+	}
+
+	def, exec, err := c.checkAction(id, subTxID, check)
+	if err == nil {
+		data, _ := json.Marshal(Intervention{Action: action, Operator: op})
+		err = c.write(exec, Log{Type: SagaIntervention, SubTxID: subTxID, Data: data})
+	}
+	if err != nil {
+		c.release(id)
+		return nil, nil, err
+	}
+	return def, exec, nil
+}
+
+// checkAction will load saga and check that action on given sub-transaction
+// fits its state
+func (c *Coordinator) checkAction(id, subTxID string, check func(*Definition, *Execution) error) (*Definition, *Execution, error) {
+	exec, err := c.load(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	def, err := c.registry.Get(exec.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+	if subTxID != "" {
+		if _, ok := def.subTx(subTxID); !ok {
+			return nil, nil, fmt.Errorf("%w : sub-transaction %s is not defined", ErrActionNotAllowed, subTxID)
+		}
+	}
+	if err = check(def, exec); err != nil {
+		return nil, nil, err
+	}
+	return def, exec, nil
+}
@@ -0,0 +1,280 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/cikupin/saga-simple-example/storage"
+)
+
+// recorder records every call of sub-transactions and compensations, and
+// fails the calls it is told to
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+	fail  map[string]bool
+}
+
+func newRecorder(fail ...string) *recorder {
+	r := &recorder{fail: make(map[string]bool)}
+	for _, name := range fail {
+		r.fail[name] = true
+	}
+	return r
+}
+
+// fn returns action or compensation recorded under given name
+func (r *recorder) fn(name string) Func {
+	return func(ctx context.Context, exec *Execution) (interface{}, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.calls = append(r.calls, name)
+		if r.fail[name] {
+			return nil, errors.New(name + " failed")
+		}
+		return map[string]string{"name": name}, nil
+	}
+}
+
+func (r *recorder) setFail(name string, fail bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fail[name] = fail
+}
+
+func (r *recorder) reset() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
+
+// newTestCoordinator will create coordinator keeping saga log in memory
+// with given definitions registered
+func newTestCoordinator(t *testing.T, defs ...*Definition) *Coordinator {
+	t.Helper()
+
+	registry := NewRegistry()
+	for _, def := range defs {
+		if err := registry.Register(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewCoordinator(registry, storage.NewMemoryStorage())
+}
+
+func TestRetryStep(t *testing.T) {
+	op := Operator{Actor: "alice", Reason: "service is back"}
+
+	tests := []struct {
+		name    string
+		fail    []string
+		pivot   bool
+		retry   string
+		op      Operator
+		wantErr error
+		want    State
+		calls   []string
+	}{
+		{
+			name:  "dead-lettered after pivot",
+			fail:  []string{"confirm"},
+			pivot: true,
+			retry: "confirm",
+			op:    op,
+			want:  StateCompleted,
+			calls: []string{"confirm"},
+		},
+		{
+			name:    "aborted before pivot",
+			fail:    []string{"pay"},
+			pivot:   true,
+			retry:   "pay",
+			op:      op,
+			wantErr: ErrActionNotAllowed,
+			want:    StateAborted,
+		},
+		{
+			name:    "aborted without pivot",
+			fail:    []string{"confirm"},
+			retry:   "confirm",
+			op:      op,
+			wantErr: ErrActionNotAllowed,
+			want:    StateAborted,
+		},
+		{
+			name:    "completed",
+			pivot:   true,
+			retry:   "confirm",
+			op:      op,
+			wantErr: ErrActionNotAllowed,
+			want:    StateCompleted,
+		},
+		{
+			name:    "finished sub-transaction",
+			fail:    []string{"confirm"},
+			pivot:   true,
+			retry:   "pay",
+			op:      op,
+			wantErr: ErrActionNotAllowed,
+			want:    StateDeadLettered,
+		},
+		{
+			name:    "without operator",
+			fail:    []string{"confirm"},
+			pivot:   true,
+			retry:   "confirm",
+			wantErr: ErrOperatorRequired,
+			want:    StateDeadLettered,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRecorder(tt.fail...)
+			def := NewDefinition("buy").
+				AddSubTxDef("reserve", r.fn("reserve"), r.fn("release")).
+				AddSubTxDef("pay", r.fn("pay"), nil).
+				AddSubTxDef("confirm", r.fn("confirm"), nil)
+			if tt.pivot {
+				def.SetPivot("pay")
+			}
+			c := newTestCoordinator(t, def)
+
+			exec, err := c.Execute(context.Background(), "buy", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range tt.fail {
+				r.setFail(name, false)
+			}
+			r.reset()
+
+			err = c.RetryStep(context.Background(), exec.ID, tt.retry, tt.op)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RetryStep() error = %v, want %v", err, tt.wantErr)
+			}
+
+			status, err := c.Status(exec.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.State != tt.want {
+				t.Errorf("state = %s, want %s", status.State, tt.want)
+			}
+			if calls := r.reset(); len(calls) != len(tt.calls) {
+				t.Errorf("calls = %v, want %v", calls, tt.calls)
+			}
+
+			letters, err := c.DeadLetters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dead := len(letters) > 0; dead != (tt.want == StateDeadLettered) {
+				t.Errorf("dead letters = %v, want state %s", letters, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryStepDeadLetter(t *testing.T) {
+	r := newRecorder("confirm")
+	def := NewDefinition("buy").
+		AddSubTxDef("reserve", r.fn("reserve"), r.fn("release")).
+		AddSubTxDef("pay", r.fn("pay"), nil).
+		AddSubTxDef("confirm", r.fn("confirm"), nil).
+		SetPivot("pay")
+	c := newTestCoordinator(t, def)
+
+	exec, err := c.Execute(context.Background(), "buy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exec.IsAborted() {
+		t.Fatal("saga is aborted after its pivot")
+	}
+
+	letters, err := c.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Aborted || len(letters[0].SubTxIDs) != 1 || letters[0].SubTxIDs[0] != "confirm" {
+		t.Fatalf("dead letters = %+v, want failed confirm", letters)
+	}
+
+	// still failing step is dead-lettered again
+	op := Operator{Actor: "alice", Reason: "retry"}
+	if err = c.RetryStep(context.Background(), exec.ID, "confirm", op); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := c.Status(exec.ID)
+	if status.State != StateDeadLettered {
+		t.Fatalf("state = %s, want %s", status.State, StateDeadLettered)
+	}
+
+	r.setFail("confirm", false)
+	if err = c.RetryStep(context.Background(), exec.ID, "confirm", op); err != nil {
+		t.Fatal(err)
+	}
+	status, _ = c.Status(exec.ID)
+	if status.State != StateCompleted || len(status.Interventions) != 2 {
+		t.Fatalf("state = %s with %d interventions, want %s with 2", status.State, len(status.Interventions), StateCompleted)
+	}
+	for _, call := range r.reset() {
+		if call == "release" {
+			t.Fatal("saga is compensated after its pivot")
+		}
+	}
+}
+
+func TestSubmitRetryStep(t *testing.T) {
+	r := newRecorder("confirm")
+	def := NewDefinition("buy").
+		AddSubTxDef("reserve", r.fn("reserve"), r.fn("release")).
+		AddSubTxDef("pay", r.fn("pay"), nil).
+		AddSubTxDef("confirm", r.fn("confirm"), nil).
+		SetPivot("pay")
+	c := newTestCoordinator(t, def)
+
+	exec, err := c.Execute(context.Background(), "buy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := Operator{Actor: "alice", Reason: "retry"}
+	if err = c.SubmitRetryStep(exec.ID, "confirm", op); err != ErrWorkersNotStarted {
+		t.Fatalf("SubmitRetryStep() error = %v, want %v", err, ErrWorkersNotStarted)
+	}
+
+	ended := make(chan struct{})
+	c.Subscribe(func(l Log) {
+		if l.Type == SagaEnd {
+			close(ended)
+		}
+	})
+
+	// retry is validated and recorded before SubmitRetryStep returns, and
+	// executed by worker pool afterwards
+	c.StartWorkers(context.Background(), 1, 1)
+
+	if err = c.SubmitRetryStep(exec.ID, "pay", op); !errors.Is(err, ErrActionNotAllowed) {
+		t.Fatalf("SubmitRetryStep() of finished step error = %v, want %v", err, ErrActionNotAllowed)
+	}
+	r.setFail("confirm", false)
+	if err = c.SubmitRetryStep(exec.ID, "confirm", op); err != nil {
+		t.Fatal(err)
+	}
+	<-ended
+
+	status, _ := c.Status(exec.ID)
+	if status.State != StateCompleted || len(status.Interventions) != 1 {
+		t.Fatalf("state = %s with %d interventions, want %s with 1", status.State, len(status.Interventions), StateCompleted)
+	}
+}
@@ -0,0 +1,84 @@
+package saga
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// LogType defines type of saga log record
+type LogType string
+
+const (
+	// SagaStart is written when saga is started, together with saga input.
+	// Child saga records its parent saga and parent sub-transaction.
+	SagaStart LogType = "saga-start"
+	// SagaAbort is written when saga starts to compensate
+	SagaAbort LogType = "saga-abort"
+	// SagaDeadLetter is written when saga compensation failed and saga is moved to dead-letter store
+	SagaDeadLetter LogType = "saga-dead-letter"
+	// SagaIntervention is written before operator acts on saga manually,
+	// together with who did it and why. It reopens finished saga.
+	SagaIntervention LogType = "saga-intervention"
+	// SagaParentAbort is written when parent saga compensates the sub-transaction
+	// which executed this saga. It reopens finished saga to compensate it.
+	SagaParentAbort LogType = "saga-parent-abort"
+	// SagaEnd is written when saga is finished, whether it is aborted or not
+	SagaEnd LogType = "saga-end"
+	// ActionStart is written before sub-transaction is executed
+	ActionStart LogType = "action-start"
+	// ActionEnd is written after sub-transaction succeeded, together with its output
+	ActionEnd LogType = "action-end"
+	// SubSagaStart is written before child saga of sub-transaction is started, together with child saga ID
+	SubSagaStart LogType = "sub-saga-start"
+	// ActionSkip is written when sub-transaction condition is not met, skipped sub-transaction is never compensated
+	ActionSkip LogType = "action-skip"
+	// ActionRetry is written after sub-transaction attempt failed and it will be retried
+	ActionRetry LogType = "action-retry"
+	// ActionFailed is written after sub-transaction failed
+	ActionFailed LogType = "action-failed"
+	// CompensateStart is written before compensation is executed
+	CompensateStart LogType = "compensate-start"
+	// CompensateEnd is written after compensation succeeded, together with its output
+	CompensateEnd LogType = "compensate-end"
+	// CompensateRetry is written after compensation attempt failed and it will be retried
+	CompensateRetry LogType = "compensate-retry"
+	// CompensateFailed is written after compensation failed
+	CompensateFailed LogType = "compensate-failed"
+)
+
+// Log defines a single saga log record. Seq is the position of record
+// inside its saga log, starting from 1. ParentID and ChildID link parent
+// saga and child saga executed by one of its sub-transactions.
+type Log struct {
+	Seq      int             `json:"seq"`
+	Type     LogType         `json:"type"`
+	SagaID   string          `json:"saga_id"`
+	SagaType string          `json:"saga_type,omitempty"`
+	SubTxID  string          `json:"sub_tx_id,omitempty"`
+	ParentID string          `json:"parent_id,omitempty"`
+	ChildID  string          `json:"child_id,omitempty"`
+	Time     time.Time       `json:"time"`
+	Attempt  int             `json:"attempt,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func (l Log) marshal() (string, error) {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func unmarshalLogs(data []string) ([]Log, error) {
+	logs := make([]Log, 0, len(data))
+	for _, d := range data {
+		var l Log
+		if err := json.Unmarshal([]byte(d), &l); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
@@ -0,0 +1,121 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ErrorClass defines class of sub-transaction error, used by retry policy
+// to decide whether failed sub-transaction can be retried
+type ErrorClass string
+
+const (
+	// ErrorNetwork means participant service could not be reached
+	ErrorNetwork ErrorClass = "network"
+	// ErrorTimeout means sub-transaction exceeded its deadline
+	ErrorTimeout ErrorClass = "timeout"
+	// ErrorServer means participant service failed to process request
+	ErrorServer ErrorClass = "server"
+	// ErrorClient means participant service rejected request
+	ErrorClient ErrorClass = "client"
+	// ErrorConflict means participant service rejected request because
+	// resource is locked by another saga
+	ErrorConflict ErrorClass = "conflict"
+	// ErrorUnknown means error has no known class
+	ErrorUnknown ErrorClass = "unknown"
+)
+
+// AllErrorClasses lists every error class, used by retry policy which
+// retries regardless of error
+var AllErrorClasses = []ErrorClass{ErrorNetwork, ErrorTimeout, ErrorServer, ErrorClient, ErrorConflict, ErrorUnknown}
+
+type classError struct {
+	class ErrorClass
+	err   error
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Unwrap() error {
+	return e.err
+}
+
+// ClassError will attach error class to err
+func ClassError(class ErrorClass, err error) error {
+	return &classError{class: class, err: err}
+}
+
+// Class returns error class of err. Error without attached class is
+// classified as network error when it is a net.Error.
+func Class(err error) ErrorClass {
+	if err == ErrStepTimeout {
+		return ErrorTimeout
+	}
+
+	var ce *classError
+	if errors.As(err, &ce) {
+		return ce.class
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return ErrorNetwork
+	}
+	return ErrorUnknown
+}
+
+// RetryPolicy defines how failed sub-transaction is retried. Backoff
+// doubles on every attempt up to MaxBackoff, and half of it is randomized
+// to spread retries of concurrent sagas. Zero policy means no retry.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	RetryOn        []ErrorClass
+}
+
+// retryable returns true if err can be retried by the policy
+func (p RetryPolicy) retryable(err error) bool {
+	class := Class(err)
+	for _, c := range p.RetryOn {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns wait duration after given failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || backoff < p.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+// sleep will wait for given duration. It returns false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,234 @@
+package saga
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// State defines current state of saga or sub-transaction
+type State string
+
+const (
+	// StatePending means sub-transaction has not been started
+	StatePending State = "pending"
+	// StateRunning means saga or sub-transaction is being executed
+	StateRunning State = "running"
+	// StateSucceeded means sub-transaction succeeded
+	StateSucceeded State = "succeeded"
+	// StateSkipped means sub-transaction condition was not met
+	StateSkipped State = "skipped"
+	// StateFailed means sub-transaction failed
+	StateFailed State = "failed"
+	// StateCompleted means every sub-transaction of saga succeeded
+	StateCompleted State = "completed"
+	// StateCompensating means saga or sub-transaction is being compensated
+	StateCompensating State = "compensating"
+	// StateCompensated means sub-transaction has been compensated
+	StateCompensated State = "compensated"
+	// StateCompensationFailed means compensation of sub-transaction failed
+	StateCompensationFailed State = "compensation-failed"
+	// StateAborted means saga has been compensated
+	StateAborted State = "aborted"
+	// StateDeadLettered means saga compensation failed and saga is waiting to be re-driven
+	StateDeadLettered State = "dead-lettered"
+	// StateForceCompleted means saga has been finished manually by operator
+	StateForceCompleted State = "force-completed"
+)
+
+type (
+	// Status defines saga status rebuilt from saga log. Child saga has
+	// ParentID and ParentSubTxID of sub-transaction which executed it.
+	Status struct {
+		ID            string          `json:"id"`
+		Type          string          `json:"type"`
+		ParentID      string          `json:"parent_id,omitempty"`
+		ParentSubTxID string          `json:"parent_sub_tx_id,omitempty"`
+		State         State           `json:"state"`
+		Input         json.RawMessage `json:"input,omitempty"`
+		StartedAt     *time.Time      `json:"started_at,omitempty"`
+		EndedAt       *time.Time      `json:"ended_at,omitempty"`
+		SubTxs        []*SubTxStatus  `json:"sub_transactions"`
+
+		Interventions []InterventionStatus `json:"interventions,omitempty"`
+	}
+
+	// InterventionStatus defines manual action taken on saga
+	InterventionStatus struct {
+		Intervention
+		SubTxID string    `json:"sub_tx_id,omitempty"`
+		Time    time.Time `json:"time"`
+	}
+
+	// SubTxStatus defines status of a sub-transaction and its compensation.
+	// ChildID is ID of the latest child saga executed by sub-transaction.
+	SubTxStatus struct {
+		ID           string              `json:"id"`
+		DependsOn    []string            `json:"depends_on,omitempty"`
+		ChildID      string              `json:"child_id,omitempty"`
+		State        State               `json:"state"`
+		StartedAt    *time.Time          `json:"started_at,omitempty"`
+		EndedAt      *time.Time          `json:"ended_at,omitempty"`
+		Attempts     int                 `json:"attempts,omitempty"`
+		Output       json.RawMessage     `json:"output,omitempty"`
+		Error        string              `json:"error,omitempty"`
+		Compensation *CompensationStatus `json:"compensation,omitempty"`
+	}
+
+	// CompensationStatus defines status of a sub-transaction compensation
+	CompensationStatus struct {
+		StartedAt *time.Time      `json:"started_at,omitempty"`
+		EndedAt   *time.Time      `json:"ended_at,omitempty"`
+		Attempts  int             `json:"attempts,omitempty"`
+		Output    json.RawMessage `json:"output,omitempty"`
+		Error     string          `json:"error,omitempty"`
+	}
+)
+
+// Status will return status of saga with given ID
+func (c *Coordinator) Status(id string) (*Status, error) {
+	logs, err := c.Logs(id)
+	if err != nil {
+		return nil, err
+	}
+
+	status := buildStatus(id, logs)
+	if def, err := c.registry.Get(status.Type); err == nil {
+		status.addPending(def)
+	}
+	return status, nil
+}
+
+// buildStatus will rebuild saga status from saga log records
+func buildStatus(id string, logs []Log) *Status {
+	status := &Status{
+		ID:     id,
+		State:  StateRunning,
+		SubTxs: []*SubTxStatus{},
+	}
+
+	aborted := false
+	for _, l := range logs {
+		t := l.Time
+		switch l.Type {
+		case SagaStart:
+			status.Type = l.SagaType
+			status.ParentID = l.ParentID
+			status.ParentSubTxID = l.SubTxID
+			status.Input = l.Data
+			status.StartedAt = &t
+		case SagaAbort:
+			aborted = true
+			status.State = StateCompensating
+		case SagaDeadLetter:
+			status.State = StateDeadLettered
+		case SagaIntervention:
+			var intervention Intervention
+			json.Unmarshal(l.Data, &intervention)
+			status.Interventions = append(status.Interventions, InterventionStatus{
+				Intervention: intervention,
+				SubTxID:      l.SubTxID,
+				Time:         t,
+			})
+
+			status.EndedAt = nil
+			switch {
+			case intervention.Action == ActionForceComplete:
+				status.State = StateForceCompleted
+			case aborted:
+				status.State = StateCompensating
+			default:
+				status.State = StateRunning
+			}
+		case SagaParentAbort:
+			aborted = true
+			status.EndedAt = nil
+			status.State = StateCompensating
+		case SagaEnd:
+			status.EndedAt = &t
+			switch status.State {
+			case StateCompensating:
+				status.State = StateAborted
+			case StateRunning:
+				status.State = StateCompleted
+			}
+		case ActionStart:
+			subTx := status.subTx(l.SubTxID)
+			subTx.State = StateRunning
+			subTx.StartedAt = &t
+		case ActionEnd:
+			subTx := status.subTx(l.SubTxID)
+			subTx.State = StateSucceeded
+			subTx.EndedAt = &t
+			subTx.Attempts = l.Attempt
+			subTx.Output = l.Data
+			subTx.Error = ""
+		case SubSagaStart:
+			status.subTx(l.SubTxID).ChildID = l.ChildID
+		case ActionSkip:
+			subTx := status.subTx(l.SubTxID)
+			subTx.State = StateSkipped
+			subTx.EndedAt = &t
+		case ActionRetry:
+			subTx := status.subTx(l.SubTxID)
+			subTx.Attempts = l.Attempt
+			subTx.Error = l.Error
+		case ActionFailed:
+			subTx := status.subTx(l.SubTxID)
+			subTx.State = StateFailed
+			subTx.EndedAt = &t
+			subTx.Attempts = l.Attempt
+			subTx.Error = l.Error
+		case CompensateStart:
+			subTx := status.subTx(l.SubTxID)
+			subTx.State = StateCompensating
+			subTx.Compensation = &CompensationStatus{StartedAt: &t}
+		case CompensateEnd:
+			subTx := status.subTx(l.SubTxID)
+			subTx.State = StateCompensated
+			subTx.compensation().EndedAt = &t
+			subTx.Compensation.Attempts = l.Attempt
+			subTx.Compensation.Output = l.Data
+			subTx.Compensation.Error = ""
+		case CompensateRetry:
+			subTx := status.subTx(l.SubTxID)
+			subTx.compensation().Attempts = l.Attempt
+			subTx.Compensation.Error = l.Error
+		case CompensateFailed:
+			subTx := status.subTx(l.SubTxID)
+			subTx.State = StateCompensationFailed
+			subTx.compensation().EndedAt = &t
+			subTx.Compensation.Attempts = l.Attempt
+			subTx.Compensation.Error = l.Error
+		}
+	}
+	return status
+}
+
+// subTx will return status of given sub-transaction, creating it when absent
+func (s *Status) subTx(id string) *SubTxStatus {
+	for _, subTx := range s.SubTxs {
+		if subTx.ID == id {
+			return subTx
+		}
+	}
+
+	subTx := &SubTxStatus{ID: id, State: StatePending}
+	s.SubTxs = append(s.SubTxs, subTx)
+	return subTx
+}
+
+// compensation will return compensation status, creating it when absent
+func (s *SubTxStatus) compensation() *CompensationStatus {
+	if s.Compensation == nil {
+		s.Compensation = &CompensationStatus{}
+	}
+	return s.Compensation
+}
+
+// addPending will list sub-transactions which have not been started yet,
+// together with dependencies of every sub-transaction
+func (s *Status) addPending(def *Definition) {
+	for _, subTx := range def.SubTxs {
+		s.subTx(subTx.ID).DependsOn = subTx.DependsOn
+	}
+}
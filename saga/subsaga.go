@@ -0,0 +1,94 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrSubSagaAborted is returned when child saga of sub-transaction is
+	// aborted. Child saga has compensated itself by then.
+	ErrSubSagaAborted = errors.New("sub-saga aborted")
+	// ErrSubSagaNotCompensated is returned when child saga still has
+	// uncompensated sub-transactions after it is compensated
+	ErrSubSagaNotCompensated = errors.New("sub-saga not compensated")
+)
+
+// SubSagaOutput defines output of sub-transaction which executes child saga
+type SubSagaOutput struct {
+	SagaID  string                     `json:"saga_id"`
+	Outputs map[string]json.RawMessage `json:"outputs"`
+}
+
+// execChild will execute child saga of sub-transaction until it is
+// finished or compensated
+func (c *Coordinator) execChild(ctx context.Context, exec *Execution, subTx SubTx) (interface{}, error) {
+	var input interface{} = exec.input
+	if subTx.SubSagaInput != nil {
+		var err error
+		if input, err = subTx.SubSagaInput(ctx, exec); err != nil {
+			return nil, err
+		}
+	}
+
+	def, child, err := c.start(subTx.SubSaga, input, exec, subTx.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(child.ID)
+
+	c.run(ctx, def, child)
+	if child.aborted {
+		return nil, fmt.Errorf("saga %s : %w", child.ID, ErrSubSagaAborted)
+	}
+
+	child.mu.Lock()
+	defer child.mu.Unlock()
+
+	output := SubSagaOutput{
+		SagaID:  child.ID,
+		Outputs: make(map[string]json.RawMessage),
+	}
+	for id, data := range child.outputs {
+		output.Outputs[id] = data
+	}
+	return output, nil
+}
+
+// compensateChild will compensate every finished sub-transaction of child
+// saga. Child saga which has finished is reopened first. Child saga which
+// has already been compensated is left as is.
+func (c *Coordinator) compensateChild(ctx context.Context, id string) error {
+	if !c.claim(id) {
+		return fmt.Errorf("saga %s : %w", id, ErrSagaRunning)
+	}
+	defer c.release(id)
+
+	child, err := c.load(id)
+	if err != nil {
+		return err
+	}
+	if child.aborted && child.ended && len(child.uncompensated()) == 0 {
+		return nil
+	}
+
+	def, err := c.registry.Get(child.Type)
+	if err != nil {
+		return err
+	}
+
+	if child.ended {
+		if err = c.write(child, Log{Type: SagaParentAbort}); err != nil {
+			return err
+		}
+	}
+	c.abort(ctx, def, child)
+	c.settle(def, child)
+
+	if len(child.uncompensated()) > 0 {
+		return fmt.Errorf("saga %s : %w", id, ErrSubSagaNotCompensated)
+	}
+	return nil
+}
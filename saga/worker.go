@@ -0,0 +1,67 @@
+package saga
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrWorkersNotStarted is returned when saga is submitted before workers are started
+	ErrWorkersNotStarted = errors.New("saga workers are not started")
+	// ErrQueueFull is returned when saga queue has no room for another saga
+	ErrQueueFull = errors.New("saga queue is full")
+)
+
+// job runs act on a worker and then releases saga with given ID
+type job struct {
+	id  string
+	act func(ctx context.Context)
+}
+
+// StartWorkers will start worker pool executing submitted sagas.
+// It must be called once before Submit.
+func (c *Coordinator) StartWorkers(ctx context.Context, workers, queueSize int) {
+	c.jobs = make(chan job, queueSize)
+	for i := 0; i < workers; i++ {
+		go c.work(ctx)
+	}
+}
+
+func (c *Coordinator) work(ctx context.Context) {
+	for j := range c.jobs {
+		j.act(ctx)
+		c.release(j.id)
+	}
+}
+
+// queue will hand job over to worker pool, returning false if queue is full
+func (c *Coordinator) queue(j job) bool {
+	select {
+	case c.jobs <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+// Submit will record saga start and queue it to be executed by worker pool.
+// Saga start is recorded before Submit returns, so its status can be
+// looked up right away. Queued saga lost on crash is resumed by Recover.
+func (c *Coordinator) Submit(sagaType string, input interface{}) (*Execution, error) {
+	if c.jobs == nil {
+		return nil, ErrWorkersNotStarted
+	}
+
+	def, exec, err := c.start(sagaType, input, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if c.queue(job{id: exec.ID, act: func(ctx context.Context) { c.run(ctx, def, exec) }}) {
+		return exec, nil
+	}
+	c.abort(context.Background(), def, exec)
+	c.settle(def, exec)
+	c.release(exec.ID)
+	return nil, ErrQueueFull
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const fileExtension = ".log"
+
+// FileStorage defines durable append-only file saga log storage.
+// Every logID is stored as one file and every record is one line.
+type FileStorage struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewFileStorage will create new file storage inside given directory
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileStorage{
+		dir: dir,
+	}, nil
+}
+
+func (f *FileStorage) path(logID string) string {
+	return filepath.Join(f.dir, logID+fileExtension)
+}
+
+// AppendLog appends data into log under given logID and syncs it to disk
+func (f *FileStorage) AppendLog(logID string, data string) error {
+	if strings.ContainsAny(data, "\r\n") {
+		return ErrInvalidData
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path(logID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err = file.WriteString(data + "\n"); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Lookup returns all data under given logID
+func (f *FileStorage) Lookup(logID string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.read(logID)
+}
+
+func (f *FileStorage) read(logID string) ([]string, error) {
+	file, err := os.Open(f.path(logID))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := []string{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		data = append(data, scanner.Text())
+	}
+	return data, scanner.Err()
+}
+
+// ReadFrom returns data under given logID starting at byte offset pos,
+// and offset after every record. Record still being appended by another
+// process is left for the next read.
+func (f *FileStorage) ReadFrom(logID string, pos int64) ([]string, []int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.Open(f.path(logID))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	if _, err = file.Seek(pos, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	var data []string
+	var next []int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return data, next, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pos += int64(len(line))
+		data = append(data, strings.TrimSuffix(line, "\n"))
+		next = append(next, pos)
+	}
+}
+
+// Close releases resources held by storage
+func (f *FileStorage) Close() error {
+	return nil
+}
+
+// LogIDs returns every existing logID
+func (f *FileStorage) LogIDs() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	files, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != fileExtension {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(file.Name(), fileExtension))
+	}
+	return ids, nil
+}
+
+// Cleanup removes all data under given logID
+func (f *FileStorage) Cleanup(logID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.Remove(f.path(logID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// LastLog returns the latest data under given logID
+func (f *FileStorage) LastLog(logID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := f.read(logID)
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 0 {
+		return "", ErrLogNotFound
+	}
+	return data[len(data)-1], nil
+}
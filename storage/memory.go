@@ -0,0 +1,94 @@
+package storage
+
+import "sync"
+
+// MemoryStorage defines in-memory saga log storage.
+// Logs are lost when process exits, so use it only for tests and local run.
+type MemoryStorage struct {
+	mu   sync.RWMutex
+	logs map[string][]string
+}
+
+// NewMemoryStorage will create new in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		logs: make(map[string][]string),
+	}
+}
+
+// AppendLog appends data into log under given logID
+func (m *MemoryStorage) AppendLog(logID string, data string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.logs[logID] = append(m.logs[logID], data)
+	return nil
+}
+
+// Lookup returns all data under given logID
+func (m *MemoryStorage) Lookup(logID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data := make([]string, len(m.logs[logID]))
+	copy(data, m.logs[logID])
+	return data, nil
+}
+
+// ReadFrom returns data under given logID starting at record pos, and
+// position after every record
+func (m *MemoryStorage) ReadFrom(logID string, pos int64) ([]string, []int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	logs := m.logs[logID]
+	if pos >= int64(len(logs)) {
+		return nil, nil, nil
+	}
+
+	data := make([]string, 0, int64(len(logs))-pos)
+	next := make([]int64, 0, cap(data))
+	for i := pos; i < int64(len(logs)); i++ {
+		data = append(data, logs[i])
+		next = append(next, i+1)
+	}
+	return data, next, nil
+}
+
+// Close releases resources held by storage
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+// LogIDs returns every existing logID
+func (m *MemoryStorage) LogIDs() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.logs))
+	for id := range m.logs {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Cleanup removes all data under given logID
+func (m *MemoryStorage) Cleanup(logID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.logs, logID)
+	return nil
+}
+
+// LastLog returns the latest data under given logID
+func (m *MemoryStorage) LastLog(logID string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data := m.logs[logID]
+	if len(data) == 0 {
+		return "", ErrLogNotFound
+	}
+	return data[len(data)-1], nil
+}
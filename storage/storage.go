@@ -0,0 +1,64 @@
+package storage
+
+import "errors"
+
+// Storage defines saga log storage engine. The method set is identical to
+// go-saga storage interface, so every engine can be plugged into go-saga.
+type Storage interface {
+	// AppendLog appends data into log under given logID
+	AppendLog(logID string, data string) error
+	// Lookup returns all data under given logID
+	Lookup(logID string) ([]string, error)
+	// Close releases resources held by storage
+	Close() error
+	// LogIDs returns every existing logID
+	LogIDs() ([]string, error)
+	// Cleanup removes all data under given logID
+	Cleanup(logID string) error
+	// LastLog returns the latest data under given logID
+	LastLog(logID string) (string, error)
+}
+
+// Reader is implemented by storage which reads a log starting at a
+// position returned by an earlier read, instead of reading it whole.
+// Position is specific to storage engine, and position 0 is the first record.
+type Reader interface {
+	// ReadFrom returns records of log under given logID starting at pos,
+	// and position after every one of them
+	ReadFrom(logID string, pos int64) (data []string, next []int64, err error)
+}
+
+// ReadFrom reads log of s starting at pos, like Reader. Storage which is
+// not a Reader is read whole, counting pos in records.
+func ReadFrom(s Storage, logID string, pos int64) ([]string, []int64, error) {
+	if r, ok := s.(Reader); ok {
+		return r.ReadFrom(logID, pos)
+	}
+
+	data, err := s.Lookup(logID)
+	if err != nil || pos >= int64(len(data)) {
+		return nil, nil, err
+	}
+	data = data[pos:]
+	next := make([]int64, len(data))
+	for i := range data {
+		next[i] = pos + int64(i) + 1
+	}
+	return data, next, nil
+}
+
+const (
+	// EngineKafka defines kafka storage engine provided by go-saga
+	EngineKafka = "kafka"
+	// EngineMemory defines in-memory storage engine
+	EngineMemory = "memory"
+	// EngineFile defines append-only file storage engine
+	EngineFile = "file"
+)
+
+var (
+	// ErrLogNotFound is returned when logID has no data
+	ErrLogNotFound = errors.New("saga log not found")
+	// ErrInvalidData is returned when data can not be stored as single record
+	ErrInvalidData = errors.New("saga log data must not contain new line")
+)
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "saga-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestStorage(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	engines := []struct {
+		name string
+		open func(t *testing.T) Storage
+	}{
+		{
+			name: EngineMemory,
+			open: func(t *testing.T) Storage { return NewMemoryStorage() },
+		},
+		{
+			name: EngineFile,
+			open: func(t *testing.T) Storage {
+				s, err := NewFileStorage(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+		},
+	}
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			s := engine.open(t)
+			defer s.Close()
+
+			if data, err := s.Lookup("missing"); err != nil || len(data) != 0 {
+				t.Errorf("Lookup of missing log = %v, %v, want empty", data, err)
+			}
+			if _, err := s.LastLog("missing"); err != ErrLogNotFound {
+				t.Errorf("LastLog of missing log error = %v, want %v", err, ErrLogNotFound)
+			}
+
+			for _, data := range []string{"first", "second", "third"} {
+				if err := s.AppendLog("saga-1", data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.AppendLog("saga-2", "only"); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := s.Lookup("saga-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"first", "second", "third"}; !reflect.DeepEqual(data, want) {
+				t.Errorf("Lookup = %v, want %v", data, want)
+			}
+			if last, err := s.LastLog("saga-1"); err != nil || last != "third" {
+				t.Errorf("LastLog = %q, %v, want %q", last, err, "third")
+			}
+
+			all, next, err := ReadFrom(s, "saga-1", 0)
+			if err != nil || !reflect.DeepEqual(all, []string{"first", "second", "third"}) || len(next) != 3 {
+				t.Fatalf("ReadFrom(0) = %v, %v, %v", all, next, err)
+			}
+			if rest, _, err := ReadFrom(s, "saga-1", next[0]); err != nil || !reflect.DeepEqual(rest, []string{"second", "third"}) {
+				t.Errorf("ReadFrom after first record = %v, %v, want [second third]", rest, err)
+			}
+			if rest, _, err := ReadFrom(s, "saga-1", next[2]); err != nil || len(rest) != 0 {
+				t.Errorf("ReadFrom after last record = %v, %v, want empty", rest, err)
+			}
+
+			logIDs, err := s.LogIDs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(logIDs)
+			if want := []string{"saga-1", "saga-2"}; !reflect.DeepEqual(logIDs, want) {
+				t.Errorf("LogIDs = %v, want %v", logIDs, want)
+			}
+
+			if err = s.Cleanup("saga-1"); err != nil {
+				t.Fatal(err)
+			}
+			if err = s.Cleanup("saga-1"); err != nil {
+				t.Errorf("Cleanup of removed log error = %v", err)
+			}
+			if logIDs, _ = s.LogIDs(); !reflect.DeepEqual(logIDs, []string{"saga-2"}) {
+				t.Errorf("LogIDs after Cleanup = %v, want [saga-2]", logIDs)
+			}
+		})
+	}
+}
+
+func TestFileStorageReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AppendLog("saga-1", `{"type":"SagaStart"}`); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// a restarted orchestrator sees the log written before
+	s, err = NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last, err := s.LastLog("saga-1"); err != nil || last != `{"type":"SagaStart"}` {
+		t.Errorf("LastLog after reopen = %q, %v", last, err)
+	}
+}
+
+func TestFileStorageInvalidData(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"two\nlines", "carriage\rreturn"} {
+		if err = s.AppendLog("saga-1", data); err != ErrInvalidData {
+			t.Errorf("AppendLog(%q) error = %v, want %v", data, err, ErrInvalidData)
+		}
+	}
+	if data, _ := s.Lookup("saga-1"); len(data) != 0 {
+		t.Errorf("invalid data was stored : %v", data)
+	}
+}
+
+func TestFileStorageReadFromPartialRecord(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AppendLog("events", "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// another process is still appending the second record
+	f, err := os.OpenFile(s.path("events"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("sec")
+	f.Close()
+
+	data, next, err := s.ReadFrom("events", 0)
+	if err != nil || !reflect.DeepEqual(data, []string{"first"}) {
+		t.Fatalf("ReadFrom = %v, %v, want [first]", data, err)
+	}
+	if data, _, _ = s.ReadFrom("events", next[0]); len(data) != 0 {
+		t.Errorf("partial record is read : %v", data)
+	}
+}
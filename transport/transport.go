@@ -0,0 +1,11 @@
+package transport
+
+import "context"
+
+// Transport sends request to an endpoint of participant service and
+// decodes its response. Endpoint is named by its HTTP path, whichever
+// protocol carries the request.
+type Transport interface {
+	Post(ctx context.Context, path string, in, out interface{}) error
+	Get(ctx context.Context, path string, out interface{}) error
+}
@@ -0,0 +1,17 @@
+package uid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// New will generate random 16 hex digits ID, such as ID of saga, broker
+// message or event
+func New() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID : %s", err.Error())
+	}
+	return hex.EncodeToString(b), nil
+}